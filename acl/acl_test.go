@@ -103,3 +103,49 @@ func TestParsing(t *testing.T) {
 		t.Errorf("Expeced 1 mask entries, got %d", len(acl.Mask))
 	}
 }
+
+func TestXattrRoundtrip(t *testing.T) {
+	names := map[uint32]string{1000: "alice", 1001: "bob", 100: "users"}
+	ids := map[string]uint32{"alice": 1000, "bob": 1001, "users": 100}
+	toName := func(id uint32) string { return names[id] }
+	toId := func(name string) (uint32, error) { return ids[name], nil }
+
+	acl, err := ParseACL("u::rwx,u:bob:r--,u:alice:rw-,g::r-x,g:users:rwx,o::---,m::rwx")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	b, err := acl.ToXattr(toId, toId)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	want := []byte{
+		2, 0, 0, 0,
+		0x01, 0, 7, 0, 0xff, 0xff, 0xff, 0xff,
+		0x02, 0, 6, 0, 0xe8, 0x03, 0, 0,
+		0x02, 0, 4, 0, 0xe9, 0x03, 0, 0,
+		0x04, 0, 5, 0, 0xff, 0xff, 0xff, 0xff,
+		0x08, 0, 7, 0, 100, 0, 0, 0,
+		0x10, 0, 7, 0, 0xff, 0xff, 0xff, 0xff,
+		0x20, 0, 0, 0, 0xff, 0xff, 0xff, 0xff,
+	}
+	if string(b) != string(want) {
+		t.Errorf("Unexpected encoding: %v", b)
+	}
+
+	have, err := FromXattr(b, toName, toName)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !have.Equals(acl) {
+		t.Errorf("Unexpected decoding result: %s", have)
+	}
+	if !have.IsExtended() || ACLFromUnixPerms(0644).IsExtended() {
+		t.Errorf("IsExtended() failed")
+	}
+
+	if _, err := FromXattr(b[:10], toName, toName); err == nil {
+		t.Errorf("Truncated xattr was decoded")
+	}
+}
@@ -0,0 +1,136 @@
+package acl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// The binary format used by Linux for the system.posix_acl_access and system.posix_acl_default extended attributes:
+// A little endian uint32 version, followed by entries consisting of a uint16 tag, uint16 permissions and uint32 id.
+
+const xattrVersion = 2
+
+const (
+	xattrUserObj  = 0x01
+	xattrUser     = 0x02
+	xattrGroupObj = 0x04
+	xattrGroup    = 0x08
+	xattrMask     = 0x10
+	xattrOther    = 0x20
+)
+
+const xattrUndefinedId = 0xffffffff
+
+// IsExtended checks, if the ACL can not be represented by the unix permission bits alone
+func (acl ACL) IsExtended() bool {
+	return len(acl.User) > 1 || len(acl.Group) > 1 || len(acl.Mask) > 0
+}
+
+// IsEmpty checks, if the ACL has no entries at all
+func (acl ACL) IsEmpty() bool {
+	return len(acl.User) == 0 && len(acl.Group) == 0 && len(acl.Other) == 0 && len(acl.Mask) == 0
+}
+
+// FromXattr decodes an ACL stored in an extended attribute. The ids of named entries are converted to names
+// using the userName and groupName functions.
+func FromXattr(b []byte, userName, groupName func(uint32) string) (ACL, error) {
+	acl := ACL{}
+	acl.Init()
+
+	if len(b) < 4 || (len(b)-4)%8 != 0 {
+		return acl, fmt.Errorf("invalid acl xattr: unexpected length %d", len(b))
+	}
+	if v := binary.LittleEndian.Uint32(b); v != xattrVersion {
+		return acl, fmt.Errorf("invalid acl xattr: unsupported version %d", v)
+	}
+
+	for b = b[4:]; len(b) > 0; b = b[8:] {
+		tag := binary.LittleEndian.Uint16(b)
+		perm := Perm(binary.LittleEndian.Uint16(b[2:]) & 7)
+		id := binary.LittleEndian.Uint32(b[4:])
+
+		switch tag {
+		case xattrUserObj:
+			acl.User[""] = perm
+		case xattrUser:
+			acl.User[userName(id)] = perm
+		case xattrGroupObj:
+			acl.Group[""] = perm
+		case xattrGroup:
+			acl.Group[groupName(id)] = perm
+		case xattrMask:
+			acl.Mask[""] = perm
+		case xattrOther:
+			acl.Other[""] = perm
+		default:
+			return acl, fmt.Errorf("invalid acl xattr: unknown tag 0x%x", tag)
+		}
+	}
+
+	return acl, nil
+}
+
+type xattrEntry struct {
+	tag  uint16
+	perm Perm
+	id   uint32
+}
+
+func appendXattrEntries(entries []xattrEntry, perms QualifiedPerms, obj_tag, named_tag uint16, toId func(string) (uint32, error)) ([]xattrEntry, error) {
+	if perm, ok := perms[""]; ok {
+		entries = append(entries, xattrEntry{obj_tag, perm, xattrUndefinedId})
+	}
+
+	named := []xattrEntry{}
+	for name, perm := range perms {
+		if name == "" {
+			continue
+		}
+		if toId == nil {
+			return nil, fmt.Errorf("named entry %s not allowed here", name)
+		}
+
+		id, err := toId(name)
+		if err != nil {
+			return nil, err
+		}
+		named = append(named, xattrEntry{named_tag, perm, id})
+	}
+
+	// The kernel expects named entries to be sorted by their id
+	sort.Slice(named, func(i, j int) bool { return named[i].id < named[j].id })
+	return append(entries, named...), nil
+}
+
+// ToXattr encodes the ACL for storing it in an extended attribute. The names of named entries are converted to ids
+// using the userId and groupId functions.
+func (acl ACL) ToXattr(userId, groupId func(string) (uint32, error)) ([]byte, error) {
+	entries := []xattrEntry{}
+	var err error
+
+	if entries, err = appendXattrEntries(entries, acl.User, xattrUserObj, xattrUser, userId); err != nil {
+		return nil, err
+	}
+	if entries, err = appendXattrEntries(entries, acl.Group, xattrGroupObj, xattrGroup, groupId); err != nil {
+		return nil, err
+	}
+	if entries, err = appendXattrEntries(entries, acl.Mask, xattrMask, 0, nil); err != nil {
+		return nil, err
+	}
+	if entries, err = appendXattrEntries(entries, acl.Other, xattrOther, 0, nil); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 4, 4+8*len(entries))
+	binary.LittleEndian.PutUint32(b, xattrVersion)
+	for _, e := range entries {
+		var buf [8]byte
+		binary.LittleEndian.PutUint16(buf[:], e.tag)
+		binary.LittleEndian.PutUint16(buf[2:], uint16(e.perm))
+		binary.LittleEndian.PutUint32(buf[4:], e.id)
+		b = append(b, buf[:]...)
+	}
+
+	return b, nil
+}
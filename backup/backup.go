@@ -7,6 +7,7 @@ import (
 	"code.laria.me/petrific/objects"
 	"code.laria.me/petrific/storage"
 	"io"
+	"path"
 	"runtime"
 	"sort"
 	"time"
@@ -43,7 +44,7 @@ func (task writeFileTask) process(proc writeDirProcess) {
 	}
 	defer rwc.Close()
 
-	result.file_id, result.err = WriteFile(proc.store, rwc)
+	result.file_id, result.err = WriteFileChunked(proc.store, rwc, proc.opts.Chunking)
 
 	proc.log.Info().Printf("finished writing file %s", task.file.Name())
 
@@ -54,6 +55,31 @@ type writeDirProcess struct {
 	queue chan writeFileTask
 	store storage.Storage
 	log   *logging.Log
+	opts  WriteDirOptions
+}
+
+// WriteDirOptions control details of WriteDir. The zero value is a sensible default.
+type WriteDirOptions struct {
+	Chunking Chunking // How files are split into blobs, nil means DefaultChunking
+
+	// Paths to exclude. The paths are relative to the backed up directory, "/" being the directory itself.
+	Exclude IgnoreRules
+
+	// Name of files (e.g. DefaultIgnoreFileName) containing additional exclude rules for their directory and its
+	// subdirectories. Empty disables ignore files.
+	IgnoreFile string
+
+	// Exclude the content of cache directories (tagged with a CACHEDIR.TAG file). The tag file itself is kept.
+	ExcludeCaches bool
+
+	// Exclude files larger than this many bytes, 0 means no limit
+	MaxFileSize int64
+
+	// Don't descend into directories on other filesystems (mount points are backed up as empty directories).
+	// Directories with an absolute path listed in AllowedMounts are still backed up, including everything
+	// on the same filesystem below them.
+	OneFileSystem bool
+	AllowedMounts []string
 }
 
 func (proc writeDirProcess) worker() {
@@ -78,11 +104,17 @@ func WriteDir(
 	d fs.Dir,
 	pcache cache.Cache,
 	log *logging.Log,
+	opts WriteDirOptions,
 ) (objects.ObjectId, error) {
+	if opts.Chunking == nil {
+		opts.Chunking = DefaultChunking
+	}
+
 	proc := writeDirProcess{
 		make(chan writeFileTask),
 		store,
 		log,
+		opts,
 	}
 	defer proc.stop()
 
@@ -90,16 +122,80 @@ func WriteDir(
 		go proc.worker()
 	}
 
-	return proc.writeDir(abspath, d, pcache)
+	return proc.writeDir(abspath, "/", d, pcache, opts.Exclude, d.Device())
+}
+
+// crossesMount checks, if the subdirectory child should not be backed up, because it is on another filesystem.
+// It returns the device of the child, which is the allowed device for everything below it.
+func (proc writeDirProcess) crossesMount(child fs.Dir, abspath string, dev uint64) (bool, uint64) {
+	if !proc.opts.OneFileSystem {
+		return false, dev
+	}
+
+	child_dev := child.Device()
+	if child_dev == dev {
+		return false, dev
+	}
+
+	for _, allowed := range proc.opts.AllowedMounts {
+		if path.Clean(allowed) == abspath {
+			return false, child_dev
+		}
+	}
+
+	return true, dev
+}
+
+// excluded checks, if the child c of the directory at relpath should not be backed up
+func (proc writeDirProcess) excluded(c fs.File, relpath string, rules IgnoreRules, is_cache bool) bool {
+	child_relpath := path.Join(relpath, c.Name())
+
+	if rules.Ignored(child_relpath, c.Type() == fs.FDir) {
+		proc.log.Info().Printf("excluding %s", child_relpath)
+		return true
+	}
+
+	if is_cache && c.Name() != cacheDirTagName {
+		proc.log.Debug().Printf("excluding %s, it is in a cache directory", child_relpath)
+		return true
+	}
+
+	if proc.opts.MaxFileSize > 0 && c.Type() == fs.FFile && c.(fs.RegularFile).Size() > proc.opts.MaxFileSize {
+		proc.log.Info().Printf("excluding %s, it is larger than %d bytes", child_relpath, proc.opts.MaxFileSize)
+		return true
+	}
+
+	return false
 }
 
 func (proc writeDirProcess) writeDir(
 	abspath string,
+	relpath string,
 	d fs.Dir,
 	pcache cache.Cache,
+	rules IgnoreRules,
+	dev uint64,
 ) (objects.ObjectId, error) {
 	proc.log.Info().Printf("start writeDir for %s", abspath)
 
+	if proc.opts.IgnoreFile != "" {
+		more_rules, err := readIgnoreFile(d, proc.opts.IgnoreFile, relpath)
+		if err != nil {
+			return objects.ObjectId{}, err
+		}
+		if len(more_rules) > 0 {
+			rules = append(append(IgnoreRules{}, rules...), more_rules...)
+		}
+	}
+
+	is_cache := false
+	if proc.opts.ExcludeCaches {
+		var err error
+		if is_cache, err = isCacheDir(d); err != nil {
+			return objects.ObjectId{}, err
+		}
+	}
+
 	_children, err := d.Readdir()
 	if err != nil {
 		return objects.ObjectId{}, err
@@ -114,17 +210,28 @@ func (proc writeDirProcess) writeDir(
 
 	infos := make(objects.Tree)
 	for _, c := range children {
+		if proc.excluded(c, relpath, rules, is_cache) {
+			continue
+		}
+
 		proc.log.Info().Printf("processing %s (%s) in %s", c.Name(), c.Type(), abspath)
 
 		var info objects.TreeEntry = nil
 
 		switch c.Type() {
 		case fs.FFile:
-			mtime, file_id, ok := pcache.PathUpdated(abspath + "/" + c.Name())
-			proc.log.Debug().Printf("cache info for %s: %s, %s, %t", abspath+"/"+c.Name(), mtime, file_id, ok)
+			mtime, file_id, ok := pcache.PathUpdated(path.Join(abspath, c.Name()))
+			proc.log.Debug().Printf("cache info for %s: %s, %s, %t", path.Join(abspath, c.Name()), mtime, file_id, ok)
+
+			if ok && !mtime.Before(c.ModTime()) {
+				// The cached file object might have been deleted by a prune in the meantime
+				if ok, err = proc.store.Has(file_id); err != nil {
+					return objects.ObjectId{}, err
+				}
+			}
 
 			if !ok || mtime.Before(c.ModTime()) {
-				// According to cache the file was changed
+				// According to cache the file was changed (or we can't use the cached object)
 
 				proc.enqueue(c.(fs.RegularFile), &file_results)
 				wait_for_files++
@@ -132,7 +239,15 @@ func (proc writeDirProcess) writeDir(
 				info = objects.NewTreeEntryFile(file_id, c.Executable())
 			}
 		case fs.FDir:
-			subtree_id, err := proc.writeDir(abspath+"/"+c.Name(), c.(fs.Dir), pcache)
+			child_abspath := path.Join(abspath, c.Name())
+
+			var subtree_id objects.ObjectId
+			if crosses, child_dev := proc.crossesMount(c.(fs.Dir), child_abspath, dev); crosses {
+				proc.log.Info().Printf("not descending into %s, it is on another filesystem", child_abspath)
+				subtree_id, err = storage.SetObject(proc.store, objects.ToRawObject(objects.Tree{}))
+			} else {
+				subtree_id, err = proc.writeDir(child_abspath, path.Join(relpath, c.Name()), c.(fs.Dir), pcache, rules, child_dev)
+			}
 			if err != nil {
 				return objects.ObjectId{}, err
 			}
@@ -145,10 +260,15 @@ func (proc writeDirProcess) writeDir(
 			}
 
 			info = objects.NewTreeEntrySymlink(target, c.Executable())
+		case fs.FBlockDev, fs.FCharDev, fs.FFifo, fs.FSocket:
+			major, minor := c.(fs.Special).DeviceNumber()
+			info = objects.NewTreeEntrySpecial(objects.TreeEntryType(c.Type()), major, minor)
+		default:
+			proc.log.Warn().Printf("skipping %s/%s, it has an unsupported type", abspath, c.Name())
 		}
 
 		if info != nil {
-			infos[c.Name()] = info
+			infos[c.Name()] = proc.withMetadata(info, c, path.Join(abspath, c.Name()))
 		}
 	}
 
@@ -160,8 +280,9 @@ func (proc writeDirProcess) writeDir(
 			break
 		}
 
-		pcache.SetPathUpdated(abspath+"/"+result.file.Name(), result.file.ModTime(), result.file_id)
-		infos[result.file.Name()] = objects.NewTreeEntryFile(result.file_id, result.file.Executable())
+		pcache.SetPathUpdated(path.Join(abspath, result.file.Name()), result.file.ModTime(), result.file_id)
+		info := objects.NewTreeEntryFile(result.file_id, result.file.Executable())
+		infos[result.file.Name()] = proc.withMetadata(info, result.file, path.Join(abspath, result.file.Name()))
 	}
 
 	for ; wait_for_files > 0; wait_for_files-- {
@@ -179,32 +300,55 @@ func (proc writeDirProcess) writeDir(
 
 const BlobChunkSize = 16 * 1024 * 1024 // 16MB
 
+// WriteFile writes a file using DefaultChunking
 func WriteFile(store storage.Storage, r io.Reader) (objects.ObjectId, error) {
-	// Right now we will create the file as fixed chunks of size BlobChunkSize
-	// It Would be more efficient to use a dynamic/content aware chunking method but this can be added later.
+	return WriteFileChunked(store, r, DefaultChunking)
+}
+
+// WriteFileChunked writes the content of r as blobs (split by chunking) and a file object tying them together
+func WriteFileChunked(store storage.Storage, r io.Reader, chunking Chunking) (objects.ObjectId, error) {
 	// The way files are serialized allows for any chunk size and addition of more properties in the future while staying compatible
 
-	fragments := make(objects.File, 0)
+	if sr, ok := r.(fs.SparseReader); ok {
+		regions, err := sr.Regions()
+		if err != nil {
+			return objects.ObjectId{}, err
+		}
 
-	read_buf := make([]byte, BlobChunkSize)
-	for {
-		n, err := io.ReadFull(r, read_buf)
-		if err == nil || err == io.ErrUnexpectedEOF {
-			content := objects.Blob(read_buf[:n])
-			blob_id, err := storage.SetObject(store, objects.ToRawObject(&content))
-			if err != nil {
-				return objects.ObjectId{}, err
-			}
+		if regions = mergeSmallHoles(regions); hasHoles(regions) {
+			return writeSparseFile(store, sr, regions, chunking)
+		}
+	}
+
+	fragments, err := writeFragments(store, make(objects.File, 0), r, chunking)
+	if err != nil {
+		return objects.ObjectId{}, err
+	}
+
+	return storage.SetObject(store, objects.ToRawObject(&fragments))
+}
 
-			fragments = append(fragments, objects.FileFragment{Blob: blob_id, Size: uint64(n)})
-		} else if err == io.EOF {
+// writeFragments stores the content of r as blobs and appends the fragments to fragments
+func writeFragments(store storage.Storage, fragments objects.File, r io.Reader, chunking Chunking) (objects.File, error) {
+	chunker := chunking.NewChunker(r)
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
 			break
-		} else {
-			return objects.ObjectId{}, err
+		} else if err != nil {
+			return nil, err
 		}
+
+		content := objects.Blob(chunk)
+		blob_id, err := storage.SetObject(store, objects.ToRawObject(&content))
+		if err != nil {
+			return nil, err
+		}
+
+		fragments = append(fragments, objects.FileFragment{Blob: blob_id, Size: uint64(len(chunk))})
 	}
 
-	return storage.SetObject(store, objects.ToRawObject(&fragments))
+	return fragments, nil
 }
 
 func CreateSnapshot(
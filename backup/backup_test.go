@@ -8,6 +8,7 @@ import (
 	"code.laria.me/petrific/objects"
 	"code.laria.me/petrific/storage"
 	"code.laria.me/petrific/storage/memory"
+	"path"
 	"testing"
 )
 
@@ -81,7 +82,7 @@ func TestWriteDir(t *testing.T) {
 		t.Fatalf("Failed creating dir: %s", err)
 	}
 
-	id, err := WriteDir(s, "", root, cache.NopCache{}, logging.NewNopLog())
+	id, err := WriteDir(s, "", root, cache.NopCache{}, logging.NewNopLog(), WriteDirOptions{})
 	if err != nil {
 		t.Fatalf("Could not WriteDir: %s", err)
 	}
@@ -97,3 +98,112 @@ func TestWriteDir(t *testing.T) {
 	wantObject(t, s, objid_subtree, obj_subtree)
 	wantObject(t, s, objid_testtree, obj_testtree)
 }
+
+func wantTreeEntries(t *testing.T, s storage.Storage, id objects.ObjectId, p string, want ...string) {
+	entry, err := LookupPath(s, id, p)
+	if err != nil {
+		t.Fatalf("LookupPath(%s) failed: %s", p, err)
+	}
+
+	tree_obj, err := storage.GetObjectOfType(s, entry.(objects.TreeEntryDir).Ref, objects.OTTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := tree_obj.(objects.Tree)
+
+	if len(tree) != len(want) {
+		t.Errorf("%s: Expected %d entries, got %d", p, len(want), len(tree))
+	}
+	for _, name := range want {
+		if _, ok := tree[name]; !ok {
+			t.Errorf("%s: Missing entry %s", p, name)
+		}
+	}
+}
+
+func TestWriteDirExclude(t *testing.T) {
+	s := memory.NewMemoryStorage()
+
+	root := fs.NewMemoryFSRoot("root")
+	mkfile(t, root, "keep.txt", false, []byte("keep"))
+	mkfile(t, root, "debug.log", false, []byte("log"))
+	mkfile(t, root, "large", false, make([]byte, 1000))
+	mkfile(t, root, DefaultIgnoreFileName, false, []byte("# comment\n/build/\n"))
+
+	sub, _ := root.CreateChildDir("sub")
+	mkfile(t, sub, "important.log", false, []byte("log"))
+	mkfile(t, sub, "build", false, []byte("a file, not the build dir"))
+	mkfile(t, sub, DefaultIgnoreFileName, false, []byte("!important.log\n"))
+	modules, _ := sub.CreateChildDir("node_modules")
+	mkfile(t, modules, "foo.js", false, []byte("foo"))
+
+	build, _ := root.CreateChildDir("build")
+	mkfile(t, build, "out", false, []byte("out"))
+
+	cachedir, _ := root.CreateChildDir("cache")
+	mkfile(t, cachedir, cacheDirTagName, false, append(cacheDirSignature, "\n# a cache"...))
+	mkfile(t, cachedir, "cached", false, []byte("cached"))
+
+	rules, err := ParseIgnoreRules("/", []string{"*.log", "node_modules/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := WriteDir(s, "", root, cache.NopCache{}, logging.NewNopLog(), WriteDirOptions{
+		Exclude:       rules,
+		IgnoreFile:    DefaultIgnoreFileName,
+		ExcludeCaches: true,
+		MaxFileSize:   100,
+	})
+	if err != nil {
+		t.Fatalf("Could not WriteDir: %s", err)
+	}
+
+	wantTreeEntries(t, s, id, "/", "keep.txt", DefaultIgnoreFileName, "sub", "cache")
+	wantTreeEntries(t, s, id, "/sub", "important.log", "build", DefaultIgnoreFileName)
+	wantTreeEntries(t, s, id, "/cache", cacheDirTagName)
+}
+
+func TestWriteDirOneFileSystem(t *testing.T) {
+	s := memory.NewMemoryStorage()
+
+	root := fs.NewMemoryFSRoot("root")
+	mkfile(t, root, "foo", false, []byte("foo"))
+
+	proc, _ := root.CreateChildDir("proc")
+	proc.(*fs.MemfsDir).SetDevice(1)
+	mkfile(t, proc, "cpuinfo", false, []byte("cpu"))
+
+	home, _ := root.CreateChildDir("home")
+	home.(*fs.MemfsDir).SetDevice(2)
+	user, _ := home.CreateChildDir("user")
+	mkfile(t, user, "file", false, []byte("file"))
+	nfs, _ := user.CreateChildDir("nfs")
+	nfs.(*fs.MemfsDir).SetDevice(3)
+	mkfile(t, nfs, "remote", false, []byte("remote"))
+
+	// Allowed mounts are absolute paths, so the result must not depend on where the backed up directory is
+	for _, abspath := range []string{"/", "/srv"} {
+		id, err := WriteDir(s, abspath, root, cache.NopCache{}, logging.NewNopLog(), WriteDirOptions{
+			OneFileSystem: true,
+			AllowedMounts: []string{path.Join(abspath, "home") + "/"},
+		})
+		if err != nil {
+			t.Fatalf("Could not WriteDir %s: %s", abspath, err)
+		}
+
+		wantTreeEntries(t, s, id, "/", "foo", "proc", "home")
+		wantTreeEntries(t, s, id, "/proc")
+		wantTreeEntries(t, s, id, "/home/user", "file", "nfs")
+		wantTreeEntries(t, s, id, "/home/user/nfs")
+	}
+
+	// Without OneFileSystem, everything gets backed up
+	id, err := WriteDir(s, "/", root, cache.NopCache{}, logging.NewNopLog(), WriteDirOptions{})
+	if err != nil {
+		t.Fatalf("Could not WriteDir: %s", err)
+	}
+
+	wantTreeEntries(t, s, id, "/proc", "cpuinfo")
+	wantTreeEntries(t, s, id, "/home/user/nfs", "remote")
+}
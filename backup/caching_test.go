@@ -21,7 +21,7 @@ func TestCacheMTime(t *testing.T) {
 	}
 
 	want := file.ModTime()
-	if _, err := WriteDir(st, "/foo", filesys, c, logging.NewNopLog()); err != nil {
+	if _, err := WriteDir(st, "/foo", filesys, c, logging.NewNopLog(), WriteDirOptions{}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -53,7 +53,7 @@ func TestCacheRetrieve(t *testing.T) {
 	mtime := file.ModTime().Add(1 * time.Hour)
 	c.SetPathUpdated("/foo/bar", mtime, objid_emptyfile)
 
-	if _, err := WriteDir(st, "/foo", filesys, c, logging.NewNopLog()); err != nil {
+	if _, err := WriteDir(st, "/foo", filesys, c, logging.NewNopLog(), WriteDirOptions{}); err != nil {
 		t.Fatal(err)
 	}
 
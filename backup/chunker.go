@@ -0,0 +1,217 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// Chunker splits the content of a file into chunks, which will then be stored as blobs
+type Chunker interface {
+	// Next returns the next chunk or io.EOF, if there is nothing left.
+	// The returned slice is only valid until the next call of Next.
+	Next() ([]byte, error)
+}
+
+// Chunking describes a method of splitting files into chunks
+type Chunking interface {
+	NewChunker(r io.Reader) Chunker
+}
+
+// FixedChunking splits files into chunks of a fixed size (the last chunk might be smaller)
+type FixedChunking int
+
+// DefaultChunking is used, if no other chunking method is configured. It is also the method used by older versions.
+var DefaultChunking Chunking = FixedChunking(BlobChunkSize)
+
+type fixedChunker struct {
+	r   io.Reader
+	buf []byte
+}
+
+func (size FixedChunking) NewChunker(r io.Reader) Chunker {
+	return &fixedChunker{r: r, buf: make([]byte, int(size))}
+}
+
+func (c *fixedChunker) Next() ([]byte, error) {
+	n, err := io.ReadFull(c.r, c.buf)
+	if err == nil || err == io.ErrUnexpectedEOF {
+		return c.buf[:n], nil
+	}
+	return nil, err
+}
+
+// FastCDCChunking splits files into chunks of varying sizes using the FastCDC algorithm.
+// The chunk boundaries depend on the content, so inserting or removing data from a file
+// only changes the chunks around the modification, all other chunks can be deduplicated.
+//
+// Changing the parameters changes (nearly) all chunk boundaries, so they should be chosen once and then left alone.
+type FastCDCChunking struct {
+	MinSize, AvgSize, MaxSize int
+
+	maskS, maskL uint64
+}
+
+const (
+	DefaultFastCDCMinSize = 512 * 1024      // 512KB
+	DefaultFastCDCAvgSize = 1024 * 1024     // 1MB
+	DefaultFastCDCMaxSize = 8 * 1024 * 1024 // 8MB
+)
+
+// NewFastCDCChunking creates a FastCDCChunking. Sizes that are 0 are replaced by their defaults.
+func NewFastCDCChunking(min, avg, max int) (FastCDCChunking, error) {
+	if min == 0 {
+		min = DefaultFastCDCMinSize
+	}
+	if avg == 0 {
+		avg = DefaultFastCDCAvgSize
+	}
+	if max == 0 {
+		max = DefaultFastCDCMaxSize
+	}
+
+	if min < 64 || min >= avg || avg >= max {
+		return FastCDCChunking{}, fmt.Errorf("invalid chunk sizes (min=%d, avg=%d, max=%d): need 64 <= min < avg < max", min, avg, max)
+	}
+
+	// We use "normalized chunking" with a normalization level of 2:
+	// Before reaching the average size, it is harder to find a cut point, afterwards it gets easier.
+	avg_bits := uint(bits.Len(uint(avg)) - 1)
+
+	return FastCDCChunking{
+		MinSize: min,
+		AvgSize: avg,
+		MaxSize: max,
+		maskS:   fastCDCMask(avg_bits + 2),
+		maskL:   fastCDCMask(avg_bits - 2),
+	}, nil
+}
+
+// fastCDCMask creates a mask with the n most significant bits set.
+// Since the gear hash shifts to the left, the upper bits depend on the most bytes.
+func fastCDCMask(n uint) uint64 {
+	if n > 64 {
+		n = 64
+	}
+	return ^uint64(0) << (64 - n)
+}
+
+// cut finds the length of the next chunk at the beginning of data.
+// data must contain at least MaxSize bytes, unless it is the end of the file.
+func (p FastCDCChunking) cut(data []byte) int {
+	n := len(data)
+	if n <= p.MinSize {
+		return n
+	}
+	if n > p.MaxSize {
+		n = p.MaxSize
+	}
+
+	normal := p.AvgSize
+	if n < normal {
+		normal = n
+	}
+
+	var fp uint64
+	i := p.MinSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&p.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&p.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+type fastCDCChunker struct {
+	params     FastCDCChunking
+	r          io.Reader
+	buf        []byte
+	start, end int
+	eof        bool
+}
+
+func (p FastCDCChunking) NewChunker(r io.Reader) Chunker {
+	return &fastCDCChunker{
+		params: p,
+		r:      r,
+		buf:    make([]byte, p.MaxSize),
+	}
+}
+
+func (c *fastCDCChunker) fill() error {
+	// Move remaining data to the beginning of the buffer
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+
+	for !c.eof && c.end < len(c.buf) {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if err == io.EOF {
+			c.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *fastCDCChunker) Next() ([]byte, error) {
+	if c.end-c.start < c.params.MaxSize && !c.eof {
+		if err := c.fill(); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	n := c.params.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// ParseChunking creates a Chunking from a method name ("fixed" or "fastcdc") and sizes.
+// For "fixed", only avg is used as the chunk size. Sizes that are 0 are replaced by defaults.
+// An empty method name results in DefaultChunking.
+func ParseChunking(method string, min, avg, max int) (Chunking, error) {
+	switch method {
+	case "":
+		return DefaultChunking, nil
+	case "fixed":
+		if avg == 0 {
+			return DefaultChunking, nil
+		} else if avg < 0 {
+			return nil, fmt.Errorf("invalid chunk size %d", avg)
+		}
+		return FixedChunking(avg), nil
+	case "fastcdc":
+		return NewFastCDCChunking(min, avg, max)
+	default:
+		return nil, fmt.Errorf("unknown chunking method \"%s\"", method)
+	}
+}
+
+// gearTable contains 256 pseudo random numbers used for the gear hash of FastCDC.
+// It must never change, otherwise chunks can no longer be deduplicated against existing ones.
+var gearTable = func() (table [256]uint64) {
+	// splitmix64 with a fixed seed
+	state := uint64(0x7065747269666963) // "petrific"
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return
+}()
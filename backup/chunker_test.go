@@ -0,0 +1,132 @@
+package backup
+
+import (
+	"bytes"
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"code.laria.me/petrific/storage/memory"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func randomData(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func chunkSizes(t *testing.T, chunking Chunking, data []byte) (sizes []int) {
+	chunker := chunking.NewChunker(bytes.NewReader(data))
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			t.Fatalf("Unexpected error from Next(): %s", err)
+		}
+		sizes = append(sizes, len(chunk))
+	}
+}
+
+func TestFastCDCChunkSizes(t *testing.T) {
+	chunking, err := NewFastCDCChunking(1024, 4096, 16384)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := randomData(1, 1<<20)
+	sizes := chunkSizes(t, chunking, data)
+
+	total := 0
+	for i, size := range sizes {
+		total += size
+		if size > 16384 || (size < 1024 && i != len(sizes)-1) {
+			t.Errorf("chunk %d has size %d", i, size)
+		}
+	}
+
+	if total != len(data) {
+		t.Errorf("chunks have a total size of %d, want %d", total, len(data))
+	}
+
+	if avg := total / len(sizes); avg < 2048 || avg > 8192 {
+		t.Errorf("unexpected average chunk size %d", avg)
+	}
+}
+
+func TestFastCDCInvalidSizes(t *testing.T) {
+	for _, sizes := range [][3]int{{10, 4096, 16384}, {4096, 4096, 16384}, {1024, 16384, 4096}} {
+		if _, err := NewFastCDCChunking(sizes[0], sizes[1], sizes[2]); err == nil {
+			t.Errorf("NewFastCDCChunking%v succeeded unexpectedly", sizes)
+		}
+	}
+}
+
+func blobIds(t *testing.T, st storage.Storage, id objects.ObjectId) map[string]struct{} {
+	obj, err := storage.GetObjectOfType(st, id, objects.OTFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make(map[string]struct{})
+	for _, fragment := range *obj.(*objects.File) {
+		ids[fragment.Blob.String()] = struct{}{}
+	}
+	return ids
+}
+
+func TestFastCDCWriteAndRestore(t *testing.T) {
+	chunking, err := NewFastCDCChunking(1024, 4096, 16384)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := memory.NewMemoryStorage()
+	data := randomData(2, 1<<20)
+
+	id, err := WriteFileChunked(st, bytes.NewReader(data), chunking)
+	if err != nil {
+		t.Fatalf("Unexpected error from WriteFileChunked: %s", err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := RestoreFile(st, id, buf); err != nil {
+		t.Fatalf("Unexpected error from RestoreFile: %s", err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("restored data differs from written data")
+	}
+
+	// Inserting data at the beginning should only affect the first few chunks
+	modified := append([]byte("Hello, World!"), data...)
+	modified_id, err := WriteFileChunked(st, bytes.NewReader(modified), chunking)
+	if err != nil {
+		t.Fatalf("Unexpected error from WriteFileChunked: %s", err)
+	}
+
+	orig_blobs := blobIds(t, st, id)
+	modified_blobs := blobIds(t, st, modified_id)
+
+	shared := 0
+	for blob := range modified_blobs {
+		if _, ok := orig_blobs[blob]; ok {
+			shared++
+		}
+	}
+
+	if shared < len(orig_blobs)-2 {
+		t.Errorf("only %d of %d blobs are shared after modification", shared, len(orig_blobs))
+	}
+}
+
+func TestFixedChunkingEmpty(t *testing.T) {
+	if sizes := chunkSizes(t, FixedChunking(16), []byte{}); len(sizes) != 0 {
+		t.Errorf("got chunks for empty input: %v", sizes)
+	}
+
+	if sizes := chunkSizes(t, FixedChunking(16), make([]byte, 40)); len(sizes) != 3 || sizes[2] != 8 {
+		t.Errorf("unexpected chunk sizes: %v", sizes)
+	}
+}
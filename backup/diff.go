@@ -0,0 +1,174 @@
+package backup
+
+import (
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"path"
+	"sort"
+)
+
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"
+	ChangeRemoved  ChangeKind = "removed"
+	ChangeModified ChangeKind = "modified" // Content of a file changed
+	ChangeType     ChangeKind = "type"     // Type of the entry changed (e.g. file => dir)
+	ChangeMetadata ChangeKind = "metadata" // Permissions, owner, other metadata or hardlink changed
+	ChangeTarget   ChangeKind = "target"   // Target of a symlink changed
+)
+
+// Change describes a difference between two trees found by DiffTrees.
+// Old is nil for added entries, New is nil for removed entries.
+type Change struct {
+	Path     string
+	Kind     ChangeKind
+	Old, New objects.TreeEntry
+}
+
+type diffProcess struct {
+	store storage.Storage
+	fn    func(Change) error
+}
+
+// DiffTrees compares the trees old and new and calls fn for every change.
+// A path can have multiple changes (e.g. a file with a new content and a changed ACL), a type change is reported
+// as the only change of a path. Subtrees with identical IDs are skipped, added and removed directories are
+// reported with their whole content.
+func DiffTrees(s storage.Storage, old, new objects.ObjectId, fn func(Change) error) error {
+	proc := diffProcess{s, fn}
+	return proc.diffTrees(old, new, "/")
+}
+
+func (proc diffProcess) getTree(id objects.ObjectId) (objects.Tree, error) {
+	tree, err := storage.GetObjectOfType(proc.store, id, objects.OTTree)
+	if err != nil {
+		return nil, err
+	}
+	return tree.(objects.Tree), nil
+}
+
+func sortedNames(trees ...objects.Tree) []string {
+	set := make(map[string]struct{})
+	for _, tree := range trees {
+		for name := range tree {
+			set[name] = struct{}{}
+		}
+	}
+
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// reportAll reports an entry and (if it is a directory) everything below it as added or removed
+func (proc diffProcess) reportAll(entry objects.TreeEntry, p string, kind ChangeKind) error {
+	change := Change{Path: p, Kind: kind}
+	if kind == ChangeAdded {
+		change.New = entry
+	} else {
+		change.Old = entry
+	}
+
+	if err := proc.fn(change); err != nil {
+		return err
+	}
+
+	dir, ok := entry.(objects.TreeEntryDir)
+	if !ok {
+		return nil
+	}
+
+	tree, err := proc.getTree(dir.Ref)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range sortedNames(tree) {
+		if err := proc.reportAll(tree[name], path.Join(p, name), kind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameMetadata compares the metadata (and for files the hardlink group) of two entries of the same type. Timestamps
+// are ignored, the atime and ctime change too often and changed content is detected by comparing the content.
+func sameMetadata(a, b objects.TreeEntry) bool {
+	if file, ok := a.(objects.TreeEntryFile); ok && file.Hardlink != b.(objects.TreeEntryFile).Hardlink {
+		return false
+	}
+
+	return a.ACL().Equals(b.ACL()) && a.User() == b.User() && a.Group() == b.Group() &&
+		a.Metadata().EqualsIgnoringTimes(b.Metadata())
+}
+
+func (proc diffProcess) diffEntries(old, new objects.TreeEntry, p string) error {
+	if old.Type() != new.Type() {
+		return proc.fn(Change{Path: p, Kind: ChangeType, Old: old, New: new})
+	}
+
+	if !sameMetadata(old, new) {
+		if err := proc.fn(Change{Path: p, Kind: ChangeMetadata, Old: old, New: new}); err != nil {
+			return err
+		}
+	}
+
+	switch o := old.(type) {
+	case objects.TreeEntryFile:
+		if !o.Ref.Equals(new.(objects.TreeEntryFile).Ref) {
+			return proc.fn(Change{Path: p, Kind: ChangeModified, Old: old, New: new})
+		}
+	case objects.TreeEntrySymlink:
+		if o.Target != new.(objects.TreeEntrySymlink).Target {
+			return proc.fn(Change{Path: p, Kind: ChangeTarget, Old: old, New: new})
+		}
+	case objects.TreeEntrySpecial:
+		if n := new.(objects.TreeEntrySpecial); o.Major != n.Major || o.Minor != n.Minor {
+			return proc.fn(Change{Path: p, Kind: ChangeModified, Old: old, New: new})
+		}
+	case objects.TreeEntryDir:
+		return proc.diffTrees(o.Ref, new.(objects.TreeEntryDir).Ref, p)
+	}
+
+	return nil
+}
+
+func (proc diffProcess) diffTrees(old_id, new_id objects.ObjectId, p string) error {
+	if old_id.Equals(new_id) {
+		return nil
+	}
+
+	old, err := proc.getTree(old_id)
+	if err != nil {
+		return err
+	}
+	new, err := proc.getTree(new_id)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range sortedNames(old, new) {
+		child_path := path.Join(p, name)
+		old_entry, in_old := old[name]
+		new_entry, in_new := new[name]
+
+		switch {
+		case !in_old:
+			err = proc.reportAll(new_entry, child_path, ChangeAdded)
+		case !in_new:
+			err = proc.reportAll(old_entry, child_path, ChangeRemoved)
+		default:
+			err = proc.diffEntries(old_entry, new_entry, child_path)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
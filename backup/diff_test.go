@@ -0,0 +1,94 @@
+package backup
+
+import (
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"reflect"
+	"testing"
+)
+
+func TestDiffTrees(t *testing.T) {
+	s := storageWithTestTree()
+
+	newtree := objects.Tree{
+		"foo":   objects.NewTreeEntryFile(objid_emptyfile, false),
+		"bar":   objects.NewTreeEntryFile(objid_emptyfile, false),
+		"baz":   objects.NewTreeEntrySymlink("bar", false),
+		"sub":   objects.NewTreeEntryFile(objid_emptyfile, false),
+		"added": objects.NewTreeEntryDir(objid_subtree, true),
+	}
+	newtree_id, err := storage.SetObject(s, objects.ToRawObject(newtree))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type change struct {
+		path string
+		kind ChangeKind
+	}
+
+	have := []change{}
+	err = DiffTrees(s, objid_testtree, newtree_id, func(c Change) error {
+		if (c.Old == nil) != (c.Kind == ChangeAdded) || (c.New == nil) != (c.Kind == ChangeRemoved) {
+			t.Errorf("Unexpected Old/New for %s %s: %v, %v", c.Kind, c.Path, c.Old, c.New)
+		}
+
+		have = append(have, change{c.Path, c.Kind})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DiffTrees failed: %s", err)
+	}
+
+	want := []change{
+		{"/added", ChangeAdded},
+		{"/added/a", ChangeAdded},
+		{"/added/b", ChangeAdded},
+		{"/bar", ChangeMetadata},
+		{"/baz", ChangeTarget},
+		{"/foo", ChangeModified},
+		{"/sub", ChangeType},
+	}
+
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("Unexpected changes:\nhave: %v\nwant: %v", have, want)
+	}
+
+	err = DiffTrees(s, objid_testtree, objid_testtree, func(c Change) error {
+		t.Errorf("Unexpected change in identical trees: %v", c)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DiffTrees failed: %s", err)
+	}
+}
+
+func TestDiffHardlink(t *testing.T) {
+	s := storageWithTestTree()
+
+	file := objects.NewTreeEntryFile(objid_emptyfile, false)
+	linked := file
+	linked.Hardlink = "1"
+
+	ids := []objects.ObjectId{}
+	for _, entry := range []objects.TreeEntry{file, linked} {
+		id, err := storage.SetObject(s, objects.ToRawObject(objects.Tree{"foo": entry}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	changes := []ChangeKind{}
+	err := DiffTrees(s, ids[0], ids[1], func(c Change) error {
+		changes = append(changes, c.Kind)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DiffTrees failed: %s", err)
+	}
+
+	if !reflect.DeepEqual(changes, []ChangeKind{ChangeMetadata}) {
+		t.Errorf("Unexpected changes: %v", changes)
+	}
+}
@@ -69,6 +69,7 @@ type queueElement struct {
 type fsckProcess struct {
 	st       storage.Storage
 	blobs    bool
+	mark     bool // If blobs is false, still mark referenced blobs as seen without checking them
 	problems chan<- FsckProblem
 	wait     *sync.WaitGroup
 	queue    chan queueElement
@@ -172,13 +173,17 @@ func (fsck fsckProcess) handleBlob(elem queueElement, obj *objects.Blob) {
 }
 
 func (fsck fsckProcess) handleFile(elem queueElement, obj *objects.File) {
-	if !fsck.blobs {
+	if !fsck.blobs && !fsck.mark {
 		return
 	}
 
 	enqueue := make([]queueElement, 0, len(*obj))
 
 	for _, fragment := range *obj {
+		if fragment.Hole {
+			continue
+		}
+
 		enqueue = append(enqueue, queueElement{
 			Id: fragment.Blob,
 			Ancestors: append(elem.Ancestors, AncestorInfo{
@@ -189,6 +194,11 @@ func (fsck fsckProcess) handleFile(elem queueElement, obj *objects.File) {
 		})
 	}
 
+	if !fsck.blobs {
+		fsck.onlyUnseen(enqueue)
+		return
+	}
+
 	fsck.enqueue(enqueue)
 }
 
@@ -234,15 +244,13 @@ func (fsck fsckProcess) worker(i int) {
 	fsck.log.Debug().Printf("stopping worker %d", i)
 }
 
-// Fsck checks the consistency of objects in a storage
-func Fsck(
+func newFsckProcess(
 	st storage.Storage,
-	start *objects.ObjectId,
 	blobs bool,
 	problems chan<- FsckProblem,
 	log *logging.Log,
-) error {
-	proc := fsckProcess{
+) fsckProcess {
+	return fsckProcess{
 		st:       st,
 		blobs:    blobs,
 		problems: problems,
@@ -252,6 +260,33 @@ func Fsck(
 		seenLock: new(sync.Mutex),
 		log:      log,
 	}
+}
+
+func (fsck fsckProcess) run(enqueue []queueElement) {
+	if len(enqueue) == 0 {
+		return
+	}
+
+	for i := 0; i < runtime.NumCPU(); i++ {
+		fsck.log.Debug().Printf("starting worker %d", i)
+		go fsck.worker(i)
+	}
+
+	fsck.enqueue(enqueue)
+
+	fsck.wait.Wait()
+	close(fsck.queue)
+}
+
+// Fsck checks the consistency of objects in a storage
+func Fsck(
+	st storage.Storage,
+	start *objects.ObjectId,
+	blobs bool,
+	problems chan<- FsckProblem,
+	log *logging.Log,
+) error {
+	proc := newFsckProcess(st, blobs, problems, log)
 
 	enqueue := []queueElement{}
 
@@ -277,18 +312,30 @@ func Fsck(
 		}
 	}
 
-	if len(enqueue) == 0 {
-		return nil
-	}
+	proc.run(enqueue)
+	return nil
+}
 
-	for i := 0; i < runtime.NumCPU(); i++ {
-		log.Debug().Printf("starting worker %d", i)
-		go proc.worker(i)
-	}
+// Reachable walks the objects referenced by the given root objects (and the
+// objects referenced by them and so on) and returns the set of all visited
+// object IDs (as strings), including the roots themselves.
+// Blobs are included in the set, but are not retrieved from the storage.
+// Objects that could not be read are reported to problems, the objects they
+// reference will then be missing in the result.
+func Reachable(
+	st storage.Storage,
+	roots []objects.ObjectId,
+	problems chan<- FsckProblem,
+	log *logging.Log,
+) map[string]struct{} {
+	proc := newFsckProcess(st, false, problems, log)
+	proc.mark = true
 
-	proc.enqueue(enqueue)
+	enqueue := make([]queueElement, 0, len(roots))
+	for _, id := range roots {
+		enqueue = append(enqueue, queueElement{Id: id})
+	}
 
-	proc.wait.Wait()
-	close(proc.queue)
-	return nil
+	proc.run(enqueue)
+	return proc.seen
 }
@@ -0,0 +1,132 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"code.laria.me/petrific/fs"
+	"io"
+	"os"
+	"strings"
+)
+
+const DefaultIgnoreFileName = ".petrificignore"
+
+// Directories containing a file CACHEDIR.TAG starting with this signature are cache directories,
+// see https://bford.info/cachedir/
+const cacheDirTagName = "CACHEDIR.TAG"
+
+var cacheDirSignature = []byte("Signature: 8a477f597d28d172789f06886806bc55")
+
+type ignoreRule struct {
+	pattern PathPattern
+	negate  bool
+}
+
+// IgnoreRules is a list of gitignore-style exclude rules (see PathPattern for the pattern syntax).
+// Later rules take precedence over earlier rules. A rule starting with "!" includes paths again, that were
+// excluded by an earlier rule. Like in git, it is not possible to include a path, if a parent directory is excluded.
+type IgnoreRules []ignoreRule
+
+// ParseIgnoreRules parses patterns relative to the directory base.
+// Empty lines and lines starting with "#" are skipped, use "\#" or "\!" for patterns starting with these characters.
+func ParseIgnoreRules(base string, lines []string) (IgnoreRules, error) {
+	rules := IgnoreRules{}
+
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		var rule ignoreRule
+		if line[0] == '!' {
+			rule.negate = true
+			line = line[1:]
+		} else if line[0] == '\\' {
+			line = line[1:]
+		}
+
+		var err error
+		if rule.pattern, err = parsePathPatternIn(base, line); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// ReadIgnoreFile reads rules (one per line) from r, the patterns are relative to the directory base
+func ReadIgnoreFile(base string, r io.Reader) (IgnoreRules, error) {
+	lines := []string{}
+
+	scan := bufio.NewScanner(r)
+	for scan.Scan() {
+		lines = append(lines, scan.Text())
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+
+	return ParseIgnoreRules(base, lines)
+}
+
+// Ignored checks, if the path p is excluded by the rules
+func (ir IgnoreRules) Ignored(p string, isDir bool) bool {
+	ignored := false
+	for _, rule := range ir {
+		if rule.pattern.MatchEntry(p, isDir) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func readIgnoreFile(d fs.Dir, name, base string) (IgnoreRules, error) {
+	f, err := d.GetChild(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if f.Type() != fs.FFile {
+		return nil, nil
+	}
+
+	rc, err := f.(fs.RegularFile).Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return ReadIgnoreFile(base, rc)
+}
+
+func isCacheDir(d fs.Dir) (bool, error) {
+	f, err := d.GetChild(cacheDirTagName)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if f.Type() != fs.FFile {
+		return false, nil
+	}
+
+	rc, err := f.(fs.RegularFile).Open()
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+
+	buf := make([]byte, len(cacheDirSignature))
+	if _, err := io.ReadFull(rc, buf); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(buf, cacheDirSignature), nil
+}
@@ -0,0 +1,52 @@
+package backup
+
+import (
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"fmt"
+	"strings"
+)
+
+// LookupPath resolves the slash separated path p inside the tree with the ID tree_id.
+// An empty path (or "/") resolves to the tree itself.
+func LookupPath(s storage.Storage, tree_id objects.ObjectId, p string) (objects.TreeEntry, error) {
+	var entry objects.TreeEntry = objects.NewTreeEntryDir(tree_id, true)
+
+	walked := ""
+	for _, name := range strings.Split(p, "/") {
+		if name == "" || name == "." {
+			continue
+		}
+
+		dir, ok := entry.(objects.TreeEntryDir)
+		if !ok {
+			return nil, fmt.Errorf("%s: Not a directory", walked)
+		}
+
+		tree_obj, err := storage.GetObjectOfType(s, dir.Ref, objects.OTTree)
+		if err != nil {
+			return nil, err
+		}
+
+		walked += "/" + name
+		if entry, ok = tree_obj.(objects.Tree)[name]; !ok {
+			return nil, fmt.Errorf("%s: No such file or directory", walked)
+		}
+	}
+
+	return entry, nil
+}
+
+// FileSize calculates the size of a file object by summing up the sizes of its fragments
+func FileSize(s storage.Storage, id objects.ObjectId) (uint64, error) {
+	file, err := storage.GetObjectOfType(s, id, objects.OTFile)
+	if err != nil {
+		return 0, err
+	}
+
+	var size uint64
+	for _, fragment := range *file.(*objects.File) {
+		size += fragment.Size
+	}
+	return size, nil
+}
@@ -0,0 +1,58 @@
+package backup
+
+import (
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage/memory"
+	"testing"
+)
+
+func TestLookupPath(t *testing.T) {
+	st := memory.NewMemoryStorage()
+	st.Set(objid_emptyfile, objects.OTFile, obj_emptyfile)
+	st.Set(objid_fooblob, objects.OTBlob, obj_fooblob)
+	st.Set(objid_foofile, objects.OTFile, obj_foofile)
+	st.Set(objid_emptytree, objects.OTTree, obj_emptytree)
+	st.Set(objid_subtree, objects.OTTree, obj_subtree)
+	st.Set(objid_testtree, objects.OTTree, obj_testtree)
+
+	for _, p := range []string{"", "/", "."} {
+		entry, err := LookupPath(st, objid_testtree, p)
+		if err != nil {
+			t.Fatalf("LookupPath(%q) failed: %s", p, err)
+		}
+		if dir, ok := entry.(objects.TreeEntryDir); !ok || !dir.Ref.Equals(objid_testtree) {
+			t.Errorf("LookupPath(%q) didn't resolve to root tree: %v", p, entry)
+		}
+	}
+
+	entry, err := LookupPath(st, objid_testtree, "/sub/b/")
+	if err != nil {
+		t.Fatalf("LookupPath failed: %s", err)
+	}
+	if dir, ok := entry.(objects.TreeEntryDir); !ok || !dir.Ref.Equals(objid_emptytree) {
+		t.Errorf("sub/b: Expected empty tree, got %v", entry)
+	}
+
+	entry, err = LookupPath(st, objid_testtree, "foo")
+	if err != nil {
+		t.Fatalf("LookupPath failed: %s", err)
+	}
+	file, ok := entry.(objects.TreeEntryFile)
+	if !ok || !file.Ref.Equals(objid_foofile) {
+		t.Fatalf("foo: Expected foo file, got %v", entry)
+	}
+
+	size, err := FileSize(st, file.Ref)
+	if err != nil {
+		t.Fatalf("FileSize failed: %s", err)
+	}
+	if size != 3 {
+		t.Errorf("Expected size 3, got %d", size)
+	}
+
+	for _, p := range []string{"nope", "sub/nope", "foo/bar", "baz/x"} {
+		if _, err := LookupPath(st, objid_testtree, p); err == nil {
+			t.Errorf("LookupPath(%q) should fail", p)
+		}
+	}
+}
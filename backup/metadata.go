@@ -0,0 +1,84 @@
+package backup
+
+import (
+	"code.laria.me/petrific/acl"
+	"code.laria.me/petrific/fs"
+	"code.laria.me/petrific/objects"
+)
+
+func objectsMetadata(m fs.Metadata) objects.Metadata {
+	return objects.Metadata{
+		Mode:       m.Mode,
+		HasOwner:   m.HasOwner,
+		Uid:        m.Uid,
+		Gid:        m.Gid,
+		Mtime:      m.Mtime,
+		Atime:      m.Atime,
+		Ctime:      m.Ctime,
+		DefaultACL: m.DefaultACL,
+		Xattrs:     m.Xattrs,
+	}
+}
+
+func fsMetadata(entry objects.TreeEntry) fs.Metadata {
+	m := entry.Metadata()
+
+	// Plain permissions are restored using the mode
+	var access_acl acl.ACL
+	if entry.ACL().IsExtended() {
+		access_acl = entry.ACL()
+	}
+
+	return fs.Metadata{
+		Mode:       m.Mode,
+		HasOwner:   m.HasOwner,
+		Uid:        m.Uid,
+		Gid:        m.Gid,
+		User:       entry.User(),
+		Group:      entry.Group(),
+		Mtime:      m.Mtime,
+		Atime:      m.Atime,
+		ACL:        access_acl,
+		DefaultACL: m.DefaultACL,
+		Xattrs:     m.Xattrs,
+	}
+}
+
+// withMetadata attaches the metadata (and the hardlink group) of f to the tree entry.
+// Metadata that could not be read is logged and left out.
+func (proc writeDirProcess) withMetadata(entry objects.TreeEntry, f fs.File, abspath string) objects.TreeEntry {
+	m, err := f.Metadata()
+	if err != nil {
+		proc.log.Warn().Printf("could not read all metadata of %s: %s", abspath, err)
+	}
+
+	user, group := "", ""
+	if m.HasOwner {
+		user, group = m.User, m.Group
+	}
+
+	entry = objects.WithMetadata(entry, objectsMetadata(m), user, group)
+	if m.ACL.IsExtended() {
+		entry = objects.WithACL(entry, m.ACL)
+	}
+
+	if file_entry, ok := entry.(objects.TreeEntryFile); ok {
+		file_entry.Hardlink = f.(fs.RegularFile).HardlinkGroup()
+		entry = file_entry
+	}
+
+	return entry
+}
+
+// applyMetadata restores the metadata of the entry to f. Failures are only logged, since they are usually caused
+// by missing privileges or filesystem support.
+func (proc restoreProcess) applyMetadata(f fs.File, entry objects.TreeEntry, child_path string) {
+	m := fsMetadata(entry)
+	if !proc.opts.Owner {
+		m.HasOwner = false
+	}
+
+	if err := f.SetMetadata(m); err != nil {
+		proc.log.Warn().Printf("could not restore metadata of %s: %s", child_path, err)
+	}
+}
@@ -0,0 +1,172 @@
+package backup
+
+import (
+	"path"
+	"strings"
+)
+
+// PathPattern is a glob-style pattern matched against slash separated paths inside a tree.
+//
+// The pattern is split into components at slashes. Each component is matched using the rules of path.Match,
+// except for the component "**", which matches zero or more path components. Like in gitignore, patterns
+// containing a slash at the beginning or in the middle are anchored at the root of the tree, all other patterns
+// can match at any depth (e.g. "*.log" matches "/a.log" and "/var/log/b.log"). A pattern ending with a slash
+// only matches directories.
+//
+// A pattern also matches everything below a matching path, e.g. "/etc/nginx" matches "/etc/nginx/nginx.conf".
+type PathPattern struct {
+	raw     string
+	comps   []string
+	dirOnly bool
+}
+
+func splitPath(p string) []string {
+	comps := []string{}
+	for _, c := range strings.Split(p, "/") {
+		if c != "" && c != "." {
+			comps = append(comps, c)
+		}
+	}
+	return comps
+}
+
+func ParsePathPattern(s string) (PathPattern, error) {
+	return parsePathPatternIn("/", s)
+}
+
+// parsePathPatternIn parses a pattern relative to the directory base
+func parsePathPatternIn(base, s string) (PathPattern, error) {
+	pp := PathPattern{raw: s, comps: splitPath(s), dirOnly: strings.HasSuffix(s, "/")}
+
+	for _, c := range pp.comps {
+		if _, err := path.Match(c, ""); err != nil {
+			return pp, err
+		}
+	}
+
+	if !strings.Contains(strings.TrimSuffix(s, "/"), "/") {
+		pp.comps = append([]string{"**"}, pp.comps...)
+	}
+
+	pp.comps = append(splitPath(base), pp.comps...)
+
+	return pp, nil
+}
+
+func (pp PathPattern) String() string {
+	return pp.raw
+}
+
+// matchComponents matches the pattern components against the path components.
+// If prefix is set, it is checked, if anything below p could match. exact decides, if the pattern may match p
+// itself (it still matches everything below p).
+func matchComponents(pat, p []string, prefix, exact bool) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(p); i++ {
+				if matchComponents(pat[1:], p[i:], prefix, exact) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(p) == 0 {
+			return prefix
+		}
+
+		if ok, _ := path.Match(pat[0], p[0]); !ok {
+			return false
+		}
+
+		pat, p = pat[1:], p[1:]
+	}
+
+	// Everything below a match also matches (and everything above p is a directory)
+	return len(p) > 0 || exact
+}
+
+// Match checks, if the pattern matches the path p (or one of its parents). Since the type of p is not known,
+// patterns only matching directories can match p.
+func (pp PathPattern) Match(p string) bool {
+	return pp.MatchEntry(p, true)
+}
+
+// MatchEntry checks, if the pattern matches the path p (or one of its parents), p being a directory, if isDir is set
+func (pp PathPattern) MatchEntry(p string, isDir bool) bool {
+	return matchComponents(pp.comps, splitPath(p), false, isDir || !pp.dirOnly)
+}
+
+// MatchBelow checks, if the pattern could match the directory p or anything below it
+func (pp PathPattern) MatchBelow(p string) bool {
+	return matchComponents(pp.comps, splitPath(p), true, true)
+}
+
+// PathFilter selects paths using include and exclude patterns.
+// A path is selected, if it matches any include pattern (or there are no include patterns) and no exclude pattern.
+type PathFilter struct {
+	Include, Exclude []PathPattern
+}
+
+func ParsePathFilter(include, exclude []string) (pf PathFilter, err error) {
+	if pf.Include, err = parsePathPatterns(include); err != nil {
+		return
+	}
+	pf.Exclude, err = parsePathPatterns(exclude)
+	return
+}
+
+func parsePathPatterns(ss []string) ([]PathPattern, error) {
+	patterns := make([]PathPattern, 0, len(ss))
+	for _, s := range ss {
+		pp, err := ParsePathPattern(s)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, pp)
+	}
+	return patterns, nil
+}
+
+func (pf PathFilter) excluded(p string) bool {
+	for _, pp := range pf.Exclude {
+		if pp.Match(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Selected checks, if the path p is selected by the filter
+func (pf PathFilter) Selected(p string) bool {
+	if pf.excluded(p) {
+		return false
+	}
+
+	if len(pf.Include) == 0 {
+		return true
+	}
+	for _, pp := range pf.Include {
+		if pp.Match(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaySelectBelow checks, if anything below the directory p might be selected by the filter
+func (pf PathFilter) MaySelectBelow(p string) bool {
+	if pf.excluded(p) {
+		return false
+	}
+
+	if len(pf.Include) == 0 {
+		return true
+	}
+	for _, pp := range pf.Include {
+		if pp.MatchBelow(p) {
+			return true
+		}
+	}
+	return false
+}
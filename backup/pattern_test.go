@@ -0,0 +1,81 @@
+package backup
+
+import (
+	"testing"
+)
+
+func TestPathPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+		below   bool
+	}{
+		{"/etc/nginx/**", "/etc/nginx/sites/default", true, true},
+		{"/etc/nginx/**", "/etc/nginx", true, true},
+		{"/etc/nginx/**", "/etc", false, true},
+		{"/etc/nginx/**", "/", false, true},
+		{"/etc/nginx/**", "/var", false, false},
+		{"/etc/nginx", "/etc/nginx/nginx.conf", true, true},
+		{"/etc/*.conf", "/etc/foo.conf", true, true},
+		{"/etc/*.conf", "/etc/sub/foo.conf", false, false},
+		{"*.log", "/a.log", true, true},
+		{"*.log", "/var/log/b.log", true, true},
+		{"*.log", "/var/log", false, true},
+		{"/home/**/.cache", "/home/foo/.cache/x", true, true},
+		{"/home/**/.cache", "/home/.cache", true, true},
+		{"/home/**/.cache", "/home/foo/bar", false, true},
+		{"sub/b", "/sub/b", true, true},
+		{"sub/b", "/x/sub/b", false, false},
+		{"build/", "/x/build", true, true},
+		{"build/", "/x/build/out.o", true, true},
+	}
+
+	for _, test := range tests {
+		pp, err := ParsePathPattern(test.pattern)
+		if err != nil {
+			t.Fatalf("ParsePathPattern(%q) failed: %s", test.pattern, err)
+		}
+
+		if have := pp.Match(test.path); have != test.match {
+			t.Errorf("%q.Match(%q) = %t, expected %t", test.pattern, test.path, have, test.match)
+		}
+		if have := pp.MatchBelow(test.path); have != test.below {
+			t.Errorf("%q.MatchBelow(%q) = %t, expected %t", test.pattern, test.path, have, test.below)
+		}
+	}
+
+	dir_only, _ := ParsePathPattern("build/")
+	if dir_only.MatchEntry("/x/build", false) {
+		t.Errorf("build/ should not match a file")
+	}
+	if !dir_only.MatchEntry("/x/build", true) {
+		t.Errorf("build/ should match a directory")
+	}
+
+	if _, err := ParsePathPattern("/foo/[a"); err == nil {
+		t.Errorf("Expected invalid pattern to fail")
+	}
+}
+
+func TestPathFilter(t *testing.T) {
+	pf, err := ParsePathFilter([]string{"/etc/**"}, []string{"*.bak"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for p, want := range map[string]bool{
+		"/etc/passwd":        true,
+		"/etc/passwd.bak":    false,
+		"/etc/x.bak/foo":     false,
+		"/var/lib/something": false,
+	} {
+		if have := pf.Selected(p); have != want {
+			t.Errorf("Selected(%q) = %t, expected %t", p, have, want)
+		}
+	}
+
+	if !(PathFilter{}).Selected("/anything") {
+		t.Errorf("Empty filter should select everything")
+	}
+}
@@ -0,0 +1,87 @@
+package backup
+
+import (
+	"code.laria.me/petrific/logging"
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"fmt"
+)
+
+// ForgetSnapshot deletes a snapshot object from the storage.
+// The objects referenced by the snapshot stay in the storage, use Prune to get rid of them.
+func ForgetSnapshot(st storage.Storage, id objects.ObjectId) error {
+	if _, err := storage.GetObjectOfType(st, id, objects.OTSnapshot); err != nil {
+		return err
+	}
+
+	return st.Delete(id)
+}
+
+type PruneProblemsErr int
+
+func (n PruneProblemsErr) Error() string {
+	return fmt.Sprintf("Found %d problem(s) while walking the snapshots, refusing to delete anything. Run fsck for details", int(n))
+}
+
+// Prune deletes all objects that are not (directly or indirectly) referenced by a snapshot.
+// If dryRun is true, nothing will be deleted. It returns the IDs of the (to be) deleted objects.
+//
+// Prune refuses to delete anything, if any of the referenced objects could not be read.
+// It must not run concurrently with anything else writing to the storage, since new objects
+// might reference objects that are deleted in the meantime.
+func Prune(st storage.Storage, dryRun bool, log *logging.Log) ([]objects.ObjectId, error) {
+	roots, err := st.List(objects.OTSnapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := make(chan FsckProblem)
+	var reachable map[string]struct{}
+	go func() {
+		reachable = Reachable(st, roots, problems, log)
+		close(problems)
+	}()
+
+	n_problems := 0
+	for p := range problems {
+		log.Warn().Print(p)
+		n_problems++
+	}
+
+	if n_problems > 0 {
+		return nil, PruneProblemsErr(n_problems)
+	}
+
+	log.Info().Printf("%d reachable objects", len(reachable))
+
+	types := []objects.ObjectType{
+		objects.OTTree,
+		objects.OTFile,
+		objects.OTBlob,
+	}
+
+	deleted := make([]objects.ObjectId, 0)
+	for _, t := range types {
+		ids, err := st.List(t)
+		if err != nil {
+			return deleted, err
+		}
+
+		for _, id := range ids {
+			if _, ok := reachable[id.String()]; ok {
+				continue
+			}
+
+			if !dryRun {
+				log.Debug().Printf("deleting %s %s", t, id)
+				if err := st.Delete(id); err != nil {
+					return deleted, err
+				}
+			}
+
+			deleted = append(deleted, id)
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,117 @@
+package backup
+
+import (
+	"code.laria.me/petrific/logging"
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"code.laria.me/petrific/storage/memory"
+	"testing"
+	"time"
+)
+
+func pruneTestStorage(t *testing.T) (storage.Storage, objects.ObjectId) {
+	st := memory.NewMemoryStorage()
+	st.Set(objid_emptyfile, objects.OTFile, obj_emptyfile)
+	st.Set(objid_fooblob, objects.OTBlob, obj_fooblob)
+	st.Set(objid_foofile, objects.OTFile, obj_foofile)
+	st.Set(objid_emptytree, objects.OTTree, obj_emptytree)
+	st.Set(objid_subtree, objects.OTTree, obj_subtree)
+	st.Set(objid_testtree, objects.OTTree, obj_testtree)
+
+	// Only references the subtree, so testtree, foofile and fooblob are garbage
+	snapshot_id, err := CreateSnapshot(st, objid_subtree, time.Now(), "foo", "")
+	if err != nil {
+		t.Fatalf("Could not create snapshot: %s", err)
+	}
+
+	return st, snapshot_id
+}
+
+func wantPresence(t *testing.T, st storage.Storage, want bool, ids ...objects.ObjectId) {
+	for _, id := range ids {
+		have, err := st.Has(id)
+		if err != nil {
+			t.Errorf("Has(%s) failed: %s", id, err)
+			continue
+		}
+		if have != want {
+			t.Errorf("Has(%s) = %t, want %t", id, have, want)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	st, snapshot_id := pruneTestStorage(t)
+
+	deleted, err := Prune(st, false, logging.NewNopLog())
+	if err != nil {
+		t.Fatalf("Unexpected error from Prune: %s", err)
+	}
+
+	if len(deleted) != 3 {
+		t.Errorf("Expected 3 deleted objects, got %d: %v", len(deleted), deleted)
+	}
+
+	wantPresence(t, st, true, snapshot_id, objid_subtree, objid_emptyfile, objid_emptytree)
+	wantPresence(t, st, false, objid_testtree, objid_foofile, objid_fooblob)
+
+	ids, err := st.List(objects.OTTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 {
+		t.Errorf("Expected 2 listed trees after prune, got %d", len(ids))
+	}
+}
+
+func TestPruneDryRun(t *testing.T) {
+	st, _ := pruneTestStorage(t)
+
+	deleted, err := Prune(st, true, logging.NewNopLog())
+	if err != nil {
+		t.Fatalf("Unexpected error from Prune: %s", err)
+	}
+
+	if len(deleted) != 3 {
+		t.Errorf("Expected 3 objects to be deleted, got %d: %v", len(deleted), deleted)
+	}
+
+	wantPresence(t, st, true, objid_testtree, objid_foofile, objid_fooblob)
+}
+
+func TestPruneRefusesOnProblems(t *testing.T) {
+	st, _ := pruneTestStorage(t)
+	st.Set(objid_corrupt_snapshot_1, objects.OTSnapshot, obj_corrupt_snapshot_1)
+
+	if _, err := Prune(st, false, logging.NewNopLog()); err == nil {
+		t.Fatal("Prune succeeded despite a missing tree")
+	}
+
+	wantPresence(t, st, true, objid_testtree, objid_foofile, objid_fooblob)
+}
+
+func TestForgetSnapshot(t *testing.T) {
+	st, snapshot_id := pruneTestStorage(t)
+
+	if err := ForgetSnapshot(st, objid_subtree); err == nil {
+		t.Error("ForgetSnapshot deleted a tree object")
+	}
+
+	if err := ForgetSnapshot(st, snapshot_id); err != nil {
+		t.Fatalf("Unexpected error from ForgetSnapshot: %s", err)
+	}
+
+	if _, err := Prune(st, false, logging.NewNopLog()); err != nil {
+		t.Fatalf("Unexpected error from Prune: %s", err)
+	}
+
+	for _, typ := range objects.AllObjectTypes {
+		ids, err := st.List(typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != 0 {
+			t.Errorf("%d objects of type %s left", len(ids), typ)
+		}
+	}
+}
@@ -10,15 +10,34 @@ import (
 	"io"
 	"math/rand"
 	"os"
+	"path"
+	"time"
 )
 
+// RestoreFile writes the content of a file object to w. Holes of sparse files are written as zeros.
 func RestoreFile(s storage.Storage, id objects.ObjectId, w io.Writer) error {
+	return restoreFile(s, id, w, false)
+}
+
+// restoreFile writes the content of a file object to w. If sparse is set, w must implement sparseWriter and holes
+// are recreated as holes.
+func restoreFile(s storage.Storage, id objects.ObjectId, w io.Writer, sparse bool) error {
 	file, err := storage.GetObjectOfType(s, id, objects.OTFile)
 	if err != nil {
 		return err
 	}
 
+	var size uint64
 	for i, fragment := range *file.(*objects.File) {
+		size += fragment.Size
+
+		if fragment.Hole {
+			if err := writeHole(w, sparse, fragment.Size); err != nil {
+				return err
+			}
+			continue
+		}
+
 		blob_obj, err := storage.GetObjectOfType(s, fragment.Blob, objects.OTBlob)
 		if err != nil {
 			return err
@@ -34,6 +53,10 @@ func RestoreFile(s storage.Storage, id objects.ObjectId, w io.Writer) error {
 		}
 	}
 
+	if sparse {
+		// Seeking doesn't extend the file, if it ends with a hole
+		return w.(sparseWriter).Truncate(int64(size))
+	}
 	return nil
 }
 
@@ -41,17 +64,213 @@ func execBitFromACL(a acl.ACL) bool {
 	return a.ToUnixPerms()&0100 != 0
 }
 
-func RestoreDir(s storage.Storage, id objects.ObjectId, root fs.Dir, log *logging.Log) error {
-	tree_obj, err := storage.GetObjectOfType(s, id, objects.OTTree)
+// ConflictPolicy decides what happens, if a file to restore already exists in the target directory.
+// An existing directory is never a conflict for a directory to restore, their contents get merged.
+type ConflictPolicy string
+
+const (
+	ConflictOverwrite ConflictPolicy = "overwrite"  // Replace the existing file
+	ConflictSkip      ConflictPolicy = "skip"       // Keep the existing file, don't restore
+	ConflictKeepNewer ConflictPolicy = "keep-newer" // Keep the existing file, if it was modified after the backup
+	ConflictRename    ConflictPolicy = "rename"     // Restore the file under another name (with a suffix)
+)
+
+const DefaultRenameSuffix = ".restored"
+
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch cp := ConflictPolicy(s); cp {
+	case ConflictOverwrite, ConflictSkip, ConflictKeepNewer, ConflictRename:
+		return cp, nil
+	case "":
+		return ConflictOverwrite, nil
+	default:
+		return "", fmt.Errorf("unknown conflict policy %s", s)
+	}
+}
+
+// RestoreOptions modify the behaviour of RestoreDir
+type RestoreOptions struct {
+	// Only restore the paths (inside the tree) selected by this filter. Intermediate directories are created as
+	// needed. Children of the target that are not in the backup are only deleted, if they are selected.
+	Filter PathFilter
+
+	// Never delete children of the target that are not in the backup
+	NonDestructive bool
+
+	Conflict     ConflictPolicy // Empty means ConflictOverwrite
+	RenameSuffix string         // Used by ConflictRename, empty means DefaultRenameSuffix
+	BackupTime   time.Time      // Used by ConflictKeepNewer, existing files modified after this time are kept
+
+	// Restore the owner of files (usually requires root privileges). The other metadata is always restored.
+	Owner bool
+}
+
+type restoreProcess struct {
+	store storage.Storage
+	log   *logging.Log
+	opts  RestoreOptions
+
+	hardlinks map[string]fs.RegularFile // Already restored file of a hardlink group
+}
+
+func RestoreDir(s storage.Storage, id objects.ObjectId, root fs.Dir, log *logging.Log, opts RestoreOptions) error {
+	proc := restoreProcess{s, log, opts, make(map[string]fs.RegularFile)}
+	return proc.restoreDir(id, "/", func() (fs.Dir, error) { return root, nil })
+}
+
+// getOrCreateDir returns the child directory name of root, creating it if necessary
+func getOrCreateDir(root fs.Dir, name string) (fs.Dir, error) {
+	// Try to use existing directory
+	child, err := root.GetChild(name)
+	if err == nil {
+		if child.Type() == fs.FDir {
+			return child.(fs.Dir), nil
+		}
+
+		if err := child.Delete(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Create directory, if it doesn't exist
+	return root.CreateChildDir(name)
+}
+
+// deleteChild deletes the child name of root, if it exists
+func deleteChild(root fs.Dir, name string) error {
+	child, err := root.GetChild(name)
+	if err == nil {
+		return child.Delete()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// lazyDir returns a function that returns the child directory name of the directory returned by parent.
+// The directory gets created on the first call, so directories are only created if something is restored into them.
+func lazyDir(parent func() (fs.Dir, error), name string) func() (fs.Dir, error) {
+	var dir fs.Dir
+	return func() (fs.Dir, error) {
+		if dir != nil {
+			return dir, nil
+		}
+
+		root, err := parent()
+		if err != nil {
+			return nil, err
+		}
+
+		dir, err = getOrCreateDir(root, name)
+		return dir, err
+	}
+}
+
+// resolveConflict checks, if restoring the entry name in root conflicts with an existing child and applies the
+// conflict policy. It returns the name under which the entry should be restored or an empty string, if the entry
+// should not be restored.
+func (proc restoreProcess) resolveConflict(root fs.Dir, name, child_path string, entry objects.TreeEntry) (string, error) {
+	child, err := root.GetChild(name)
+	if os.IsNotExist(err) {
+		return name, nil
+	} else if err != nil {
+		return "", err
+	}
+
+	if entry.Type() == objects.TETDir && child.Type() == fs.FDir {
+		return name, nil
+	}
+
+	switch proc.opts.Conflict {
+	case ConflictOverwrite, "":
+		return name, nil
+	case ConflictSkip:
+		proc.log.Info().Printf("skipping %s, it already exists", child_path)
+		return "", nil
+	case ConflictKeepNewer:
+		if child.ModTime().After(proc.opts.BackupTime) {
+			proc.log.Info().Printf("skipping %s, existing file is newer", child_path)
+			return "", nil
+		}
+		return name, nil
+	case ConflictRename:
+		suffix := proc.opts.RenameSuffix
+		if suffix == "" {
+			suffix = DefaultRenameSuffix
+		}
+
+		for i := 0; ; i++ {
+			newname := name + suffix
+			if i > 0 {
+				newname += fmt.Sprintf(".%d", i)
+			}
+
+			if _, err := root.GetChild(newname); os.IsNotExist(err) {
+				proc.log.Info().Printf("%s already exists, restoring as %s", child_path, newname)
+				return newname, nil
+			} else if err != nil {
+				return "", err
+			}
+		}
+	default:
+		return "", fmt.Errorf("unknown conflict policy %s", proc.opts.Conflict)
+	}
+}
+
+func (proc restoreProcess) restoreDir(id objects.ObjectId, dir_path string, get_root func() (fs.Dir, error)) error {
+	tree_obj, err := storage.GetObjectOfType(proc.store, id, objects.OTTree)
+	if err != nil {
+		return err
+	}
 	tree := tree_obj.(objects.Tree)
 
+	filter := proc.opts.Filter
+
 	seen := make(map[string]struct{})
 
 	for name, file_info := range tree {
-		log.Info().Printf("restoring %s %s", name, file_info.Type())
+		seen[name] = struct{}{}
+		child_path := path.Join(dir_path, name)
+
+		if !filter.Selected(child_path) {
+			if dir_info, ok := file_info.(objects.TreeEntryDir); ok && filter.MaySelectBelow(child_path) {
+				if err := proc.restoreDir(dir_info.Ref, child_path, lazyDir(get_root, name)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		root, err := get_root()
+		if err != nil {
+			return err
+		}
+
+		// child_path stays the path inside the tree, even if the entry is restored under another name
+		if name, err = proc.resolveConflict(root, name, child_path, file_info); err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		proc.log.Info().Printf("restoring %s %s", child_path, file_info.Type())
 
 		switch file_info.Type() {
 		case objects.TETFile:
+			if target, ok := proc.hardlinks[file_info.(objects.TreeEntryFile).Hardlink]; ok {
+				if err := deleteChild(root, name); err != nil {
+					return err
+				}
+				if _, err := root.CreateChildHardlink(name, target); err != nil {
+					return err
+				}
+				continue
+			}
+
 			tmpname := fmt.Sprintf(".petrific-%d-%08x%08x%08x%08x", os.Getpid(), rand.Uint32(), rand.Uint32(), rand.Uint32(), rand.Uint32())
 			new_file, err := root.CreateChildFile(tmpname, execBitFromACL(file_info.ACL()))
 			if err != nil {
@@ -63,72 +282,91 @@ func RestoreDir(s storage.Storage, id objects.ObjectId, root fs.Dir, log *loggin
 				return err
 			}
 
-			if err := RestoreFile(s, file_info.(objects.TreeEntryFile).Ref, wc); err != nil {
+			_, sparse := wc.(sparseWriter)
+			if err := restoreFile(proc.store, file_info.(objects.TreeEntryFile).Ref, wc, sparse); err != nil {
 				wc.Close()
 				return err
 			}
 			wc.Close()
 
+			// A directory can't simply be replaced by renaming
+			if child, err := root.GetChild(name); err == nil && child.Type() == fs.FDir {
+				if err := child.Delete(); err != nil {
+					return err
+				}
+			}
+
 			if err := root.RenameChild(tmpname, name); err != nil {
 				return err
 			}
-		case objects.TETDir:
-			var subdir fs.Dir
-
-			// Try to use existing directory
-			child, err := root.GetChild(name)
-			if err == nil {
-				if child.Type() == fs.FDir {
-					subdir = child.(fs.Dir)
-				} else {
-					if err := child.Delete(); err != nil {
-						return err
-					}
-				}
-			} else if !os.IsNotExist(err) {
+
+			restored, err := root.GetChild(name)
+			if err != nil {
 				return err
 			}
+			proc.applyMetadata(restored, file_info, child_path)
 
-			// Create directory, if it doesn't exist
-			if subdir == nil {
-				subdir, err = root.CreateChildDir(name)
-				if err != nil {
-					return err
-				}
+			if group := file_info.(objects.TreeEntryFile).Hardlink; group != "" {
+				proc.hardlinks[group] = restored.(fs.RegularFile)
+			}
+		case objects.TETDir:
+			subdir, err := getOrCreateDir(root, name)
+			if err != nil {
+				return err
 			}
 
-			if err := RestoreDir(s, file_info.(objects.TreeEntryDir).Ref, subdir, log); err != nil {
+			if err := proc.restoreDir(file_info.(objects.TreeEntryDir).Ref, child_path, func() (fs.Dir, error) { return subdir, nil }); err != nil {
 				return err
 			}
+
+			// Applied after restoring the content, so the modification time is not changed afterwards
+			proc.applyMetadata(subdir, file_info, child_path)
 		case objects.TETSymlink:
-			// Is there already a child of that name? If yes, delete it
-			child, err := root.GetChild(name)
-			if err == nil {
-				if err := child.Delete(); err != nil {
-					return err
-				}
-			} else if err != os.ErrNotExist {
+			if err := deleteChild(root, name); err != nil {
 				return err
 			}
 
-			if _, err := root.CreateChildSymlink(name, file_info.(objects.TreeEntrySymlink).Target); err != nil {
+			symlink, err := root.CreateChildSymlink(name, file_info.(objects.TreeEntrySymlink).Target)
+			if err != nil {
 				return err
 			}
+			proc.applyMetadata(symlink, file_info, child_path)
+		case objects.TETBlockDev, objects.TETCharDev, objects.TETFifo, objects.TETSocket:
+			if err := deleteChild(root, name); err != nil {
+				return err
+			}
+
+			special_info := file_info.(objects.TreeEntrySpecial)
+			special, err := root.CreateChildSpecial(name, fs.FileType(special_info.Kind), special_info.Major, special_info.Minor)
+			if err != nil {
+				// Creating devices usually needs root privileges, this should not stop the restore
+				proc.log.Warn().Printf("could not restore %s: %s", child_path, err)
+				continue
+			}
+			proc.applyMetadata(special, file_info, child_path)
 		default:
 			return fmt.Errorf("child '%s' of %s has unknown tree entry type %s", name, id, file_info.Type())
 		}
+	}
 
-		seen[name] = struct{}{}
+	// Directories that are not selected as a whole might not even exist, we leave them alone
+	if proc.opts.NonDestructive || !filter.Selected(dir_path) {
+		return nil
 	}
 
 	// We now restored all children, we now need to remove the children of root, that shouldn't be there accoring to the backup
+	root, err := get_root()
+	if err != nil {
+		return err
+	}
+
 	children, err := root.Readdir()
 	if err != nil {
 		return err
 	}
 	for _, c := range children {
 		_, ok := seen[c.Name()]
-		if !ok {
+		if !ok && filter.Selected(path.Join(dir_path, c.Name())) {
 			if err := c.Delete(); err != nil {
 				return err
 			}
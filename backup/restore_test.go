@@ -2,12 +2,18 @@ package backup
 
 import (
 	"bytes"
+	"code.laria.me/petrific/acl"
+	"code.laria.me/petrific/cache"
 	"code.laria.me/petrific/fs"
 	"code.laria.me/petrific/logging"
 	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
 	"code.laria.me/petrific/storage/memory"
+	"fmt"
 	"io"
+	"os"
 	"testing"
+	"time"
 )
 
 func withChildOfType(t *testing.T, root fs.Dir, name string, ft fs.FileType, do func(*testing.T, fs.File)) {
@@ -83,7 +89,7 @@ func TestRestoreDir(t *testing.T) {
 
 	root := fs.NewMemoryFSRoot("")
 
-	if err := RestoreDir(s, objid_testtree, root, logging.NewNopLog()); err != nil {
+	if err := RestoreDir(s, objid_testtree, root, logging.NewNopLog(), RestoreOptions{}); err != nil {
 		t.Fatalf("Unexpected error from RestoreDir(): %s", err)
 	}
 
@@ -126,3 +132,216 @@ func TestRestoreLargeFile(t *testing.T) {
 		t.Errorf("Unexpected restoration result: %s", have)
 	}
 }
+
+func TestRestoreDirPartial(t *testing.T) {
+	s := memory.NewMemoryStorage()
+
+	s.Set(objid_emptyfile, objects.OTFile, obj_emptyfile)
+	s.Set(objid_fooblob, objects.OTBlob, obj_fooblob)
+	s.Set(objid_foofile, objects.OTFile, obj_foofile)
+	s.Set(objid_emptytree, objects.OTTree, obj_emptytree)
+	s.Set(objid_subtree, objects.OTTree, obj_subtree)
+	s.Set(objid_testtree, objects.OTTree, obj_testtree)
+
+	root := fs.NewMemoryFSRoot("")
+	if _, err := root.CreateChildFile("unrelated", false); err != nil {
+		t.Fatal(err)
+	}
+
+	filter, err := ParsePathFilter([]string{"/sub/**", "/foo"}, []string{"/sub/b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RestoreDir(s, objid_testtree, root, logging.NewNopLog(), RestoreOptions{Filter: filter}); err != nil {
+		t.Fatalf("Unexpected error from RestoreDir(): %s", err)
+	}
+
+	wantDir(3, func(t *testing.T, root fs.Dir) {
+		withChildOfType(t, root, "unrelated", fs.FFile, wantFileWithContent([]byte{}, false))
+		withChildOfType(t, root, "foo", fs.FFile, wantFileWithContent([]byte("foo"), false))
+		withChildOfType(t, root, "sub", fs.FDir, wantDir(1, func(t *testing.T, d fs.Dir) {
+			withChildOfType(t, d, "a", fs.FFile, wantFileWithContent([]byte{}, false))
+		}))
+	})(t, root)
+}
+
+func TestRestoreDirPartialCreatesOnDemand(t *testing.T) {
+	s := memory.NewMemoryStorage()
+
+	s.Set(objid_emptyfile, objects.OTFile, obj_emptyfile)
+	s.Set(objid_emptytree, objects.OTTree, obj_emptytree)
+	s.Set(objid_subtree, objects.OTTree, obj_subtree)
+	s.Set(objid_testtree, objects.OTTree, obj_testtree)
+
+	root := fs.NewMemoryFSRoot("")
+
+	filter, err := ParsePathFilter([]string{"/sub/a", "/nothing/**"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RestoreDir(s, objid_testtree, root, logging.NewNopLog(), RestoreOptions{Filter: filter}); err != nil {
+		t.Fatalf("Unexpected error from RestoreDir(): %s", err)
+	}
+
+	wantDir(1, func(t *testing.T, root fs.Dir) {
+		withChildOfType(t, root, "sub", fs.FDir, wantDir(1, func(t *testing.T, d fs.Dir) {
+			withChildOfType(t, d, "a", fs.FFile, wantFileWithContent([]byte{}, false))
+		}))
+	})(t, root)
+}
+
+func storageWithTestTree() storage.Storage {
+	s := memory.NewMemoryStorage()
+	s.Set(objid_emptyfile, objects.OTFile, obj_emptyfile)
+	s.Set(objid_fooblob, objects.OTBlob, obj_fooblob)
+	s.Set(objid_foofile, objects.OTFile, obj_foofile)
+	s.Set(objid_emptytree, objects.OTTree, obj_emptytree)
+	s.Set(objid_subtree, objects.OTTree, obj_subtree)
+	s.Set(objid_testtree, objects.OTTree, obj_testtree)
+	return s
+}
+
+func TestRestoreDirConflicts(t *testing.T) {
+	tests := []struct {
+		opts    RestoreOptions
+		want    []byte
+		renamed bool
+	}{
+		{RestoreOptions{NonDestructive: true, Conflict: ConflictOverwrite}, []byte("foo"), false},
+		{RestoreOptions{NonDestructive: true, Conflict: ConflictSkip}, []byte("mine"), false},
+		{RestoreOptions{NonDestructive: true, Conflict: ConflictKeepNewer, BackupTime: time.Now().Add(-time.Hour)}, []byte("mine"), false},
+		{RestoreOptions{NonDestructive: true, Conflict: ConflictKeepNewer, BackupTime: time.Now().Add(time.Hour)}, []byte("foo"), false},
+		{RestoreOptions{NonDestructive: true, Conflict: ConflictRename}, []byte("mine"), true},
+		{RestoreOptions{Conflict: ConflictRename, RenameSuffix: ".new"}, []byte("mine"), true},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%+v", test.opts), func(t *testing.T) {
+			s := storageWithTestTree()
+
+			root := fs.NewMemoryFSRoot("")
+			mkfile(t, root, "foo", false, []byte("mine"))
+			mkfile(t, root, "unknown", false, []byte("unknown"))
+
+			if err := RestoreDir(s, objid_testtree, root, logging.NewNopLog(), test.opts); err != nil {
+				t.Fatalf("Unexpected error from RestoreDir(): %s", err)
+			}
+
+			n := 4
+			if test.opts.NonDestructive {
+				n++
+				withChildOfType(t, root, "unknown", fs.FFile, wantFileWithContent([]byte("unknown"), false))
+			}
+
+			withChildOfType(t, root, "foo", fs.FFile, wantFileWithContent(test.want, false))
+
+			if test.renamed {
+				n++
+				suffix := test.opts.RenameSuffix
+				if suffix == "" {
+					suffix = DefaultRenameSuffix
+				}
+				withChildOfType(t, root, "foo"+suffix, fs.FFile, wantFileWithContent([]byte("foo"), false))
+			}
+
+			wantDir(n, func(*testing.T, fs.Dir) {})(t, root)
+		})
+	}
+}
+
+func TestRestoreDirMetadata(t *testing.T) {
+	s := memory.NewMemoryStorage()
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	file_meta := fs.Metadata{
+		Mode:     0640 | os.ModeSetuid,
+		HasOwner: true,
+		Uid:      1000,
+		Gid:      100,
+		User:     "user1",
+		Group:    "users",
+		Mtime:    mtime,
+		Xattrs:   map[string]string{"user.foo": "bar"},
+	}
+	file_meta.ACL, _ = acl.ParseACL("u::rw-,u:user2:rw-,g::r--,m::rw-,o::---")
+	dir_meta := fs.Metadata{Mode: 0700, Mtime: mtime}
+	dir_meta.DefaultACL, _ = acl.ParseACL("u::rwx,g::r-x,g:users:rwx,m::rwx,o::---")
+
+	src := fs.NewMemoryFSRoot("")
+	mkfile(t, src, "foo", false, []byte("foo"))
+	foo, _ := src.GetChild("foo")
+	foo.SetMetadata(file_meta)
+	sub, _ := src.CreateChildDir("sub")
+	sub.SetMetadata(dir_meta)
+
+	id, err := WriteDir(s, "", src, cache.NopCache{}, logging.NewNopLog(), WriteDirOptions{})
+	if err != nil {
+		t.Fatalf("Could not WriteDir: %s", err)
+	}
+
+	for _, owner := range []bool{false, true} {
+		root := fs.NewMemoryFSRoot("")
+		if err := RestoreDir(s, id, root, logging.NewNopLog(), RestoreOptions{Owner: owner}); err != nil {
+			t.Fatalf("Unexpected error from RestoreDir(): %s", err)
+		}
+
+		want_file_meta := file_meta
+		if !owner {
+			want_file_meta.HasOwner = false
+		}
+
+		for name, want := range map[string]fs.Metadata{"foo": want_file_meta, "sub": dir_meta} {
+			withChildOfType(t, root, name, map[string]fs.FileType{"foo": fs.FFile, "sub": fs.FDir}[name], func(t *testing.T, f fs.File) {
+				have, _ := f.Metadata()
+				if !objectsMetadata(have).Equals(objectsMetadata(want)) || !have.ACL.Equals(want.ACL) ||
+					(owner && (have.User != want.User || have.Group != want.Group)) {
+					t.Errorf("Unexpected metadata of %s (owner=%t): %v", name, owner, have)
+				}
+			})
+		}
+	}
+}
+
+func TestRestoreDirSpecialAndHardlinks(t *testing.T) {
+	s := memory.NewMemoryStorage()
+
+	src := fs.NewMemoryFSRoot("")
+	mkfile(t, src, "a", false, []byte("foo"))
+	a, _ := src.GetChild("a")
+	sub, _ := src.CreateChildDir("sub")
+	sub.CreateChildHardlink("b", a.(fs.RegularFile))
+	src.CreateChildSpecial("null", fs.FCharDev, 1, 3)
+	src.CreateChildSpecial("pipe", fs.FFifo, 0, 0)
+
+	id, err := WriteDir(s, "", src, cache.NopCache{}, logging.NewNopLog(), WriteDirOptions{})
+	if err != nil {
+		t.Fatalf("Could not WriteDir: %s", err)
+	}
+
+	root := fs.NewMemoryFSRoot("")
+	if err := RestoreDir(s, id, root, logging.NewNopLog(), RestoreOptions{}); err != nil {
+		t.Fatalf("Unexpected error from RestoreDir(): %s", err)
+	}
+
+	var group string
+	withChildOfType(t, root, "a", fs.FFile, func(t *testing.T, f fs.File) {
+		if group = f.(fs.RegularFile).HardlinkGroup(); group == "" {
+			t.Errorf("a is not hardlinked")
+		}
+	})
+	withChildOfType(t, root, "sub", fs.FDir, wantDir(1, func(t *testing.T, d fs.Dir) {
+		withChildOfType(t, d, "b", fs.FFile, func(t *testing.T, f fs.File) {
+			if have := f.(fs.RegularFile).HardlinkGroup(); have != group {
+				t.Errorf("b is not a hardlink of a")
+			}
+		})
+	}))
+	withChildOfType(t, root, "null", fs.FCharDev, func(t *testing.T, f fs.File) {
+		if major, minor := f.(fs.Special).DeviceNumber(); major != 1 || minor != 3 {
+			t.Errorf("Unexpected device number %d,%d", major, minor)
+		}
+	})
+	withChildOfType(t, root, "pipe", fs.FFifo, func(t *testing.T, f fs.File) {})
+}
@@ -0,0 +1,183 @@
+package backup
+
+import (
+	"code.laria.me/petrific/objects"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy describes which snapshots of an archive should be kept.
+//
+// Last keeps the Last most recent snapshots. Hourly, Daily, Weekly, Monthly and Yearly keep the most recent snapshot
+// of the respective last n hours, days, (ISO-)weeks, months or years that have snapshots. Within keeps all snapshots
+// that are at most Within older than the most recent snapshot.
+//
+// A snapshot is kept, if at least one rule wants to keep it.
+type RetentionPolicy struct {
+	Last, Hourly, Daily, Weekly, Monthly, Yearly int
+	Within                                       time.Duration
+}
+
+// ParseRetentionPolicy parses a policy in the form "key=value,key=value,...".
+// The keys are "last", "hourly", "daily", "weekly", "monthly", "yearly" (with a count as value)
+// and "within" (with a duration like "36h" as value, see time.ParseDuration).
+//
+// Example: "last=3,daily=7,weekly=4,monthly=12"
+func ParseRetentionPolicy(s string) (p RetentionPolicy, err error) {
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return p, fmt.Errorf("invalid retention rule \"%s\": expected key=value", part)
+		}
+		key, val := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+
+		if key == "within" {
+			if p.Within, err = time.ParseDuration(val); err != nil {
+				return p, fmt.Errorf("invalid retention rule \"%s\": %s", part, err)
+			}
+			continue
+		}
+
+		var count *int
+		switch key {
+		case "last":
+			count = &p.Last
+		case "hourly":
+			count = &p.Hourly
+		case "daily":
+			count = &p.Daily
+		case "weekly":
+			count = &p.Weekly
+		case "monthly":
+			count = &p.Monthly
+		case "yearly":
+			count = &p.Yearly
+		default:
+			return p, fmt.Errorf("unknown retention rule \"%s\"", key)
+		}
+
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("invalid retention rule \"%s\": expected a non-negative number", part)
+		}
+		*count = n
+	}
+
+	if p.IsEmpty() {
+		return p, errors.New("the retention policy is empty, it would not keep any snapshot")
+	}
+
+	return p, nil
+}
+
+func (p RetentionPolicy) IsEmpty() bool {
+	return p == RetentionPolicy{}
+}
+
+func (p RetentionPolicy) String() string {
+	parts := []string{}
+	add := func(key string, n int) {
+		if n > 0 {
+			parts = append(parts, key+"="+strconv.Itoa(n))
+		}
+	}
+
+	add("last", p.Last)
+	add("hourly", p.Hourly)
+	add("daily", p.Daily)
+	add("weekly", p.Weekly)
+	add("monthly", p.Monthly)
+	add("yearly", p.Yearly)
+	if p.Within > 0 {
+		parts = append(parts, "within="+p.Within.String())
+	}
+
+	return strings.Join(parts, ",")
+}
+
+type retentionBucket func(time.Time) string
+
+func bucketFormat(layout string) retentionBucket {
+	return func(t time.Time) string { return t.Format(layout) }
+}
+
+func weekBucket(t time.Time) string {
+	y, w := t.ISOWeek()
+	return fmt.Sprintf("%04d-W%02d", y, w)
+}
+
+// Apply decides which of the given snapshots should be kept. The snapshots are expected to belong to the same archive.
+// It returns a slice with an entry for each snapshot (in the order given), that is true if the snapshot should be kept.
+// An empty policy keeps all snapshots.
+func (p RetentionPolicy) Apply(snapshots []objects.Snapshot) []bool {
+	keep := make([]bool, len(snapshots))
+	if p.IsEmpty() {
+		for i := range keep {
+			keep[i] = true
+		}
+		return keep
+	}
+
+	// Indexes of the snapshots, most recent first
+	order := make([]int, len(snapshots))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return snapshots[order[a]].Date.After(snapshots[order[b]].Date)
+	})
+
+	for i := 0; i < p.Last && i < len(order); i++ {
+		keep[order[i]] = true
+	}
+
+	rules := []struct {
+		n      int
+		bucket retentionBucket
+	}{
+		{p.Hourly, bucketFormat("2006-01-02 15")},
+		{p.Daily, bucketFormat("2006-01-02")},
+		{p.Weekly, weekBucket},
+		{p.Monthly, bucketFormat("2006-01")},
+		{p.Yearly, bucketFormat("2006")},
+	}
+
+	for _, rule := range rules {
+		remaining := rule.n
+		last := ""
+		for _, i := range order {
+			if remaining == 0 {
+				break
+			}
+
+			b := rule.bucket(snapshots[i].Date)
+			if b == last {
+				continue
+			}
+
+			keep[i] = true
+			last = b
+			remaining--
+		}
+	}
+
+	if p.Within > 0 && len(order) > 0 {
+		oldest := snapshots[order[0]].Date.Add(-p.Within)
+		for _, i := range order {
+			if !snapshots[i].Date.Before(oldest) {
+				keep[i] = true
+			}
+		}
+	}
+
+	return keep
+}
@@ -0,0 +1,93 @@
+package backup
+
+import (
+	"code.laria.me/petrific/objects"
+	"testing"
+	"time"
+)
+
+func TestParseRetentionPolicy(t *testing.T) {
+	p, err := ParseRetentionPolicy("last=3, daily=7,weekly=4,monthly=12,within=36h")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	want := RetentionPolicy{Last: 3, Daily: 7, Weekly: 4, Monthly: 12, Within: 36 * time.Hour}
+	if p != want {
+		t.Errorf("Unexpected policy: %+v", p)
+	}
+
+	if p.String() != "last=3,daily=7,weekly=4,monthly=12,within=36h0m0s" {
+		t.Errorf("Unexpected String(): %s", p)
+	}
+
+	for _, invalid := range []string{"", "last=0", "foo=1", "last", "daily=-1", "within=forever"} {
+		if _, err := ParseRetentionPolicy(invalid); err == nil {
+			t.Errorf("Parsing \"%s\" succeeded unexpectedly", invalid)
+		}
+	}
+}
+
+// nightlySnapshots generates one snapshot per day at 02:00, starting at 2018-01-01 (a monday), in chronological order
+func nightlySnapshots(n int) []objects.Snapshot {
+	snapshots := make([]objects.Snapshot, n)
+	for i := range snapshots {
+		snapshots[i] = objects.Snapshot{
+			Archive: "foo",
+			Date:    time.Date(2018, 1, 1+i, 2, 0, 0, 0, time.UTC),
+		}
+	}
+	return snapshots
+}
+
+func keptDays(snapshots []objects.Snapshot, keep []bool) []string {
+	days := []string{}
+	for i, s := range snapshots {
+		if keep[i] {
+			days = append(days, s.Date.Format("2006-01-02"))
+		}
+	}
+	return days
+}
+
+func TestRetentionPolicyApply(t *testing.T) {
+	snapshots := nightlySnapshots(70) // 2018-01-01 .. 2018-03-11
+
+	subtests := []struct {
+		name   string
+		policy RetentionPolicy
+		want   []string
+	}{
+		{"last", RetentionPolicy{Last: 2}, []string{"2018-03-10", "2018-03-11"}},
+		{"daily", RetentionPolicy{Daily: 3}, []string{"2018-03-09", "2018-03-10", "2018-03-11"}},
+		{"weekly", RetentionPolicy{Weekly: 3}, []string{"2018-02-25", "2018-03-04", "2018-03-11"}},
+		{"monthly", RetentionPolicy{Monthly: 5}, []string{"2018-01-31", "2018-02-28", "2018-03-11"}},
+		{"yearly", RetentionPolicy{Yearly: 1}, []string{"2018-03-11"}},
+		{"within", RetentionPolicy{Within: 48 * time.Hour}, []string{"2018-03-09", "2018-03-10", "2018-03-11"}},
+		{"combined", RetentionPolicy{Last: 1, Monthly: 2}, []string{"2018-02-28", "2018-03-11"}},
+	}
+
+	for _, subtest := range subtests {
+		have := keptDays(snapshots, subtest.policy.Apply(snapshots))
+
+		if len(have) != len(subtest.want) {
+			t.Errorf("%s: kept %v, want %v", subtest.name, have, subtest.want)
+			continue
+		}
+		for i := range have {
+			if have[i] != subtest.want[i] {
+				t.Errorf("%s: kept %v, want %v", subtest.name, have, subtest.want)
+				break
+			}
+		}
+	}
+}
+
+func TestEmptyRetentionPolicyKeepsAll(t *testing.T) {
+	snapshots := nightlySnapshots(5)
+	for i, keep := range (RetentionPolicy{}).Apply(snapshots) {
+		if !keep {
+			t.Errorf("snapshot %d would not be kept", i)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package backup
+
+import (
+	"code.laria.me/petrific/fs"
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"io"
+)
+
+// Holes smaller than this are stored as data, so sparse files don't get split into too many fragments
+const minHoleSize = 64 * 1024
+
+func mergeSmallHoles(regions []fs.Region) []fs.Region {
+	merged := []fs.Region{}
+	for _, r := range regions {
+		if r.Hole && r.Length < minHoleSize {
+			r.Hole = false
+		}
+
+		if n := len(merged); n > 0 && merged[n-1].Hole == r.Hole {
+			merged[n-1].Length += r.Length
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	return merged
+}
+
+func hasHoles(regions []fs.Region) bool {
+	for _, r := range regions {
+		if r.Hole {
+			return true
+		}
+	}
+	return false
+}
+
+// writeSparseFile stores a file as hole fragments and the chunked data regions
+func writeSparseFile(store storage.Storage, sr fs.SparseReader, regions []fs.Region, chunking Chunking) (objects.ObjectId, error) {
+	fragments := make(objects.File, 0)
+
+	for _, r := range regions {
+		if r.Hole {
+			fragments = append(fragments, objects.FileFragment{Hole: true, Size: uint64(r.Length)})
+			continue
+		}
+
+		var err error
+		if fragments, err = writeFragments(store, fragments, io.NewSectionReader(sr, r.Offset, r.Length), chunking); err != nil {
+			return objects.ObjectId{}, err
+		}
+	}
+
+	return storage.SetObject(store, objects.ToRawObject(&fragments))
+}
+
+// sparseWriter is implemented by writers that can create holes by seeking past the end (like *os.File)
+type sparseWriter interface {
+	io.Writer
+	io.Seeker
+	Truncate(size int64) error
+}
+
+// writeHole writes a hole of the given size. If w is not sparse, zeros are written.
+func writeHole(w io.Writer, sparse bool, size uint64) error {
+	if sparse {
+		_, err := w.(sparseWriter).Seek(int64(size), io.SeekCurrent)
+		return err
+	}
+
+	zeros := make([]byte, 64*1024)
+	for size > 0 {
+		n := uint64(len(zeros))
+		if size < n {
+			n = size
+		}
+		if _, err := w.Write(zeros[:n]); err != nil {
+			return err
+		}
+		size -= n
+	}
+	return nil
+}
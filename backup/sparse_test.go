@@ -0,0 +1,86 @@
+package backup
+
+import (
+	"bytes"
+	"code.laria.me/petrific/fs"
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"code.laria.me/petrific/storage/memory"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testSparseReader struct {
+	*bytes.Reader
+	regions []fs.Region
+}
+
+func (r testSparseReader) Regions() ([]fs.Region, error) {
+	return r.regions, nil
+}
+
+func TestSparseFile(t *testing.T) {
+	content := make([]byte, 300000)
+	copy(content, []byte("foo"))
+	copy(content[200000:], []byte("bar"))
+
+	r := testSparseReader{bytes.NewReader(content), []fs.Region{
+		{Offset: 0, Length: 4096},
+		{Offset: 4096, Length: 4096, Hole: true}, // Too small, stored as data
+		{Offset: 8192, Length: 4096},
+		{Offset: 12288, Length: 200000 - 12288, Hole: true},
+		{Offset: 200000, Length: 4096},
+		{Offset: 204096, Length: 300000 - 204096, Hole: true},
+	}}
+
+	s := memory.NewMemoryStorage()
+	id, err := WriteFile(s, r)
+	if err != nil {
+		t.Fatalf("Unexpected error when writing file: %s", err)
+	}
+
+	file_obj, err := storage.GetObjectOfType(s, id, objects.OTFile)
+	if err != nil {
+		t.Fatalf("Could not get file: %s", err)
+	}
+	file := *file_obj.(*objects.File)
+
+	wantHoles := []bool{false, true, false, true}
+	wantSizes := []uint64{12288, 200000 - 12288, 4096, 300000 - 204096}
+	if len(file) != len(wantHoles) {
+		t.Fatalf("Unexpected fragments: %v", file)
+	}
+	for i, fragment := range file {
+		if fragment.Hole != wantHoles[i] || fragment.Size != wantSizes[i] {
+			t.Errorf("Unexpected fragment %d: %v", i, fragment)
+		}
+	}
+
+	// Not sparse
+	buf := new(bytes.Buffer)
+	if err := RestoreFile(s, id, buf); err != nil {
+		t.Fatalf("Unexpected error from RestoreFile(): %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), content) {
+		t.Errorf("Unexpected content of restored file")
+	}
+
+	// Sparse
+	p := filepath.Join(t.TempDir(), "sparse")
+	fh, err := os.Create(p)
+	if err != nil {
+		t.Fatalf("Could not create file: %s", err)
+	}
+	if err := restoreFile(s, id, fh, true); err != nil {
+		t.Fatalf("Unexpected error from restoreFile(): %s", err)
+	}
+	fh.Close()
+
+	if have, err := ioutil.ReadFile(p); err != nil {
+		t.Fatalf("Could not read restored file: %s", err)
+	} else if !bytes.Equal(have, content) {
+		t.Errorf("Unexpected content of restored sparse file (size %d)", len(have))
+	}
+}
@@ -0,0 +1,77 @@
+package backup
+
+import (
+	"code.laria.me/petrific/cache"
+	"code.laria.me/petrific/fs"
+	"code.laria.me/petrific/logging"
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"sync"
+)
+
+// dryRunStorage keeps trees in memory and discards all other objects. Everything else is read from the base storage.
+type dryRunStorage struct {
+	storage.Storage
+
+	mu    *sync.Mutex
+	trees map[string][]byte
+}
+
+func newDryRunStorage(base storage.Storage) dryRunStorage {
+	return dryRunStorage{base, new(sync.Mutex), make(map[string][]byte)}
+}
+
+func (drs dryRunStorage) Get(id objects.ObjectId) ([]byte, error) {
+	drs.mu.Lock()
+	raw, ok := drs.trees[id.String()]
+	drs.mu.Unlock()
+
+	if ok {
+		return raw, nil
+	}
+	return drs.Storage.Get(id)
+}
+
+func (drs dryRunStorage) Has(id objects.ObjectId) (bool, error) {
+	drs.mu.Lock()
+	_, ok := drs.trees[id.String()]
+	drs.mu.Unlock()
+
+	if ok {
+		return true, nil
+	}
+	return drs.Storage.Has(id)
+}
+
+func (drs dryRunStorage) Set(id objects.ObjectId, typ objects.ObjectType, raw []byte) error {
+	if typ == objects.OTTree {
+		drs.mu.Lock()
+		drs.trees[id.String()] = raw
+		drs.mu.Unlock()
+	}
+	return nil
+}
+
+// VerifyDir compares the directory d with the tree tree_id without writing anything to the storage.
+// All file contents are read and hashed (the cache is not used), changes are reported like in DiffTrees,
+// with the tree being the old and the directory being the new side.
+// opts must match the options used when writing the tree, otherwise files can be reported as modified,
+// because they were split differently.
+func VerifyDir(
+	store storage.Storage,
+	abspath string,
+	d fs.Dir,
+	tree_id objects.ObjectId,
+	log *logging.Log,
+	opts WriteDirOptions,
+	fn func(Change) error,
+) error {
+	drs := newDryRunStorage(store)
+
+	dir_id, err := WriteDir(drs, abspath, d, cache.NopCache{}, log, opts)
+	if err != nil {
+		return err
+	}
+
+	return DiffTrees(drs, tree_id, dir_id, fn)
+}
@@ -0,0 +1,51 @@
+package backup
+
+import (
+	"code.laria.me/petrific/fs"
+	"code.laria.me/petrific/logging"
+	"code.laria.me/petrific/objects"
+	"reflect"
+	"testing"
+)
+
+func TestVerifyDir(t *testing.T) {
+	s := storageWithTestTree()
+
+	root := fs.NewMemoryFSRoot("root")
+	if err := RestoreDir(s, objid_testtree, root, logging.NewNopLog(), RestoreOptions{}); err != nil {
+		t.Fatalf("RestoreDir failed: %s", err)
+	}
+
+	verify := func() []string {
+		have := []string{}
+		err := VerifyDir(s, "", root, objid_testtree, logging.NewNopLog(), WriteDirOptions{}, func(c Change) error {
+			have = append(have, string(c.Kind)+" "+c.Path)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("VerifyDir failed: %s", err)
+		}
+		return have
+	}
+
+	if have := verify(); len(have) != 0 {
+		t.Errorf("Unexpected changes in restored dir: %v", have)
+	}
+
+	mkfile(t, root, "foo", false, []byte("changed"))
+	mkfile(t, root, "new", false, []byte("new content"))
+
+	have := verify()
+	want := []string{"modified /foo", "added /new"}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("Unexpected changes:\nhave: %v\nwant: %v", have, want)
+	}
+
+	// Nothing may have been written to the storage
+	if blobs, _ := s.List(objects.OTBlob); len(blobs) != 1 {
+		t.Errorf("VerifyDir wrote to storage, have %d blobs", len(blobs))
+	}
+	if trees, _ := s.List(objects.OTTree); len(trees) != 3 {
+		t.Errorf("VerifyDir wrote to storage, have %d trees", len(trees))
+	}
+}
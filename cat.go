@@ -0,0 +1,101 @@
+package main
+
+import (
+	"code.laria.me/petrific/backup"
+	"code.laria.me/petrific/objects"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// splitSnapshotPath splits "snapshot-id:/path" into the snapshot id and the path.
+// Since object ids contain a colon themselves, the path starts after the second colon.
+func splitSnapshotPath(s string) (id objects.ObjectId, p string, err error) {
+	algo_end := strings.Index(s, ":")
+	if algo_end < 0 {
+		err = fmt.Errorf("invalid object id %s", s)
+		return
+	}
+
+	id_end := strings.Index(s[algo_end+1:], ":")
+	if id_end < 0 {
+		err = fmt.Errorf("%s contains no path", s)
+		return
+	}
+	id_end += algo_end + 1
+
+	id, err = objects.ParseObjectId(s[:id_end])
+	p = s[id_end+1:]
+	return
+}
+
+// resolveFile resolves a path inside a snapshot to the id of a file object
+func resolveFile(env *Env, snapshot_id objects.ObjectId, archive, p string) (objects.ObjectId, error) {
+	snapshot, err := loadTrustedSnapshot(env, snapshot_id, archive)
+	if err != nil {
+		return objects.ObjectId{}, err
+	}
+
+	entry, err := backup.LookupPath(env.Store, snapshot.Tree, p)
+	if err != nil {
+		return objects.ObjectId{}, err
+	}
+
+	switch e := entry.(type) {
+	case objects.TreeEntryFile:
+		return e.Ref, nil
+	case objects.TreeEntrySymlink:
+		return objects.ObjectId{}, fmt.Errorf("%s is a symlink to %s", p, e.Target)
+	default:
+		return objects.ObjectId{}, fmt.Errorf("%s is not a regular file", p)
+	}
+}
+
+func Cat(env *Env, args []string) int {
+	flags := flag.NewFlagSet(os.Args[0]+" cat", flag.ContinueOnError)
+	archive := flags.String("archive", "", "read the path from the latest trusted snapshot of this archive")
+
+	flags.Usage = subcmdUsage("cat", "[flags] (snapshot-id:/path | file-id | -archive archive /path)", flags)
+	errout := subcmdErrout(env.Log, "cat")
+
+	if err := flags.Parse(args); err != nil {
+		errout(err)
+		return 2
+	}
+
+	args = flags.Args()
+	if len(args) != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	var file_id objects.ObjectId
+	var err error
+	if *archive != "" {
+		file_id, err = resolveFile(env, objects.ObjectId{}, *archive, args[0])
+	} else if id, perr := objects.ParseObjectId(args[0]); perr == nil {
+		file_id = id
+	} else {
+		var snapshot_id objects.ObjectId
+		var p string
+		if snapshot_id, p, err = splitSnapshotPath(args[0]); err != nil {
+			errout(err)
+			flags.Usage()
+			return 2
+		}
+
+		file_id, err = resolveFile(env, snapshot_id, "", p)
+	}
+
+	if err != nil {
+		errout(err)
+		return 1
+	}
+
+	if err := backup.RestoreFile(env.Store, file_id, os.Stdout); err != nil {
+		errout(err)
+		return 1
+	}
+	return 0
+}
@@ -15,8 +15,47 @@
 //    cache_path = "~/.cache/petrific.cache"
 //
 //    [signing]
+//    # Sign snapshots with OpenPGP ("gpg", the default) or with an ed25519 SSH key ("ssh")
+//    method = "gpg"
 //    # Use this GPG key to sign snapshots
 //    key = "0123456789ABCDEF0123456789ABCDEF01234567"
+//    # Sign with the secret key from this armored key file instead of running gpg (key is optional then and
+//    # selects a key from the file). passphrase_file is only needed for encrypted keys.
+//    key_file = "~/.config/petrific/signing-key.asc"
+//    passphrase_file = "~/.config/petrific/signing-key.pass"
+//    # Verify snapshots with the keys from these armored key files instead of the gpg keyring.
+//    # If key_file is set, its key is also used for verification.
+//    public_key_files = ["~/.config/petrific/colleague.asc"]
+//    # The ed25519 SSH key used by the "ssh" method (passphrase_file is used for encrypted keys) and further public keys
+//    # (in authorized_keys format) to verify SSH signatures with.
+//    ssh_key_file = "~/.ssh/id_ed25519"
+//    ssh_public_key_files = ["~/.config/petrific/colleague.pub"]
+//    # Only trust snapshot signatures by these keys (full fingerprints, "SHA256:..." for SSH keys). Without this,
+//    # every key of the keyring (or the key files) is trusted.
+//    trusted_keys = ["0123456789ABCDEF0123456789ABCDEF01234567"]
+//    # Reject unsigned snapshots when restoring
+//    require_signature = true
+//
+//    [chunking]
+//    # How files are split into blobs. "fixed" (the default) splits into blobs of avg_size (default 16MB),
+//    # "fastcdc" splits by content into blobs between min_size and max_size bytes (defaults: 512KB, 1MB, 8MB),
+//    # which deduplicates changed files much better.
+//    method = "fastcdc"
+//
+//    # The archive.* sections define per-archive settings (optional)
+//    [archive.home]
+//    # Retention policy used by `petrific forget` (see backup.ParseRetentionPolicy)
+//    keep = "last=3,daily=7,weekly=4,monthly=12"
+//    # Exclude rules used by `petrific take-snapshot` (gitignore-style patterns, see backup.PathPattern).
+//    # Additional rules are read from .petrificignore files in the backed up directories.
+//    exclude = ["node_modules/", "*.o", "/Downloads"]
+//    exclude_caches = true # Skip directories tagged with CACHEDIR.TAG
+//    max_file_size = 1073741824 # Skip files larger than 1GB
+//    one_file_system = true # Don't descend into other mounted filesystems ...
+//    allowed_mounts = ["/home"] # ... except these
+//    # Signature policy for snapshots of this archive, trusted_keys replaces the one from [signing]
+//    trusted_keys = ["89ABCDEF0123456789ABCDEF0123456789ABCDEF"]
+//    require_signature = true
 //
 //    # The storage.* sections define storage backends.
 //    # Every section must contain the key `method`, the other keys depend on the selected method.
@@ -31,14 +70,23 @@
 //    base="local"
 //    encode=["zlib-flate", "-compress"]
 //    decode=["zlib-flate", "-uncompress"]
+//
+//    [storage.local_compressed_zstd]
+//    method="compress"
+//    base="local"
+//    algorithm="zstd"
 package config
 
 import (
+	"bytes"
 	"code.laria.me/petrific/gpg"
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/sshsig"
 	"errors"
 	"fmt"
 	"github.com/BurntSushi/toml"
 	"github.com/adrg/xdg"
+	"io/ioutil"
 	"os"
 	"os/user"
 	"strings"
@@ -61,12 +109,43 @@ type Config struct {
 	CachePath      string `toml:"cache_path,omitempty"`
 	DefaultStorage string `toml:"default_storage"`
 	Signing        struct {
-		Key string
+		Method         string `toml:"method,omitempty"`
+		Key            string
+		KeyFile        string   `toml:"key_file,omitempty"`
+		PassphraseFile string   `toml:"passphrase_file,omitempty"`
+		PublicKeyFiles []string `toml:"public_key_files,omitempty"`
+
+		SSHKeyFile        string   `toml:"ssh_key_file,omitempty"`
+		SSHPublicKeyFiles []string `toml:"ssh_public_key_files,omitempty"`
+
+		TrustedKeys      []string `toml:"trusted_keys,omitempty"`
+		RequireSignature bool     `toml:"require_signature,omitempty"`
+	}
+	Chunking struct {
+		Method  string `toml:"method,omitempty"`
+		MinSize int    `toml:"min_size,omitempty"`
+		AvgSize int    `toml:"avg_size,omitempty"`
+		MaxSize int    `toml:"max_size,omitempty"`
 	}
+	Archive map[string]ArchiveConfig `toml:"archive,omitempty"`
 	Storage map[string]toml.Primitive
 	meta    toml.MetaData `toml:"-"`
 }
 
+// ArchiveConfig holds settings for a single archive
+type ArchiveConfig struct {
+	Keep string `toml:"keep,omitempty"` // Retention policy
+
+	Exclude       []string `toml:"exclude,omitempty"`
+	ExcludeCaches bool     `toml:"exclude_caches,omitempty"`
+	MaxFileSize   int64    `toml:"max_file_size,omitempty"`
+	OneFileSystem bool     `toml:"one_file_system,omitempty"`
+	AllowedMounts []string `toml:"allowed_mounts,omitempty"`
+
+	TrustedKeys      []string `toml:"trusted_keys,omitempty"`
+	RequireSignature bool     `toml:"require_signature,omitempty"`
+}
+
 func LoadConfig(path string) (config Config, err error) {
 	if path == "" {
 		path, err = xdg.ConfigFile("petrific/config.toml")
@@ -84,6 +163,105 @@ func (c Config) GPGSigner() gpg.Signer {
 	return gpg.Signer{Key: c.Signing.Key}
 }
 
+func (c Config) passphrase() ([]byte, error) {
+	if c.Signing.PassphraseFile == "" {
+		return nil, nil
+	}
+
+	raw, err := ioutil.ReadFile(ExpandTilde(c.Signing.PassphraseFile))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(raw, "\r\n"), nil
+}
+
+// Signer returns the signer for snapshots, selected by the signing method. For the "gpg" method without a key_file,
+// the gpg binary is used.
+func (c Config) Signer() (objects.Signer, error) {
+	switch c.Signing.Method {
+	case "", "gpg":
+		if c.Signing.KeyFile == "" {
+			return c.GPGSigner(), nil
+		}
+
+		passphrase, err := c.passphrase()
+		if err != nil {
+			return nil, err
+		}
+		return gpg.NewNativeSigner(ExpandTilde(c.Signing.KeyFile), c.Signing.Key, passphrase)
+	case "ssh":
+		if c.Signing.SSHKeyFile == "" {
+			return nil, errors.New("signing method ssh needs ssh_key_file")
+		}
+
+		passphrase, err := c.passphrase()
+		if err != nil {
+			return nil, err
+		}
+		return sshsig.NewSigner(ExpandTilde(c.Signing.SSHKeyFile), passphrase)
+	default:
+		return nil, fmt.Errorf("unknown signing method %s", c.Signing.Method)
+	}
+}
+
+// Verifyers returns the verifyers for snapshot signatures. OpenPGP signatures are verified by the gpg binary, if no
+// key files are configured. SSH signatures can only be verified, if SSH keys are configured.
+func (c Config) Verifyers() (objects.Verifyers, error) {
+	verifyers := make(objects.Verifyers)
+
+	paths := []string{}
+	if c.Signing.KeyFile != "" {
+		paths = append(paths, ExpandTilde(c.Signing.KeyFile))
+	}
+	for _, path := range c.Signing.PublicKeyFiles {
+		paths = append(paths, ExpandTilde(path))
+	}
+
+	if len(paths) == 0 {
+		verifyers[objects.SignatureOpenPGP] = gpg.Verifyer{}
+	} else {
+		v, err := gpg.NewNativeVerifyer(paths...)
+		if err != nil {
+			return nil, err
+		}
+		verifyers[objects.SignatureOpenPGP] = v
+	}
+
+	paths = []string{}
+	if c.Signing.SSHKeyFile != "" {
+		paths = append(paths, ExpandTilde(c.Signing.SSHKeyFile))
+	}
+	for _, path := range c.Signing.SSHPublicKeyFiles {
+		paths = append(paths, ExpandTilde(path))
+	}
+
+	if len(paths) > 0 {
+		v, err := sshsig.NewVerifyer(paths...)
+		if err != nil {
+			return nil, err
+		}
+		verifyers[objects.SignatureSSH] = v
+	}
+
+	return verifyers, nil
+}
+
+// SignaturePolicy returns the policy for verifying snapshots of an archive.
+// The trusted keys of the archive replace the ones from the [signing] section. A signature is required, if either
+// section requires it.
+func (c Config) SignaturePolicy(archive string) objects.SignaturePolicy {
+	archive_conf := c.Archive[archive]
+
+	policy := objects.SignaturePolicy{
+		RequireSignature: c.Signing.RequireSignature || archive_conf.RequireSignature,
+		TrustedKeys:      c.Signing.TrustedKeys,
+	}
+	if len(archive_conf.TrustedKeys) > 0 {
+		policy.TrustedKeys = archive_conf.TrustedKeys
+	}
+	return policy
+}
+
 func (c Config) GetStorageMethod(name string) (string, error) {
 	prim, ok := c.Storage[name]
 	if !ok {
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"code.laria.me/petrific/backup"
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// treeOf returns the id of the tree and the archive of a snapshot, if it is accepted by the signature policy of its
+// archive. If id refers to a tree, it is returned unchanged (and the archive is empty).
+func treeOf(env *Env, id objects.ObjectId) (objects.ObjectId, string, error) {
+	rawobj, err := storage.GetObject(env.Store, id)
+	if err != nil {
+		return id, "", err
+	}
+
+	switch rawobj.Type {
+	case objects.OTTree:
+		return id, "", nil
+	case objects.OTSnapshot:
+		snapshot, err := loadTrustedSnapshot(env, id, "")
+		if err != nil {
+			return id, "", err
+		}
+		return snapshot.Tree, snapshot.Archive, nil
+	default:
+		return id, "", fmt.Errorf("%s is a %s, expected a snapshot or tree", id, rawobj.Type)
+	}
+}
+
+type jsonTreeEntry struct {
+	Type     objects.TreeEntryType `json:"type"`
+	ACL      string                `json:"acl"`
+	User     string                `json:"user,omitempty"`
+	Group    string                `json:"group,omitempty"`
+	Ref      string                `json:"ref,omitempty"`
+	Target   string                `json:"target,omitempty"`
+	Hardlink string                `json:"hardlink,omitempty"`
+	Major    *uint32               `json:"major,omitempty"` // Only for devices
+	Minor    *uint32               `json:"minor,omitempty"`
+
+	Mode       string            `json:"mode,omitempty"` // Octal, like chmod(1) expects it
+	Uid        *int              `json:"uid,omitempty"`
+	Gid        *int              `json:"gid,omitempty"`
+	DefaultACL string            `json:"default_acl,omitempty"`
+	Xattrs     map[string]string `json:"xattrs,omitempty"`
+}
+
+func toJSONTreeEntry(entry objects.TreeEntry) *jsonTreeEntry {
+	if entry == nil {
+		return nil
+	}
+
+	out := &jsonTreeEntry{
+		Type:  entry.Type(),
+		ACL:   entry.ACL().String(),
+		User:  entry.User(),
+		Group: entry.Group(),
+	}
+
+	m := entry.Metadata()
+	if m.Mode != 0 {
+		out.Mode = fmt.Sprintf("%04o", m.UnixMode())
+	}
+	if m.HasOwner {
+		out.Uid = &m.Uid
+		out.Gid = &m.Gid
+	}
+	if !m.DefaultACL.IsEmpty() {
+		out.DefaultACL = m.DefaultACL.String()
+	}
+	out.Xattrs = m.Xattrs
+
+	switch e := entry.(type) {
+	case objects.TreeEntryFile:
+		out.Ref = e.Ref.String()
+		out.Hardlink = e.Hardlink
+	case objects.TreeEntryDir:
+		out.Ref = e.Ref.String()
+	case objects.TreeEntrySymlink:
+		out.Target = e.Target
+	case objects.TreeEntrySpecial:
+		if e.Kind == objects.TETBlockDev || e.Kind == objects.TETCharDev {
+			out.Major = &e.Major
+			out.Minor = &e.Minor
+		}
+	}
+
+	return out
+}
+
+type jsonChange struct {
+	Path   string            `json:"path"`
+	Change backup.ChangeKind `json:"change"`
+	Old    *jsonTreeEntry    `json:"old,omitempty"`
+	New    *jsonTreeEntry    `json:"new,omitempty"`
+}
+
+func printChangeJSON(enc *json.Encoder) func(backup.Change) error {
+	return func(c backup.Change) error {
+		return enc.Encode(jsonChange{
+			Path:   c.Path,
+			Change: c.Kind,
+			Old:    toJSONTreeEntry(c.Old),
+			New:    toJSONTreeEntry(c.New),
+		})
+	}
+}
+
+// describeMetadata describes everything that is compared to detect a metadata change (i.e. everything but timestamps)
+func describeMetadata(entry objects.TreeEntry) string {
+	s := fmt.Sprintf("%s %s:%s", entry.ACL(), orDash(entry.User()), orDash(entry.Group()))
+
+	m := entry.Metadata()
+	if m.Mode != 0 {
+		s += fmt.Sprintf(" mode=%04o", m.UnixMode())
+	}
+	if m.HasOwner {
+		s += fmt.Sprintf(" uid=%d gid=%d", m.Uid, m.Gid)
+	}
+	if !m.DefaultACL.IsEmpty() {
+		s += " default_acl=" + m.DefaultACL.String()
+	}
+	if file, ok := entry.(objects.TreeEntryFile); ok && file.Hardlink != "" {
+		s += " hardlink=" + file.Hardlink
+	}
+
+	names := make([]string, 0, len(m.Xattrs))
+	for name := range m.Xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s += fmt.Sprintf(" xattr.%s=%q", name, m.Xattrs[name])
+	}
+
+	return s
+}
+
+func printChange(c backup.Change) error {
+	switch c.Kind {
+	case backup.ChangeAdded:
+		fmt.Printf("+ %s (%s)\n", c.Path, c.New.Type())
+	case backup.ChangeRemoved:
+		fmt.Printf("- %s (%s)\n", c.Path, c.Old.Type())
+	case backup.ChangeModified:
+		if old, ok := c.Old.(objects.TreeEntrySpecial); ok {
+			new := c.New.(objects.TreeEntrySpecial)
+			fmt.Printf("M %s: %d:%d -> %d:%d\n", c.Path, old.Major, old.Minor, new.Major, new.Minor)
+		} else {
+			fmt.Printf("M %s\n", c.Path)
+		}
+	case backup.ChangeType:
+		fmt.Printf("T %s: %s -> %s\n", c.Path, c.Old.Type(), c.New.Type())
+	case backup.ChangeMetadata:
+		fmt.Printf("P %s: %s -> %s\n", c.Path, describeMetadata(c.Old), describeMetadata(c.New))
+	case backup.ChangeTarget:
+		fmt.Printf("L %s: %s -> %s\n", c.Path, c.Old.(objects.TreeEntrySymlink).Target, c.New.(objects.TreeEntrySymlink).Target)
+	}
+	return nil
+}
+
+func Diff(env *Env, args []string) int {
+	flags := flag.NewFlagSet(os.Args[0]+" diff", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print one JSON object per change")
+
+	flags.Usage = subcmdUsage("diff", "[flags] old new\n\nold and new are ids of snapshots or trees.\n"+
+		"Changes are printed as: + added, - removed, M modified content, T type changed,\n"+
+		"P permissions, owner, other metadata or hardlink changed, L symlink target changed", flags)
+	errout := subcmdErrout(env.Log, "diff")
+
+	if err := flags.Parse(args); err != nil {
+		errout(err)
+		return 2
+	}
+
+	args = flags.Args()
+	if len(args) != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	trees := make([]objects.ObjectId, 2)
+	for i, arg := range args {
+		id, err := objects.ParseObjectId(arg)
+		if err != nil {
+			errout(fmt.Errorf("invalid id %s: %s", arg, err))
+			return 2
+		}
+
+		if trees[i], _, err = treeOf(env, id); err != nil {
+			errout(err)
+			return 1
+		}
+	}
+
+	fn := printChange
+	if *asJSON {
+		fn = printChangeJSON(json.NewEncoder(os.Stdout))
+	}
+
+	if err := backup.DiffTrees(env.Store, trees[0], trees[1], fn); err != nil {
+		errout(err)
+		return 1
+	}
+	return 0
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"code.laria.me/petrific/backup"
 	"code.laria.me/petrific/cache"
 	"code.laria.me/petrific/config"
 	"code.laria.me/petrific/logging"
@@ -42,6 +43,33 @@ func (env *Env) loadCache() error {
 	return nil
 }
 
+// WriteDirOptions builds the options for backup.WriteDir from the config.
+// If archive is not empty, the exclude settings of that archive are used.
+func (env *Env) WriteDirOptions(archive string) (opts backup.WriteDirOptions, err error) {
+	c := env.Conf.Chunking
+	if opts.Chunking, err = backup.ParseChunking(c.Method, c.MinSize, c.AvgSize, c.MaxSize); err != nil {
+		err = fmt.Errorf("chunking config: %s", err)
+		return
+	}
+
+	opts.IgnoreFile = backup.DefaultIgnoreFileName
+
+	if archive == "" {
+		return
+	}
+
+	archive_conf := env.Conf.Archive[archive]
+	if opts.Exclude, err = backup.ParseIgnoreRules("/", archive_conf.Exclude); err != nil {
+		err = fmt.Errorf("exclude rules of archive %s: %s", archive, err)
+		return
+	}
+	opts.ExcludeCaches = archive_conf.ExcludeCaches
+	opts.MaxFileSize = archive_conf.MaxFileSize
+	opts.OneFileSystem = archive_conf.OneFileSystem
+	opts.AllowedMounts = archive_conf.AllowedMounts
+	return
+}
+
 func NewEnv(log *logging.Log, confPath, storageName string) (*Env, error) {
 	env := new(Env)
 	env.Log = log
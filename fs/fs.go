@@ -11,20 +11,34 @@ const (
 	FFile    FileType = "file"
 	FDir     FileType = "dir"
 	FSymlink FileType = "symlink"
+
+	// Special files, these must implement Special
+	FBlockDev FileType = "blockdev"
+	FCharDev  FileType = "chardev"
+	FFifo     FileType = "fifo"
+	FSocket   FileType = "socket"
 )
 
 type File interface {
-	Type() FileType // Depending on type, the File must also implement RegularFile (FFile), Dir (FDir) or Symlink (FSymlink)
+	Type() FileType // Depending on type, the File must also implement RegularFile (FFile), Dir (FDir), Symlink (FSymlink) or Special
 	Name() string
-	Executable() bool // For now we will only record the executable bit instead of all permission bits
+	Executable() bool // Only used for the legacy ACL of tree entries, Metadata records the full mode
 	ModTime() time.Time
 	Delete() error
+
+	Metadata() (Metadata, error)
+	SetMetadata(Metadata) error
 }
 
 type RegularFile interface {
 	File
+	Size() int64
 	Open() (io.ReadCloser, error)
 	OpenWritable() (io.WriteCloser, error)
+
+	// HardlinkGroup identifies the underlying file, if it has more than one link (empty otherwise).
+	// Hardlinks of each other have the same group.
+	HardlinkGroup() string
 }
 
 type Dir interface {
@@ -35,11 +49,35 @@ type Dir interface {
 	CreateChildFile(name string, exec bool) (RegularFile, error)
 	CreateChildDir(name string) (Dir, error)
 	CreateChildSymlink(name string, target string) (Symlink, error)
+	CreateChildSpecial(name string, ft FileType, major, minor uint32) (Special, error) // major and minor are only used for devices
+	CreateChildHardlink(name string, target RegularFile) (RegularFile, error)
 
 	RenameChild(oldname, newname string) error
+
+	Device() uint64 // ID of the device containing the directory, used to detect mount points
 }
 
 type Symlink interface {
 	File
 	Readlink() (string, error)
 }
+
+type Special interface {
+	File
+	DeviceNumber() (major, minor uint32) // Only meaningful for FBlockDev and FCharDev
+}
+
+// Region is a part of a file, either containing data or a hole (reading as zeros)
+type Region struct {
+	Offset, Length int64
+	Hole           bool
+}
+
+// SparseReader can be implemented by the readers returned by RegularFile.Open, if the file might contain holes
+type SparseReader interface {
+	io.Reader
+	io.ReaderAt
+
+	// Regions returns the data regions and holes of the file, in order and covering the whole file
+	Regions() ([]Region, error)
+}
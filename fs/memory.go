@@ -3,6 +3,7 @@ package fs
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"time"
@@ -13,6 +14,7 @@ type memfsBase struct {
 	name   string
 	exec   bool
 	mtime  time.Time
+	meta   Metadata
 }
 
 type memfsChild interface {
@@ -25,6 +27,14 @@ func (b *memfsBase) setName(n string)  { b.name = n }
 func (b memfsBase) Executable() bool   { return b.exec }
 func (b memfsBase) ModTime() time.Time { return b.mtime }
 
+// Metadata returns the metadata set by SetMetadata (empty by default)
+func (b memfsBase) Metadata() (Metadata, error) { return b.meta, nil }
+
+func (b *memfsBase) SetMetadata(m Metadata) error {
+	b.meta = m
+	return nil
+}
+
 func (b memfsBase) Delete() error {
 	if b.parent == nil {
 		return errors.New("Root entry can not be deleted")
@@ -36,11 +46,29 @@ func (b memfsBase) Delete() error {
 type MemfsFile struct {
 	memfsBase
 	content     *bytes.Buffer
+	links       *int // Number of hardlinks sharing content
 	HasBeenRead bool
 }
 
 func (MemfsFile) Type() FileType { return FFile }
 
+func (f MemfsFile) HardlinkGroup() string {
+	if *f.links > 1 {
+		return fmt.Sprintf("%p", f.content)
+	}
+	return ""
+}
+
+func (f MemfsFile) Delete() error {
+	if err := f.memfsBase.Delete(); err != nil {
+		return err
+	}
+	*f.links--
+	return nil
+}
+
+func (f MemfsFile) Size() int64 { return int64(f.content.Len()) }
+
 func (f *MemfsFile) Open() (io.ReadCloser, error) {
 	return f, nil
 }
@@ -65,10 +93,16 @@ func (MemfsFile) Close() error {
 type MemfsDir struct {
 	memfsBase
 	children map[string]memfsChild
+	dev      uint64
 }
 
 func (MemfsDir) Type() FileType { return FDir }
 
+func (d MemfsDir) Device() uint64 { return d.dev }
+
+// SetDevice sets the device id of the directory, this can be used to simulate mount points
+func (d *MemfsDir) SetDevice(dev uint64) { d.dev = dev }
+
 func (d MemfsDir) Readdir() ([]File, error) {
 	l := make([]File, 0, len(d.children))
 
@@ -100,6 +134,36 @@ func (d MemfsDir) CreateChildFile(name string, exec bool) (RegularFile, error) {
 	child := MemfsFile{
 		memfsBase: d.createChildBase(name, exec),
 		content:   new(bytes.Buffer),
+		links:     new(int),
+	}
+	*child.links = 1
+	d.children[name] = &child
+	return &child, nil
+}
+
+func (d MemfsDir) CreateChildHardlink(name string, target RegularFile) (RegularFile, error) {
+	t, ok := target.(*MemfsFile)
+	if !ok {
+		return nil, errors.New("Hardlink target is not in a memory fs")
+	}
+
+	child := MemfsFile{
+		memfsBase: d.createChildBase(name, t.exec),
+		content:   t.content,
+		links:     t.links,
+	}
+	child.meta = t.meta
+	*child.links++
+	d.children[name] = &child
+	return &child, nil
+}
+
+func (d MemfsDir) CreateChildSpecial(name string, ft FileType, major, minor uint32) (Special, error) {
+	child := MemfsSpecial{
+		memfsBase: d.createChildBase(name, false),
+		ft:        ft,
+		major:     major,
+		minor:     minor,
 	}
 	d.children[name] = &child
 	return &child, nil
@@ -109,6 +173,7 @@ func (d MemfsDir) CreateChildDir(name string) (Dir, error) {
 	child := MemfsDir{
 		memfsBase: d.createChildBase(name, true),
 		children:  make(map[string]memfsChild),
+		dev:       d.dev,
 	}
 	d.children[name] = &child
 	return &child, nil
@@ -163,3 +228,15 @@ func (MemfsSymlink) Type() FileType { return FSymlink }
 func (s MemfsSymlink) Readlink() (string, error) {
 	return s.target, nil
 }
+
+type MemfsSpecial struct {
+	memfsBase
+	ft           FileType
+	major, minor uint32
+}
+
+func (s MemfsSpecial) Type() FileType { return s.ft }
+
+func (s MemfsSpecial) DeviceNumber() (major, minor uint32) {
+	return s.major, s.minor
+}
@@ -0,0 +1,159 @@
+package fs
+
+import (
+	"code.laria.me/petrific/acl"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Metadata describes the POSIX metadata of a file. Fields not supported by a File implementation are left empty.
+type Metadata struct {
+	Mode os.FileMode // Permission bits, os.ModeSetuid, os.ModeSetgid and os.ModeSticky
+
+	HasOwner    bool // Uid, Gid, User and Group are only valid, if this is set
+	Uid, Gid    int
+	User, Group string // Names of the owner, might be empty, if they could not be looked up
+
+	Mtime, Atime, Ctime time.Time
+
+	// POSIX ACLs, only set if the file has an extended ACL (or a directory a default ACL).
+	// Named entries use user and group names (or the numeric id, if there is no name).
+	ACL, DefaultACL acl.ACL
+
+	Xattrs map[string]string // Without the xattrs storing the ACLs
+}
+
+// ModeMask selects the mode bits stored in Metadata
+const ModeMask = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+type nameCache struct {
+	mu     sync.Mutex
+	byId   map[int]string
+	byName map[string]int
+}
+
+var (
+	userNames  = nameCache{byId: make(map[int]string), byName: make(map[string]int)}
+	groupNames = nameCache{byId: make(map[int]string), byName: make(map[string]int)}
+)
+
+func (nc *nameCache) name(id int, lookup func(string) (string, error)) string {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	if name, ok := nc.byId[id]; ok {
+		return name
+	}
+
+	name, err := lookup(strconv.Itoa(id))
+	if err != nil {
+		name = ""
+	}
+	nc.byId[id] = name
+	return name
+}
+
+func (nc *nameCache) id(name string, fallback int, lookup func(string) (string, error)) int {
+	if name == "" {
+		return fallback
+	}
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	id, ok := nc.byName[name]
+	if !ok {
+		id = -1 // Unknown name
+		if s, err := lookup(name); err == nil {
+			if parsed, err := strconv.Atoi(s); err == nil {
+				id = parsed
+			}
+		}
+		nc.byName[name] = id
+	}
+
+	if id < 0 {
+		return fallback
+	}
+	return id
+}
+
+func lookupUserName(uid int) string {
+	return userNames.name(uid, func(s string) (string, error) {
+		u, err := user.LookupId(s)
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	})
+}
+
+func lookupGroupName(gid int) string {
+	return groupNames.name(gid, func(s string) (string, error) {
+		g, err := user.LookupGroupId(s)
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	})
+}
+
+// resolveOwner returns the uid and gid the metadata refers to. Names are preferred over the numeric ids, so
+// owners are restored correctly on systems with different id assignments.
+func resolveOwner(m Metadata) (uid, gid int) {
+	uid = userNames.id(m.User, m.Uid, func(s string) (string, error) {
+		u, err := user.Lookup(s)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+	gid = groupNames.id(m.Group, m.Gid, func(s string) (string, error) {
+		g, err := user.LookupGroup(s)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	})
+	return
+}
+
+// aclUserName and aclGroupName return the name for an id in an ACL entry
+func aclUserName(uid uint32) string {
+	if name := lookupUserName(int(uid)); name != "" {
+		return name
+	}
+	return strconv.FormatUint(uint64(uid), 10)
+}
+
+func aclGroupName(gid uint32) string {
+	if name := lookupGroupName(int(gid)); name != "" {
+		return name
+	}
+	return strconv.FormatUint(uint64(gid), 10)
+}
+
+// aclUserId and aclGroupId resolve the names in ACL entries back to ids
+func aclUserId(name string) (uint32, error) {
+	uid, _ := resolveOwner(Metadata{User: name, Uid: -1})
+	return aclId(name, uid, "user")
+}
+
+func aclGroupId(name string) (uint32, error) {
+	_, gid := resolveOwner(Metadata{Group: name, Gid: -1})
+	return aclId(name, gid, "group")
+}
+
+func aclId(name string, id int, kind string) (uint32, error) {
+	if id >= 0 {
+		return uint32(id), nil
+	}
+	if parsed, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(parsed), nil
+	}
+	return 0, fmt.Errorf("unknown %s %s in ACL", kind, name)
+}
@@ -0,0 +1,11 @@
+//go:build !windows && !plan9 && !freebsd
+
+package fs
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+func mknod(path string, mode uint32, dev uint64) error {
+	return unix.Mknod(path, mode, int(dev))
+}
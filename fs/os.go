@@ -39,6 +39,18 @@ func (f osFile) Type() FileType {
 	if m&os.ModeSymlink != 0 {
 		return FSymlink
 	}
+	if m&os.ModeCharDevice != 0 {
+		return FCharDev
+	}
+	if m&os.ModeDevice != 0 {
+		return FBlockDev
+	}
+	if m&os.ModeNamedPipe != 0 {
+		return FFifo
+	}
+	if m&os.ModeSocket != 0 {
+		return FSocket
+	}
 	return "unknown"
 }
 
@@ -50,6 +62,10 @@ func (f osFile) Executable() bool {
 	return f.fi.Mode()&0100 != 0 // x bit set for user?
 }
 
+func (f osFile) Size() int64 {
+	return f.fi.Size()
+}
+
 func (f osFile) ModTime() time.Time {
 	return f.fi.ModTime()
 }
@@ -63,7 +79,7 @@ func (f osFile) Open() (io.ReadCloser, error) {
 	if err != nil {
 		return nil, err
 	}
-	return fh, nil
+	return openSparse(fh), nil
 }
 
 func (f osFile) OpenWritable() (io.WriteCloser, error) {
@@ -150,6 +166,16 @@ func (f osFile) CreateChildSymlink(name string, target string) (Symlink, error)
 	return OpenOSFile(p)
 }
 
+func (f osFile) CreateChildHardlink(name string, target RegularFile) (RegularFile, error) {
+	p := pathJoin(f.fullpath, name)
+
+	if err := os.Link(target.(osFile).fullpath, p); err != nil {
+		return nil, err
+	}
+
+	return OpenOSFile(p)
+}
+
 func (f osFile) RenameChild(oldname, newname string) error {
 	return os.Rename(pathJoin(f.fullpath, oldname), pathJoin(f.fullpath, newname))
 }
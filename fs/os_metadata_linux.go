@@ -0,0 +1,168 @@
+package fs
+
+import (
+	"code.laria.me/petrific/acl"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func (f osFile) Metadata() (m Metadata, err error) {
+	m.Mode = f.fi.Mode() & ModeMask
+	m.Mtime = f.fi.ModTime()
+
+	if st, ok := f.fi.Sys().(*syscall.Stat_t); ok {
+		m.HasOwner = true
+		m.Uid = int(st.Uid)
+		m.Gid = int(st.Gid)
+		m.User = lookupUserName(m.Uid)
+		m.Group = lookupGroupName(m.Gid)
+		m.Atime = time.Unix(st.Atim.Unix())
+		m.Ctime = time.Unix(st.Ctim.Unix())
+	}
+
+	if m.Xattrs, err = listXattrs(f.fullpath); err != nil {
+		return
+	}
+
+	if m.ACL, err = takeACLXattr(m.Xattrs, xattrACLAccess); err != nil {
+		return
+	}
+	m.DefaultACL, err = takeACLXattr(m.Xattrs, xattrACLDefault)
+	return
+}
+
+const (
+	xattrACLAccess  = "system.posix_acl_access"
+	xattrACLDefault = "system.posix_acl_default"
+)
+
+// takeACLXattr decodes the ACL stored in the xattr name and removes it from xattrs
+func takeACLXattr(xattrs map[string]string, name string) (acl.ACL, error) {
+	value, ok := xattrs[name]
+	if !ok {
+		return acl.ACL{}, nil
+	}
+	delete(xattrs, name)
+
+	a, err := acl.FromXattr([]byte(value), aclUserName, aclGroupName)
+	if err != nil {
+		return acl.ACL{}, fmt.Errorf("%s: %s", name, err)
+	}
+	return a, nil
+}
+
+// setACLXattr stores the ACL in the xattr name or removes the xattr, if the ACL is empty
+func setACLXattr(path, name string, a acl.ACL) error {
+	if a.IsEmpty() {
+		err := unix.Lremovexattr(path, name)
+		if err == unix.ENODATA || err == unix.ENOTSUP {
+			return nil
+		}
+		return err
+	}
+
+	b, err := a.ToXattr(aclUserId, aclGroupId)
+	if err != nil {
+		return err
+	}
+	return unix.Lsetxattr(path, name, b, 0)
+}
+
+func listXattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err == unix.ENOTSUP {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(path, buf); err != nil {
+		return nil, err
+	}
+
+	xattrs := make(map[string]string)
+	for _, name := range strings.Split(string(buf[:size]), "\x00") {
+		if name == "" {
+			continue
+		}
+
+		value, err := getXattr(path, name)
+		if err == unix.ENODATA {
+			continue // Removed in the meantime
+		} else if err != nil {
+			return nil, err
+		}
+		xattrs[name] = string(value)
+	}
+
+	return xattrs, nil
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	size, err := unix.Lgetxattr(path, name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, size)
+	size, err = unix.Lgetxattr(path, name, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}
+
+// SetMetadata applies the metadata to the file. The owner is only changed, if m.HasOwner is set (this usually
+// requires root privileges). It tries to apply everything and returns the first error encountered.
+func (f osFile) SetMetadata(m Metadata) error {
+	var first_err error
+	record := func(err error) {
+		if err != nil && first_err == nil {
+			first_err = err
+		}
+	}
+
+	// Change the owner first, chown clears the setuid/setgid bits
+	if m.HasOwner {
+		uid, gid := resolveOwner(m)
+		record(os.Lchown(f.fullpath, uid, gid))
+	}
+
+	// The permissions of symlinks can't be changed on linux
+	if m.Mode != 0 && f.fi.Mode()&os.ModeSymlink == 0 {
+		// Also removes ACLs inherited from a default ACL of the parent, if the file had no ACL.
+		// chmod afterwards only changes the mask of the ACL.
+		record(setACLXattr(f.fullpath, xattrACLAccess, m.ACL))
+		if f.fi.IsDir() {
+			record(setACLXattr(f.fullpath, xattrACLDefault, m.DefaultACL))
+		}
+
+		record(os.Chmod(f.fullpath, m.Mode))
+	}
+
+	for name, value := range m.Xattrs {
+		record(unix.Lsetxattr(f.fullpath, name, []byte(value), 0))
+	}
+
+	if !m.Mtime.IsZero() {
+		atime := m.Atime
+		if atime.IsZero() {
+			atime = m.Mtime
+		}
+		ts := []unix.Timespec{
+			unix.NsecToTimespec(atime.UnixNano()),
+			unix.NsecToTimespec(m.Mtime.UnixNano()),
+		}
+		record(unix.UtimesNanoAt(unix.AT_FDCWD, f.fullpath, ts, unix.AT_SYMLINK_NOFOLLOW))
+	}
+
+	return first_err
+}
@@ -0,0 +1,36 @@
+//go:build !linux
+
+package fs
+
+import (
+	"os"
+)
+
+// Only the mode and the modification time are supported on this platform
+func (f osFile) Metadata() (Metadata, error) {
+	return Metadata{
+		Mode:  f.fi.Mode() & ModeMask,
+		Mtime: f.fi.ModTime(),
+	}, nil
+}
+
+func (f osFile) SetMetadata(m Metadata) error {
+	if f.fi.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+
+	if m.Mode != 0 {
+		if err := os.Chmod(f.fullpath, m.Mode); err != nil {
+			return err
+		}
+	}
+
+	if !m.Mtime.IsZero() {
+		atime := m.Atime
+		if atime.IsZero() {
+			atime = m.Mtime
+		}
+		return os.Chtimes(f.fullpath, atime, m.Mtime)
+	}
+	return nil
+}
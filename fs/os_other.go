@@ -0,0 +1,25 @@
+//go:build windows || plan9
+
+package fs
+
+import (
+	"errors"
+)
+
+// Device ids are not available on this platform, all files are considered to be on the same device
+func (f osFile) Device() uint64 {
+	return 0
+}
+
+func (f osFile) DeviceNumber() (major, minor uint32) {
+	return 0, 0
+}
+
+// Hardlinks are not detected on this platform
+func (f osFile) HardlinkGroup() string {
+	return ""
+}
+
+func (f osFile) CreateChildSpecial(name string, ft FileType, major, minor uint32) (Special, error) {
+	return nil, errors.New("special files are not supported on this platform")
+}
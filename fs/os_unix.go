@@ -0,0 +1,54 @@
+//go:build !windows && !plan9
+
+package fs
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"syscall"
+)
+
+func (f osFile) Device() uint64 {
+	if st, ok := f.fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Dev)
+	}
+	return 0
+}
+
+func (f osFile) DeviceNumber() (major, minor uint32) {
+	if st, ok := f.fi.Sys().(*syscall.Stat_t); ok {
+		return unix.Major(uint64(st.Rdev)), unix.Minor(uint64(st.Rdev))
+	}
+	return 0, 0
+}
+
+func (f osFile) HardlinkGroup() string {
+	if st, ok := f.fi.Sys().(*syscall.Stat_t); ok && st.Nlink > 1 {
+		return fmt.Sprintf("%x:%x", uint64(st.Dev), uint64(st.Ino))
+	}
+	return ""
+}
+
+func (f osFile) CreateChildSpecial(name string, ft FileType, major, minor uint32) (Special, error) {
+	p := pathJoin(f.fullpath, name)
+
+	var mode uint32
+	switch ft {
+	case FBlockDev:
+		mode = unix.S_IFBLK
+	case FCharDev:
+		mode = unix.S_IFCHR
+	case FFifo:
+		mode = unix.S_IFIFO
+	case FSocket:
+		mode = unix.S_IFSOCK
+	default:
+		return nil, fmt.Errorf("can not create special file of type %s", ft)
+	}
+
+	if err := mknod(p, mode|0600, unix.Mkdev(major, minor)); err != nil {
+		return nil, err
+	}
+
+	return OpenOSFile(p)
+}
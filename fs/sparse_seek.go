@@ -0,0 +1,78 @@
+//go:build linux || freebsd
+
+package fs
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+)
+
+type sparseOSFile struct {
+	*os.File
+}
+
+func openSparse(fh *os.File) io.ReadCloser {
+	return sparseOSFile{fh}
+}
+
+// Regions uses SEEK_DATA and SEEK_HOLE to find the holes. The read position is reset to the start of the file.
+func (f sparseOSFile) Regions() ([]Region, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+
+	regions, err := f.findRegions(size)
+	if errors.Is(err, unix.EINVAL) {
+		// Not supported, everything is data
+		regions, err = []Region{{Offset: 0, Length: size}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return regions, nil
+}
+
+func (f sparseOSFile) findRegions(size int64) ([]Region, error) {
+	regions := []Region{}
+
+	var off int64
+	for off < size {
+		data, err := f.Seek(off, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			break // Only a hole until the end of the file
+		} else if err != nil {
+			return nil, err
+		}
+		if data >= size {
+			break // The file shrank in the meantime
+		}
+
+		hole, err := f.Seek(data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		if hole > size {
+			hole = size // The file grew in the meantime
+		}
+
+		if data > off {
+			regions = append(regions, Region{Offset: off, Length: data - off, Hole: true})
+		}
+		regions = append(regions, Region{Offset: data, Length: hole - data})
+		off = hole
+	}
+
+	if off < size {
+		regions = append(regions, Region{Offset: off, Length: size - off, Hole: true})
+	}
+
+	return regions, nil
+}
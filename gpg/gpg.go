@@ -1,10 +1,17 @@
 package gpg
 
-// Package gpg wraps around the gpg command line tool and exposes some of its functionality
+// Package gpg wraps around the gpg command line tool and exposes some of its functionality.
+// NativeSigner and NativeVerifyer provide the same functionality without the gpg binary.
 
 import (
+	"bufio"
 	"bytes"
+	"code.laria.me/petrific/objects"
+	"errors"
 	"os/exec"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Signer implements objects.Signer using gpg
@@ -30,9 +37,68 @@ func (s Signer) Sign(b []byte) ([]byte, error) {
 // Verifyer implements objects.Verifyer using gpg
 type Verifyer struct{}
 
-// Verify verifies the signed message b
-func (Verifyer) Verify(b []byte) error {
-	cmd := exec.Command("gpg", "--verify")
-	cmd.Stdin = bytes.NewReader(b)
-	return cmd.Run()
+// Verify verifies the signed message b. Like NativeVerifyer, it rejects text outside of the signed message.
+func (Verifyer) Verify(b []byte) (objects.SignatureInfo, error) {
+	if err := checkClearsigned(b); err != nil {
+		return objects.SignatureInfo{}, err
+	}
+
+	out, err := filter(exec.Command("gpg", "--status-fd", "1", "--verify"), b)
+	if err != nil {
+		return objects.SignatureInfo{}, err
+	}
+	return parseVerifyStatus(out)
+}
+
+// parseVerifyStatus gets the signature info from the status output of `gpg --verify` (see doc/DETAILS in gnupg)
+func parseVerifyStatus(status []byte) (si objects.SignatureInfo, err error) {
+	valid := false
+
+	sc := bufio.NewScanner(bytes.NewReader(status))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 || fields[0] != "[GNUPG:]" {
+			continue
+		}
+
+		switch fields[1] {
+		case "GOODSIG":
+			// GOODSIG <long_keyid_or_fpr> <username>
+			if len(fields) > 3 {
+				si.Signer = strings.Join(fields[3:], " ")
+			}
+		case "VALIDSIG":
+			// VALIDSIG <fpr> <sig_creation_date> <sig-timestamp> <expire-timestamp> <sig-version> <reserved>
+			//          <pubkey-algo> <hash-algo> <sig-class> [ <primary-key-fpr> ]
+			if len(fields) < 5 {
+				return si, errors.New("invalid VALIDSIG status from gpg")
+			}
+			valid = true
+
+			si.Fingerprint = fields[2]
+			if len(fields) > 11 {
+				si.Fingerprint = fields[11]
+			}
+			si.KeyId = fields[2]
+			if len(si.KeyId) > 16 {
+				si.KeyId = si.KeyId[len(si.KeyId)-16:]
+			}
+
+			if strings.Contains(fields[4], "T") {
+				si.Time, err = time.Parse("20060102T150405", fields[4])
+			} else {
+				var timestamp int64
+				timestamp, err = strconv.ParseInt(fields[4], 10, 64)
+				si.Time = time.Unix(timestamp, 0)
+			}
+			if err != nil {
+				return si, errors.New("invalid signature time from gpg")
+			}
+		}
+	}
+
+	if !valid {
+		return si, errors.New("gpg reported no valid signature")
+	}
+	return si, sc.Err()
 }
@@ -0,0 +1,27 @@
+package gpg
+
+import (
+	"testing"
+)
+
+func TestParseVerifyStatus(t *testing.T) {
+	status := []byte("" +
+		"[GNUPG:] NEWSIG\n" +
+		"[GNUPG:] KEY_CONSIDERED 6A30199D2AAD0F2DD2E84D07376269856DDF8A8C 0\n" +
+		"[GNUPG:] SIG_ID QVWQsIntVElJYNg3LxoajI0HK8A 2026-10-17 1792218348\n" +
+		"[GNUPG:] GOODSIG 376269856DDF8A8C Petrific Test <test@example.com>\n" +
+		"[GNUPG:] VALIDSIG 6A30199D2AAD0F2DD2E84D07376269856DDF8A8C 2026-10-17 1792218348 0 4 0 22 8 01 6A30199D2AAD0F2DD2E84D07376269856DDF8A8C\n" +
+		"[GNUPG:] TRUST_ULTIMATE 0 pgp\n")
+
+	si, err := parseVerifyStatus(status)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if si != testGPGSignatureInfo {
+		t.Errorf("Unexpected signature info %#v", si)
+	}
+
+	if _, err := parseVerifyStatus([]byte("[GNUPG:] NEWSIG\n[GNUPG:] BADSIG 376269856DDF8A8C Petrific Test <test@example.com>\n")); err == nil {
+		t.Errorf("Bad signature was accepted")
+	}
+}
@@ -0,0 +1,182 @@
+package gpg
+
+import (
+	"bytes"
+	"code.laria.me/petrific/objects"
+	"errors"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"os"
+	"strings"
+	"time"
+)
+
+const clearsignHeader = "-----BEGIN PGP SIGNED MESSAGE-----"
+
+var (
+	ErrNoSigningKey   = errors.New("no usable signing key found")
+	ErrNotClearsigned = errors.New("not a clearsigned message")
+)
+
+func readKeyFile(path string) (openpgp.EntityList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key file %s: %s", path, err)
+	}
+	return keys, nil
+}
+
+// matchesKey checks, if an entity is selected by key, which is a fingerprint, a (long) key ID or a part of a user ID
+// like an email address. An empty key selects every entity.
+func matchesKey(entity *openpgp.Entity, key string) bool {
+	if key == "" {
+		return true
+	}
+
+	hex := strings.TrimPrefix(strings.ToUpper(strings.Replace(key, " ", "", -1)), "0X")
+	keys := []*packet.PublicKey{entity.PrimaryKey}
+	for _, subkey := range entity.Subkeys {
+		keys = append(keys, subkey.PublicKey)
+	}
+	for _, k := range keys {
+		fingerprint := fmt.Sprintf("%X", k.Fingerprint)
+		if len(hex) >= 16 && strings.HasSuffix(fingerprint, hex) {
+			return true
+		}
+	}
+
+	for name := range entity.Identities {
+		if strings.Contains(name, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// NativeSigner implements objects.Signer without the gpg binary, using a secret key from an armored key file.
+// The signatures are clearsigned messages, just like the ones created by Signer.
+type NativeSigner struct {
+	key *packet.PrivateKey
+}
+
+// NewNativeSigner reads the secret key from the armored key file at path. If the file contains multiple keys, key
+// selects one of them (see Signer.Key), otherwise the first key that can sign is used. The passphrase is only needed,
+// if the key is encrypted.
+func NewNativeSigner(path, key string, passphrase []byte) (NativeSigner, error) {
+	entities, err := readKeyFile(path)
+	if err != nil {
+		return NativeSigner{}, err
+	}
+
+	now := time.Now()
+	for _, entity := range entities {
+		if entity.PrivateKey == nil || !matchesKey(entity, key) {
+			continue
+		}
+
+		signing_key, ok := entity.SigningKey(now)
+		if !ok || signing_key.PrivateKey == nil || signing_key.PrivateKey.Dummy() {
+			continue
+		}
+
+		if signing_key.PrivateKey.Encrypted {
+			if passphrase == nil {
+				return NativeSigner{}, fmt.Errorf("signing key in %s is encrypted, but no passphrase was given", path)
+			}
+			if err := signing_key.PrivateKey.Decrypt(passphrase); err != nil {
+				return NativeSigner{}, fmt.Errorf("could not decrypt signing key in %s: %s", path, err)
+			}
+		}
+
+		return NativeSigner{signing_key.PrivateKey}, nil
+	}
+
+	return NativeSigner{}, ErrNoSigningKey
+}
+
+// Sign signs a message b, the result is compatible with `gpg --clearsign`
+func (s NativeSigner) Sign(b []byte) ([]byte, error) {
+	var out bytes.Buffer
+
+	w, err := clearsign.Encode(&out, s.key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// NativeVerifyer implements objects.Verifyer without the gpg binary. It accepts signatures made by any key of
+// its keyring.
+type NativeVerifyer struct {
+	keyring openpgp.EntityList
+}
+
+// NewNativeVerifyer reads the keyring from the armored key files at paths (public or secret keys).
+func NewNativeVerifyer(paths ...string) (NativeVerifyer, error) {
+	var keyring openpgp.EntityList
+	for _, path := range paths {
+		keys, err := readKeyFile(path)
+		if err != nil {
+			return NativeVerifyer{}, err
+		}
+		keyring = append(keyring, keys...)
+	}
+	return NativeVerifyer{keyring}, nil
+}
+
+// checkClearsigned checks, that b is a single clearsigned message. Unlike gpg, we don't accept text before or after
+// the signed message, as it would not be covered by the signature.
+func checkClearsigned(b []byte) error {
+	if !bytes.HasPrefix(b, []byte(clearsignHeader)) {
+		return ErrNotClearsigned
+	}
+
+	block, rest := clearsign.Decode(b)
+	if block == nil {
+		return ErrNotClearsigned
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		return errors.New("unsigned data after the signed message")
+	}
+	return nil
+}
+
+// Verify verifies the clearsigned message b
+func (v NativeVerifyer) Verify(b []byte) (objects.SignatureInfo, error) {
+	if err := checkClearsigned(b); err != nil {
+		return objects.SignatureInfo{}, err
+	}
+	block, _ := clearsign.Decode(b)
+
+	sig, signer, err := openpgp.VerifyDetachedSignature(v.keyring, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body, nil)
+	if err != nil {
+		return objects.SignatureInfo{}, err
+	}
+
+	si := objects.SignatureInfo{
+		Fingerprint: fmt.Sprintf("%X", signer.PrimaryKey.Fingerprint),
+		Time:        sig.CreationTime,
+	}
+	if identity := signer.PrimaryIdentity(); identity != nil {
+		si.Signer = identity.Name
+	}
+	if sig.IssuerKeyId != nil {
+		si.KeyId = fmt.Sprintf("%016X", *sig.IssuerKeyId)
+	}
+	return si, nil
+}
@@ -0,0 +1,200 @@
+package gpg
+
+import (
+	"bytes"
+	"code.laria.me/petrific/objects"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	testSnapshotPayload = []byte("" +
+		"== BEGIN SNAPSHOT ==\n" +
+		"archive foo\n" +
+		"date 2017-07-01T21:40:00+02:00\n" +
+		"signed yes\n" +
+		"tree sha3-256:ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff\n" +
+		"\n" +
+		"foo\n" +
+		"bar\n" +
+		"baz!\n" +
+		"== END SNAPSHOT ==\n")
+
+	// testSnapshotPayload signed with `gpg --clearsign` by testGPGPublicKey
+	testGPGSignedSnapshot = []byte(`-----BEGIN PGP SIGNED MESSAGE-----
+Hash: SHA256
+
+== BEGIN SNAPSHOT ==
+archive foo
+date 2017-07-01T21:40:00+02:00
+signed yes
+tree sha3-256:ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff
+
+foo
+bar
+baz!
+== END SNAPSHOT ==
+-----BEGIN PGP SIGNATURE-----
+
+iHUEARYIAB0WIQRqMBmdKq0PLdLoTQc3YmmFbd+KjAUCatMU7AAKCRA3YmmFbd+K
+jOEWAQDbmX2cg3O/y/2D6UA53gqeFNW5V5ADeuqOf0Gisy8+dwEAuHWTpevukneu
+m2oMblXraaP32EOmGNPG8RlpQQdkow0=
+=JkZu
+-----END PGP SIGNATURE-----
+`)
+
+	testGPGSignatureInfo = objects.SignatureInfo{
+		Signer:      "Petrific Test <test@example.com>",
+		KeyId:       "376269856DDF8A8C",
+		Fingerprint: "6A30199D2AAD0F2DD2E84D07376269856DDF8A8C",
+		Time:        time.Unix(1792218348, 0),
+	}
+
+	testGPGPublicKey = []byte(`-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mDMEatMU7BYJKwYBBAHaRw8BAQdA8yzqXOONB/mxfu5QqneTGpPspaSNMQw/4/GG
+EfIDWiS0IFBldHJpZmljIFRlc3QgPHRlc3RAZXhhbXBsZS5jb20+iJAEExYIADgW
+IQRqMBmdKq0PLdLoTQc3YmmFbd+KjAUCatMU7AIbAwULCQgHAgYVCgkICwIEFgID
+AQIeAQIXgAAKCRA3YmmFbd+KjJ9FAQCAKu3iC5/UzgHq49Ut+SaUySpUKefD5B7y
+4LDFaEBmhQEA8D3u3DYcqaAKW7gdYObZ9F1X2r72HWRoQorYK+NX0QY=
+=VT0z
+-----END PGP PUBLIC KEY BLOCK-----
+`)
+)
+
+func writeFile(t *testing.T, name string, content []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatalf("Could not write %s: %s", name, err)
+	}
+	return path
+}
+
+// writeSecretKey generates a new key and writes it to an armored key file. If passphrase is not nil, the key gets
+// encrypted.
+func writeSecretKey(t *testing.T, passphrase []byte) string {
+	entity, err := openpgp.NewEntity("Test", "", "test@example.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatalf("Could not generate key: %s", err)
+	}
+	if passphrase != nil {
+		if err := entity.EncryptPrivateKeys(passphrase, nil); err != nil {
+			t.Fatalf("Could not encrypt key: %s", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.SerializePrivateWithoutSigning(w, nil); err != nil {
+		t.Fatalf("Could not serialize key: %s", err)
+	}
+	w.Close()
+
+	return writeFile(t, "secret.asc", buf.Bytes())
+}
+
+func verifySnapshot(payload []byte, v objects.Verifyer) error {
+	var snapshot objects.Snapshot
+	if err := snapshot.FromPayload(payload); err != nil {
+		return err
+	}
+	_, err := snapshot.Verify(objects.Verifyers{objects.SignatureOpenPGP: v})
+	return err
+}
+
+func TestNativeVerifyGPGSignature(t *testing.T) {
+	v, err := NewNativeVerifyer(writeFile(t, "public.asc", testGPGPublicKey))
+	if err != nil {
+		t.Fatalf("Could not load public key: %s", err)
+	}
+
+	if err := verifySnapshot(testGPGSignedSnapshot, v); err != nil {
+		t.Errorf("Verification of gpg signature failed: %s", err)
+	}
+
+	si, err := v.Verify(testGPGSignedSnapshot)
+	if err != nil {
+		t.Fatalf("Verification of gpg signature failed: %s", err)
+	}
+	if si != testGPGSignatureInfo {
+		t.Errorf("Unexpected signature info %#v", si)
+	}
+
+	subtests := []struct {
+		name    string
+		payload []byte
+	}{
+		{"tampered", bytes.Replace(testGPGSignedSnapshot, []byte("archive foo"), []byte("archive bar"), 1)},
+		{"prefixed", append([]byte("== BEGIN SNAPSHOT ==\narchive bar\ndate 2017-07-01T21:40:00+02:00\nsigned yes\ntree sha3-256:0000000000000000000000000000000000000000000000000000000000000000\n== END SNAPSHOT ==\n"), testGPGSignedSnapshot...)},
+		{"suffixed", append(append([]byte{}, testGPGSignedSnapshot...), "unsigned\n"...)},
+	}
+
+	for _, subtest := range subtests {
+		if err := verifySnapshot(subtest.payload, v); err == nil {
+			t.Errorf("Verification of %s snapshot succeeded", subtest.name)
+		}
+	}
+}
+
+func TestNativeSign(t *testing.T) {
+	key_path := writeSecretKey(t, nil)
+
+	signer, err := NewNativeSigner(key_path, "", nil)
+	if err != nil {
+		t.Fatalf("Could not load signing key: %s", err)
+	}
+	signed, err := signer.Sign(testSnapshotPayload)
+	if err != nil {
+		t.Fatalf("Could not sign: %s", err)
+	}
+
+	v, err := NewNativeVerifyer(key_path)
+	if err != nil {
+		t.Fatalf("Could not load key: %s", err)
+	}
+	if err := verifySnapshot(signed, v); err != nil {
+		t.Errorf("Verification failed: %s", err)
+	}
+
+	other, err := NewNativeVerifyer(writeFile(t, "public.asc", testGPGPublicKey))
+	if err != nil {
+		t.Fatalf("Could not load public key: %s", err)
+	}
+	if err := verifySnapshot(signed, other); err == nil {
+		t.Errorf("Verification with other key succeeded")
+	}
+
+	if _, err := NewNativeSigner(key_path, "nobody@example.com", nil); err != ErrNoSigningKey {
+		t.Errorf("Unexpected error for unknown key: %v", err)
+	}
+	if _, err := NewNativeSigner(key_path, "test@example.com", nil); err != nil {
+		t.Errorf("Could not select key by email: %s", err)
+	}
+}
+
+func TestNativeSignEncryptedKey(t *testing.T) {
+	key_path := writeSecretKey(t, []byte("secret"))
+
+	if _, err := NewNativeSigner(key_path, "", nil); err == nil {
+		t.Errorf("Loading encrypted key without passphrase succeeded")
+	}
+	if _, err := NewNativeSigner(key_path, "", []byte("wrong")); err == nil {
+		t.Errorf("Loading encrypted key with wrong passphrase succeeded")
+	}
+
+	signer, err := NewNativeSigner(key_path, "", []byte("secret"))
+	if err != nil {
+		t.Fatalf("Could not load encrypted key: %s", err)
+	}
+	if _, err := signer.Sign(testSnapshotPayload); err != nil {
+		t.Errorf("Could not sign with encrypted key: %s", err)
+	}
+}
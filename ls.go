@@ -0,0 +1,140 @@
+package main
+
+import (
+	"code.laria.me/petrific/backup"
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+)
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func printTreeEntry(env *Env, name string, entry objects.TreeEntry) error {
+	size := "-"
+	suffix := ""
+
+	switch e := entry.(type) {
+	case objects.TreeEntryFile:
+		file_size, err := backup.FileSize(env.Store, e.Ref)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		size = fmt.Sprintf("%d", file_size)
+	case objects.TreeEntryDir:
+		suffix = "/"
+	case objects.TreeEntrySymlink:
+		suffix = " -> " + e.Target
+	case objects.TreeEntrySpecial:
+		if e.IsDevice() {
+			size = fmt.Sprintf("%d,%d", e.Major, e.Minor)
+		}
+	}
+
+	fmt.Printf("%-8s %s %s:%s %12s %s%s\n", entry.Type(), entry.ACL(), orDash(entry.User()), orDash(entry.Group()), size, name, suffix)
+	return nil
+}
+
+func listTree(env *Env, tree_id objects.ObjectId, prefix string, recursive bool) error {
+	tree_obj, err := storage.GetObjectOfType(env.Store, tree_id, objects.OTTree)
+	if err != nil {
+		return err
+	}
+	tree := tree_obj.(objects.Tree)
+
+	names := make([]string, 0, len(tree))
+	for name := range tree {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		entry := tree[name]
+		if err := printTreeEntry(env, path.Join(prefix, name), entry); err != nil {
+			return err
+		}
+
+		if dir, ok := entry.(objects.TreeEntryDir); ok && recursive {
+			if err := listTree(env, dir.Ref, path.Join(prefix, name), true); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func Ls(env *Env, args []string) int {
+	flags := flag.NewFlagSet(os.Args[0]+" ls", flag.ContinueOnError)
+	archive := flags.String("archive", "", "list the latest trusted snapshot of this archive (instead of giving a snapshot id)")
+	recursive := flags.Bool("recursive", false, "also list the content of subdirectories")
+
+	flags.Usage = subcmdUsage("ls", "[flags] [snapshot-id] [path]", flags)
+	errout := subcmdErrout(env.Log, "ls")
+
+	if err := flags.Parse(args); err != nil {
+		errout(err)
+		return 2
+	}
+
+	args = flags.Args()
+
+	var snapshot_id objects.ObjectId
+	if *archive == "" {
+		if len(args) < 1 {
+			errout(errors.New("Either a snapshot id or -archive must be given"))
+			flags.Usage()
+			return 2
+		}
+
+		var err error
+		if snapshot_id, err = objects.ParseObjectId(args[0]); err != nil {
+			errout(fmt.Errorf("invalid snapshot id: %s", err))
+			return 2
+		}
+		args = args[1:]
+	}
+
+	if len(args) > 1 {
+		flags.Usage()
+		return 2
+	}
+
+	p := ""
+	if len(args) == 1 {
+		p = args[0]
+	}
+
+	snapshot, err := loadTrustedSnapshot(env, snapshot_id, *archive)
+	if err != nil {
+		errout(err)
+		return 1
+	}
+
+	entry, err := backup.LookupPath(env.Store, snapshot.Tree, p)
+	if err != nil {
+		errout(err)
+		return 1
+	}
+
+	if dir, ok := entry.(objects.TreeEntryDir); ok {
+		err = listTree(env, dir.Ref, "", *recursive)
+	} else {
+		err = printTreeEntry(env, path.Base(p), entry)
+	}
+
+	if err != nil {
+		errout(err)
+		return 1
+	}
+	return 0
+}
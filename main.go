@@ -18,7 +18,16 @@ var subcmds = map[string]subcmd{
 	"create-snapshot":  CreateSnapshot,
 	"list-snapshots":   ListSnapshots,
 	"restore-snapshot": RestoreSnapshot,
+	"ls":               Ls,
+	"cat":              Cat,
+	"diff":             Diff,
+	"verify-dir":       VerifyDir,
+	"verify-snapshot":  VerifySnapshot,
+	"mount":            Mount,
+	"serve-9p":         Serve9P,
 	"fsck":             Fsck,
+	"forget":           Forget,
+	"prune":            Prune,
 	"storagecmd":       StorageCmd,
 }
 
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"code.laria.me/petrific/snapshotfs"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func Mount(env *Env, args []string) int {
+	flags := flag.NewFlagSet(os.Args[0]+" mount", flag.ContinueOnError)
+	allowOther := flags.Bool("allow-other", false, "allow other users to access the mounted snapshots (needs user_allow_other in /etc/fuse.conf)")
+
+	flags.Usage = subcmdUsage("mount", "[flags] mountpoint", flags)
+	errout := subcmdErrout(env.Log, "mount")
+
+	if err := flags.Parse(args); err != nil {
+		errout(err)
+		return 2
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	mountpoint, err := abspath(flags.Arg(0))
+	if err != nil {
+		errout(err)
+		return 1
+	}
+
+	fsys, err := trustedSnapshotFS(env)
+	if err != nil {
+		errout(err)
+		return 1
+	}
+
+	// Unmount on interrupt, this also stops serving the filesystem
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for range signals {
+			if err := snapshotfs.Unmount(mountpoint); err != nil {
+				env.Log.Warn().Printf("mount: could not unmount %s: %s", mountpoint, err)
+			}
+		}
+	}()
+
+	if err := snapshotfs.Mount(fsys, mountpoint, *allowOther); err != nil {
+		errout(err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,209 @@
+package objects
+
+import (
+	"code.laria.me/petrific/acl"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metadata holds optional POSIX metadata of a tree entry. Zero values are not recorded.
+//
+// It is serialized with these additional keys of the tree entry properties:
+//
+// mode: Octal permission bits including the setuid (4000), setgid (2000) and sticky (1000) bits
+//
+// uid, gid: Numeric owner and group (The names are stored in the keys "user" and "group")
+//
+// mtime, atime, ctime: Modification, access and status change time in RFC 3339 format
+//
+// default_acl: Default ACL of a directory (the ACL of the entry itself is stored in "acl")
+//
+// xattr.<name>: Value of the extended attribute <name>
+type Metadata struct {
+	Mode os.FileMode // Permission bits, os.ModeSetuid, os.ModeSetgid and os.ModeSticky
+
+	HasOwner bool // Uid and Gid are only recorded, if this is set
+	Uid, Gid int
+
+	Mtime, Atime, Ctime time.Time
+
+	DefaultACL acl.ACL // Empty, if there is no default ACL
+
+	Xattrs map[string]string
+}
+
+const xattrPrefix = "xattr."
+
+// The special bits are stored like chmod(1) expects them
+func modeToUnix(m os.FileMode) uint32 {
+	u := uint32(m.Perm())
+	if m&os.ModeSetuid != 0 {
+		u |= 04000
+	}
+	if m&os.ModeSetgid != 0 {
+		u |= 02000
+	}
+	if m&os.ModeSticky != 0 {
+		u |= 01000
+	}
+	return u
+}
+
+func modeFromUnix(u uint32) os.FileMode {
+	m := os.FileMode(u & 0777)
+	if u&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if u&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if u&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+// UnixMode returns the mode like chmod(1) expects it, including the setuid, setgid and sticky bits
+func (m Metadata) UnixMode() uint32 {
+	return modeToUnix(m.Mode)
+}
+
+func (m Metadata) addToProperties(props Properties) {
+	if m.Mode != 0 {
+		props["mode"] = fmt.Sprintf("%04o", m.UnixMode())
+	}
+
+	if m.HasOwner {
+		props["uid"] = strconv.Itoa(m.Uid)
+		props["gid"] = strconv.Itoa(m.Gid)
+	}
+
+	for key, t := range map[string]time.Time{"mtime": m.Mtime, "atime": m.Atime, "ctime": m.Ctime} {
+		if !t.IsZero() {
+			props[key] = t.UTC().Format(time.RFC3339Nano)
+		}
+	}
+
+	if !m.DefaultACL.IsEmpty() {
+		props["default_acl"] = m.DefaultACL.String()
+	}
+
+	for name, value := range m.Xattrs {
+		props[xattrPrefix+name] = value
+	}
+}
+
+func metadataFromProperties(props Properties) (m Metadata, err error) {
+	if mode, ok := props["mode"]; ok {
+		u, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return m, fmt.Errorf("Invalid mode %s", mode)
+		}
+		m.Mode = modeFromUnix(uint32(u))
+	}
+
+	uid, has_uid := props["uid"]
+	gid, has_gid := props["gid"]
+	if has_uid && has_gid {
+		m.HasOwner = true
+		if m.Uid, err = strconv.Atoi(uid); err != nil {
+			return m, fmt.Errorf("Invalid uid %s", uid)
+		}
+		if m.Gid, err = strconv.Atoi(gid); err != nil {
+			return m, fmt.Errorf("Invalid gid %s", gid)
+		}
+	}
+
+	for key, t := range map[string]*time.Time{"mtime": &m.Mtime, "atime": &m.Atime, "ctime": &m.Ctime} {
+		if s, ok := props[key]; ok {
+			if *t, err = time.Parse(time.RFC3339Nano, s); err != nil {
+				return m, fmt.Errorf("Invalid %s: %s", key, err)
+			}
+		}
+	}
+
+	if s, ok := props["default_acl"]; ok {
+		if m.DefaultACL, err = acl.ParseACL(s); err != nil {
+			return m, err
+		}
+	}
+
+	for key, value := range props {
+		if strings.HasPrefix(key, xattrPrefix) {
+			if m.Xattrs == nil {
+				m.Xattrs = make(map[string]string)
+			}
+			m.Xattrs[strings.TrimPrefix(key, xattrPrefix)] = value
+		}
+	}
+
+	return m, nil
+}
+
+// EqualsIgnoringTimes compares two Metadata, ignoring the timestamps
+func (a Metadata) EqualsIgnoringTimes(b Metadata) bool {
+	if a.Mode != b.Mode || a.HasOwner != b.HasOwner || a.Uid != b.Uid || a.Gid != b.Gid {
+		return false
+	}
+
+	if !a.DefaultACL.Equals(b.DefaultACL) {
+		return false
+	}
+
+	if len(a.Xattrs) != len(b.Xattrs) {
+		return false
+	}
+	for name, va := range a.Xattrs {
+		if vb, ok := b.Xattrs[name]; !ok || va != vb {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (a Metadata) Equals(b Metadata) bool {
+	return a.EqualsIgnoringTimes(b) && a.Mtime.Equal(b.Mtime) && a.Atime.Equal(b.Atime) && a.Ctime.Equal(b.Ctime)
+}
+
+// WithMetadata returns a copy of the tree entry with the metadata and the owner names set.
+// If the metadata contains a mode, the ACL is derived from it.
+func WithMetadata(entry TreeEntry, m Metadata, user, group string) TreeEntry {
+	return modifyBase(entry, func(base *TreeEntryBase) {
+		base.meta = m
+		base.user = user
+		base.group = group
+		if m.Mode != 0 {
+			base.acl = acl.ACLFromUnixPerms(m.Mode.Perm())
+		}
+	})
+}
+
+// WithACL returns a copy of the tree entry with the ACL replaced
+func WithACL(entry TreeEntry, a acl.ACL) TreeEntry {
+	return modifyBase(entry, func(base *TreeEntryBase) {
+		base.acl = a
+	})
+}
+
+func modifyBase(entry TreeEntry, set func(*TreeEntryBase)) TreeEntry {
+	switch e := entry.(type) {
+	case TreeEntryFile:
+		set(&e.TreeEntryBase)
+		return e
+	case TreeEntryDir:
+		set(&e.TreeEntryBase)
+		return e
+	case TreeEntrySymlink:
+		set(&e.TreeEntryBase)
+		return e
+	case TreeEntrySpecial:
+		set(&e.TreeEntryBase)
+		return e
+	default:
+		return entry
+	}
+}
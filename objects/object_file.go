@@ -11,16 +11,28 @@ import (
 // The size is not necessary for reconstruction (the blob object already has a size)
 // but it can speed up random access to the whole file by skipping previous fragments.
 // It is serialized as `Properties` (see there) with the keys `blob` (ID of blob object) and `size` (decimal size of the blob)
+//
+// A fragment can also be a hole in a sparse file. It then has no blob, but the key `hole` (value `true`) and
+// the `size` of the hole. Holes read as zero bytes.
 type FileFragment struct {
 	Blob ObjectId
 	Size uint64
+	Hole bool
 }
 
 func (ff FileFragment) toProperties() Properties {
+	if ff.Hole {
+		return Properties{"hole": "true", "size": strconv.FormatUint(ff.Size, 10)}
+	}
 	return Properties{"blob": ff.Blob.String(), "size": strconv.FormatUint(ff.Size, 10)}
 }
 
 func (ff *FileFragment) fromProperties(p Properties) error {
+	if p["hole"] == "true" {
+		ff.Hole = true
+		return ff.sizeFromProperties(p)
+	}
+
 	blob, ok := p["blob"]
 	if !ok {
 		return errors.New("Field `blob` is missing")
@@ -32,6 +44,10 @@ func (ff *FileFragment) fromProperties(p Properties) error {
 		return err
 	}
 
+	return ff.sizeFromProperties(p)
+}
+
+func (ff *FileFragment) sizeFromProperties(p Properties) (err error) {
 	size, ok := p["size"]
 	if !ok {
 		return errors.New("Field `size` is missing")
@@ -42,6 +58,9 @@ func (ff *FileFragment) fromProperties(p Properties) error {
 }
 
 func (a FileFragment) Equals(b FileFragment) bool {
+	if a.Hole || b.Hole {
+		return a.Hole == b.Hole && a.Size == b.Size
+	}
 	return a.Blob.Equals(b.Blob) && a.Size == b.Size
 }
 
@@ -86,3 +86,27 @@ func TestUnserializeFileFailure(t *testing.T) {
 		}
 	}
 }
+
+func TestSparseFile(t *testing.T) {
+	f := File{
+		FileFragment{Hole: true, Size: 4096},
+		FileFragment{Blob: genId(0x11), Size: 10},
+		FileFragment{Hole: true, Size: 100},
+	}
+
+	want := "" +
+		"hole=true&size=4096\n" +
+		"blob=sha3-256:1111111111111111111111111111111111111111111111111111111111111111&size=10\n" +
+		"hole=true&size=100\n"
+	if have := f.Payload(); string(have) != want {
+		t.Errorf("Unexpected serialization result: %s", have)
+	}
+
+	have := File{}
+	if err := have.FromPayload([]byte(want)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !have.Equals(f) {
+		t.Errorf("Unexpeced unserialization result: %v", have)
+	}
+}
@@ -15,11 +15,15 @@ const (
 
 	snapshot_start_line = snapshot_start_marker + "\n"
 	snapshot_end_line   = snapshot_end_marker + "\n"
+
+	// Signatures wrap around (OpenPGP) or follow (SSH) the snapshot
+	pgp_signed_marker    = "-----BEGIN PGP SIGNED MESSAGE-----"
+	ssh_signature_marker = "-----BEGIN SSH SIGNATURE-----"
 )
 
 // Snapshot objects describe the state of a directory structure at a given time. It references a tree object by it's ID,
 // records the snapshot time and associates it ton an archive (which is just a short freeform text grouping multiple snapshots together).
-// A snapshot can optionally contain a comment and can be signed with a gpg key.
+// A snapshot can optionally contain a comment and can be signed with a gpg key or an SSH key.
 // If the snapshot is signed and you trust the signature, you can automatically trust the whole associated file tree,
 // since all references are really cryptographic hashes, guaranteeing data integrity.
 type Snapshot struct {
@@ -69,17 +73,41 @@ func (s Snapshot) Payload() (out []byte) {
 }
 
 type Verifyer interface {
-	Verify([]byte) error
+	// Verify verifies the signed message and returns information about the signature
+	Verify([]byte) (SignatureInfo, error)
+}
+
+// SignatureType detects the signature scheme of a signed snapshot. It returns an empty string, if it is unknown.
+// Only works with unserialized snapshots.
+func (s Snapshot) SignatureType() SignatureType {
+	switch {
+	case bytes.HasPrefix(s.raw, []byte(pgp_signed_marker)):
+		return SignatureOpenPGP
+	case bytes.Contains(s.raw, []byte(snapshot_end_line+ssh_signature_marker)):
+		return SignatureSSH
+	default:
+		return ""
+	}
 }
 
-// Verify verifies that the snapshot has a valid signature.
+// Verify verifies that the snapshot has a valid signature, using the Verifyer for the signature type of the snapshot.
+// It returns ErrUnsigned for unsigned snapshots, use a SignaturePolicy to decide, if they are acceptable.
 // Only works with unserialized snapshots, i.e. a freshly created snapshot can not be verified.
-func (s Snapshot) Verify(v Verifyer) error {
+func (s Snapshot) Verify(v Verifyers) (SignatureInfo, error) {
 	if !s.Signed {
-		return nil
+		return SignatureInfo{}, ErrUnsigned
 	}
 
-	return v.Verify(s.raw)
+	sig_type := s.SignatureType()
+	if sig_type == "" {
+		return SignatureInfo{}, errors.New("unknown signature type")
+	}
+
+	verifyer, ok := v[sig_type]
+	if !ok {
+		return SignatureInfo{}, fmt.Errorf("can not verify %s signatures, no keys configured", sig_type)
+	}
+	return verifyer.Verify(s.raw)
 }
 
 type Signer interface {
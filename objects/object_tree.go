@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"strconv"
 )
 
 type TreeEntryType string
@@ -15,12 +16,22 @@ const (
 	TETFile    TreeEntryType = "file"
 	TETDir     TreeEntryType = "dir"
 	TETSymlink TreeEntryType = "symlink"
+
+	// Special files, see TreeEntrySpecial
+	TETBlockDev TreeEntryType = "blockdev"
+	TETCharDev  TreeEntryType = "chardev"
+	TETFifo     TreeEntryType = "fifo"
+	TETSocket   TreeEntryType = "socket"
 )
 
 var treeEntryTypes = map[TreeEntryType]struct{}{
-	TETFile:    {},
-	TETDir:     {},
-	TETSymlink: {},
+	TETFile:     {},
+	TETDir:      {},
+	TETSymlink:  {},
+	TETBlockDev: {},
+	TETCharDev:  {},
+	TETFifo:     {},
+	TETSocket:   {},
 }
 
 type TreeEntry interface {
@@ -28,6 +39,7 @@ type TreeEntry interface {
 	ACL() acl.ACL
 	User() string
 	Group() string
+	Metadata() Metadata
 	equalContent(TreeEntry) bool
 	toProperties() Properties
 }
@@ -39,6 +51,7 @@ func compareTreeEntries(a, b TreeEntry) bool {
 type TreeEntryBase struct {
 	acl         acl.ACL
 	user, group string
+	meta        Metadata
 }
 
 func baseFromExec(exec bool) (base TreeEntryBase) {
@@ -62,6 +75,10 @@ func (teb TreeEntryBase) Group() string {
 	return teb.group
 }
 
+func (teb TreeEntryBase) Metadata() Metadata {
+	return teb.meta
+}
+
 func (teb TreeEntryBase) toProperties() Properties {
 	props := Properties{"acl": teb.acl.String()}
 	if teb.user != "" {
@@ -70,16 +87,20 @@ func (teb TreeEntryBase) toProperties() Properties {
 	if teb.group != "" {
 		props["group"] = teb.group
 	}
+	teb.meta.addToProperties(props)
 	return props
 }
 
 func (a TreeEntryBase) equalContent(b TreeEntryBase) bool {
-	return a.acl.Equals(b.acl) && a.user == b.user && a.group == b.group
+	return a.acl.Equals(b.acl) && a.user == b.user && a.group == b.group && a.meta.Equals(b.meta)
 }
 
 type TreeEntryFile struct {
 	TreeEntryBase
 	Ref ObjectId
+
+	// Files with the same non-empty Hardlink value (anywhere in the same snapshot) are hardlinks of each other
+	Hardlink string
 }
 
 func NewTreeEntryFile(ref ObjectId, exec bool) TreeEntryFile {
@@ -96,12 +117,15 @@ func (tef TreeEntryFile) Type() TreeEntryType {
 func (tef TreeEntryFile) toProperties() Properties {
 	props := tef.TreeEntryBase.toProperties()
 	props["ref"] = tef.Ref.String()
+	if tef.Hardlink != "" {
+		props["hardlink"] = tef.Hardlink
+	}
 	return props
 }
 
 func (a TreeEntryFile) equalContent(_b TreeEntry) bool {
 	b, ok := _b.(TreeEntryFile)
-	return ok && a.TreeEntryBase.equalContent(b.TreeEntryBase) && a.Ref.Equals(b.Ref)
+	return ok && a.TreeEntryBase.equalContent(b.TreeEntryBase) && a.Ref.Equals(b.Ref) && a.Hardlink == b.Hardlink
 }
 
 type TreeEntryDir struct {
@@ -158,22 +182,68 @@ func (a TreeEntrySymlink) equalContent(_b TreeEntry) bool {
 	return ok && a.TreeEntryBase.equalContent(b.TreeEntryBase) && a.Target == b.Target
 }
 
+// TreeEntrySpecial is a block or character device, a FIFO or a socket
+type TreeEntrySpecial struct {
+	TreeEntryBase
+	Kind         TreeEntryType // One of TETBlockDev, TETCharDev, TETFifo, TETSocket
+	Major, Minor uint32        // Device number, only used for devices
+}
+
+func NewTreeEntrySpecial(kind TreeEntryType, major, minor uint32) TreeEntrySpecial {
+	return TreeEntrySpecial{
+		TreeEntryBase: baseFromExec(false),
+		Kind:          kind,
+		Major:         major,
+		Minor:         minor,
+	}
+}
+
+func (tes TreeEntrySpecial) Type() TreeEntryType {
+	return tes.Kind
+}
+
+func (tes TreeEntrySpecial) IsDevice() bool {
+	return tes.Kind == TETBlockDev || tes.Kind == TETCharDev
+}
+
+func (tes TreeEntrySpecial) toProperties() Properties {
+	props := tes.TreeEntryBase.toProperties()
+	if tes.IsDevice() {
+		props["major"] = strconv.FormatUint(uint64(tes.Major), 10)
+		props["minor"] = strconv.FormatUint(uint64(tes.Minor), 10)
+	}
+	return props
+}
+
+func (a TreeEntrySpecial) equalContent(_b TreeEntry) bool {
+	b, ok := _b.(TreeEntrySpecial)
+	return ok && a.TreeEntryBase.equalContent(b.TreeEntryBase) && a.Kind == b.Kind && a.Major == b.Major && a.Minor == b.Minor
+}
+
 // Tree objects represent a filesystem tree / directory.
 // It contains references to files (See `File`), symlinks and other trees plus their metadata.
 // It is serialized as a sorted list of `Property` serializations (seperated by newline '\n').
 // All entries have the property keys "name" and "type" (and optionally "user", "group" and "acl" representing a posix ACL.
-// Currently only the execution bit is actually considerer. Choosing posix ACLs gives us the
-// possibility to extend the privilege system later).
+// Choosing posix ACLs gives us the possibility to extend the privilege system later).
+// Entries can also have optional POSIX metadata, see `Metadata` for the keys.
 // Further keys depend on the value of type:
 //
 // type=file, type=dir =>
 //
 // ref: Holding the ID referencing a file / subtree
 //
+// hardlink: (optional, only files) Identifies a group of hardlinked files
+//
 // type=symlink
 //
 // target: Holding the (relative) symlink path
 //
+// type=blockdev, type=chardev =>
+//
+// major, minor: The device number
+//
+// type=fifo, type=socket have no further keys
+//
 // The Property format allows easy extension in the future while remaining compatible
 // to older versions (they then simply ignore the additional properties).
 type Tree map[string]TreeEntry
@@ -216,8 +286,22 @@ func getObjectIdFromProps(p Properties, key string) (ObjectId, error) {
 	return oid, err
 }
 
-func defaultFileTreeEntryBase(_acl *acl.ACL, props Properties) (base TreeEntryBase) {
+func getUint32FromProps(p Properties, key string) (uint32, error) {
+	raw, ok := p[key]
+	if !ok {
+		return 0, fmt.Errorf("Missing key: %s", key)
+	}
+
+	n, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s: %s", key, raw)
+	}
+	return uint32(n), nil
+}
+
+func defaultFileTreeEntryBase(_acl *acl.ACL, meta Metadata, props Properties) (base TreeEntryBase) {
 	base.user = props["user"]
+	base.meta = meta
 	base.group = props["group"]
 	if _acl == nil {
 		base.acl = acl.ACLFromUnixPerms(0664)
@@ -227,8 +311,9 @@ func defaultFileTreeEntryBase(_acl *acl.ACL, props Properties) (base TreeEntryBa
 	return
 }
 
-func defaultDirTreeEntryBase(_acl *acl.ACL, props Properties) (base TreeEntryBase) {
+func defaultDirTreeEntryBase(_acl *acl.ACL, meta Metadata, props Properties) (base TreeEntryBase) {
 	base.user = props["user"]
+	base.meta = meta
 	base.group = props["group"]
 	if _acl == nil {
 		base.acl = acl.ACLFromUnixPerms(0775)
@@ -261,6 +346,11 @@ func (t Tree) FromPayload(payload []byte) error {
 			_acl = &acltmp
 		}
 
+		meta, err := metadataFromProperties(props)
+		if err != nil {
+			return err
+		}
+
 		entry_type, ok := props["type"]
 		if !ok {
 			return errors.New("Missing property: type")
@@ -274,8 +364,9 @@ func (t Tree) FromPayload(payload []byte) error {
 				return err
 			}
 			entry = TreeEntryFile{
-				TreeEntryBase: defaultFileTreeEntryBase(_acl, props),
+				TreeEntryBase: defaultFileTreeEntryBase(_acl, meta, props),
 				Ref:           ref,
+				Hardlink:      props["hardlink"],
 			}
 		case TETDir:
 			ref, err := getObjectIdFromProps(props, "ref")
@@ -283,7 +374,7 @@ func (t Tree) FromPayload(payload []byte) error {
 				return err
 			}
 			entry = TreeEntryDir{
-				TreeEntryBase: defaultDirTreeEntryBase(_acl, props),
+				TreeEntryBase: defaultDirTreeEntryBase(_acl, meta, props),
 				Ref:           ref,
 			}
 		case TETSymlink:
@@ -292,9 +383,23 @@ func (t Tree) FromPayload(payload []byte) error {
 				return errors.New("Missing key: target")
 			}
 			entry = TreeEntrySymlink{
-				TreeEntryBase: defaultFileTreeEntryBase(_acl, props),
+				TreeEntryBase: defaultFileTreeEntryBase(_acl, meta, props),
 				Target:        target,
 			}
+		case TETBlockDev, TETCharDev, TETFifo, TETSocket:
+			special := TreeEntrySpecial{
+				TreeEntryBase: defaultFileTreeEntryBase(_acl, meta, props),
+				Kind:          TreeEntryType(entry_type),
+			}
+			if special.IsDevice() {
+				if special.Major, err = getUint32FromProps(props, "major"); err != nil {
+					return err
+				}
+				if special.Minor, err = getUint32FromProps(props, "minor"); err != nil {
+					return err
+				}
+			}
+			entry = special
 		default:
 			// TODO: Or should we just ignore this entry? There might be more types in the future...
 			return fmt.Errorf("Unknown tree entry type: %s", entry_type)
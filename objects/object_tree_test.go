@@ -3,7 +3,9 @@ package objects
 import (
 	"bytes"
 	"code.laria.me/petrific/acl"
+	"os"
 	"testing"
+	"time"
 )
 
 var (
@@ -96,6 +98,9 @@ func TestUnserializeTreeFailure(t *testing.T) {
 		{"file ref missing", "name=foo&type=file\n"},
 		{"dir ref missing", "name=foo&type=dir\n"},
 		{"symlink target missing", "name=foo&type=symlink\n"},
+		{"device number missing", "major=1&name=foo&type=chardev\n"},
+		{"invalid mode", "mode=0999&name=foo&target=bar&type=symlink\n"},
+		{"invalid mtime", "mtime=yesterday&name=foo&target=bar&type=symlink\n"},
 	}
 
 	for _, subtest := range subtests {
@@ -106,3 +111,65 @@ func TestUnserializeTreeFailure(t *testing.T) {
 		}
 	}
 }
+
+func TestTreeMetadata(t *testing.T) {
+	meta := Metadata{
+		Mode:     0750 | os.ModeSetgid,
+		HasOwner: true,
+		Uid:      1000,
+		Gid:      100,
+		Mtime:    time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC),
+		Xattrs:   map[string]string{"user.foo": "bar\x00baz"},
+	}
+
+	tree := Tree{"foo": WithMetadata(NewTreeEntryDir(genId(0x11), false), meta, "user1", "users")}
+
+	want := "acl=u::rwx,g::r-x,o::---&gid=100&group=users&mode=2750&mtime=2020-01-02T03:04:05.000000006Z&" +
+		"name=foo&ref=sha3-256:1111111111111111111111111111111111111111111111111111111111111111&type=dir&uid=1000&" +
+		"user=user1&xattr.user.foo=bar%00baz\n"
+	if have := tree.Payload(); string(have) != want {
+		t.Errorf("Unexpected serialization result: %s", have)
+	}
+
+	have := make(Tree)
+	if err := have.FromPayload(tree.Payload()); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if !have.Equals(tree) {
+		t.Errorf("Unexpeced unserialization result: %v", have)
+	}
+	if m := have["foo"].Metadata(); !m.Equals(meta) {
+		t.Errorf("Unexpected metadata: %v", m)
+	}
+}
+
+func TestTreeSpecialEntries(t *testing.T) {
+	file := NewTreeEntryFile(genId(0x11), false)
+	file.Hardlink = "1"
+
+	tree := Tree{
+		"null": NewTreeEntrySpecial(TETCharDev, 1, 3),
+		"pipe": NewTreeEntrySpecial(TETFifo, 0, 0),
+		"a":    file,
+		"b":    file,
+	}
+
+	want := "" +
+		"acl=u::rw-,g::r--,o::r--&hardlink=1&name=a&ref=sha3-256:1111111111111111111111111111111111111111111111111111111111111111&type=file\n" +
+		"acl=u::rw-,g::r--,o::r--&hardlink=1&name=b&ref=sha3-256:1111111111111111111111111111111111111111111111111111111111111111&type=file\n" +
+		"acl=u::rw-,g::r--,o::r--&major=1&minor=3&name=null&type=chardev\n" +
+		"acl=u::rw-,g::r--,o::r--&name=pipe&type=fifo\n"
+	if have := tree.Payload(); string(have) != want {
+		t.Errorf("Unexpected serialization result: %s", have)
+	}
+
+	have := make(Tree)
+	if err := have.FromPayload([]byte(want)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if !have.Equals(tree) {
+		t.Errorf("Unexpeced unserialization result: %v", have)
+	}
+}
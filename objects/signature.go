@@ -0,0 +1,98 @@
+package objects
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrUnsigned = errors.New("snapshot is not signed")
+
+// SignatureType identifies the signature scheme of a signed snapshot
+type SignatureType string
+
+const (
+	SignatureOpenPGP SignatureType = "openpgp" // The snapshot is a clearsigned OpenPGP message
+	SignatureSSH     SignatureType = "ssh"     // The snapshot is followed by an armored SSH signature (see sshsig)
+)
+
+// Verifyers holds a Verifyer for every supported signature type
+type Verifyers map[SignatureType]Verifyer
+
+// SignatureInfo describes a valid signature, as reported by a Verifyer
+type SignatureInfo struct {
+	Signer      string // Name of the signer (e.g. the user ID of an OpenPGP key)
+	KeyId       string // ID of the key that made the signature (might be a subkey of the key identified by Fingerprint)
+	Fingerprint string // Fingerprint of the (primary) key
+	Time        time.Time
+}
+
+func (si SignatureInfo) String() string {
+	s := "signed by key " + si.KeyId
+	if si.Signer != "" {
+		s = fmt.Sprintf("signed by %q, key %s", si.Signer, si.KeyId)
+	}
+	if si.Fingerprint != si.KeyId {
+		s += fmt.Sprintf(" (fingerprint %s)", si.Fingerprint)
+	}
+	if !si.Time.IsZero() {
+		s += " at " + si.Time.Format(time.RFC3339)
+	}
+	return s
+}
+
+// SignaturePolicy decides which snapshots are trusted
+type SignaturePolicy struct {
+	// Reject unsigned snapshots
+	RequireSignature bool
+
+	// Fingerprints of the keys that are trusted to sign snapshots. If empty, every signature the Verifyer
+	// accepts is trusted. Only full fingerprints are accepted, as short key IDs can be forged.
+	TrustedKeys []string
+}
+
+// normalizeFingerprint removes spaces and a "0x" prefix from hexadecimal fingerprints and converts them to upper case.
+// Other fingerprints are returned unchanged.
+func normalizeFingerprint(fingerprint string) string {
+	hex := strings.ToUpper(strings.Replace(fingerprint, " ", "", -1))
+	hex = strings.TrimPrefix(hex, "0X")
+
+	for _, c := range hex {
+		if !(c >= '0' && c <= '9' || c >= 'A' && c <= 'F') {
+			return fingerprint
+		}
+	}
+	return hex
+}
+
+// Trusts checks, if a valid signature was made by a trusted key
+func (p SignaturePolicy) Trusts(si SignatureInfo) bool {
+	if len(p.TrustedKeys) == 0 {
+		return true
+	}
+
+	fingerprint := normalizeFingerprint(si.Fingerprint)
+	for _, trusted := range p.TrustedKeys {
+		if fingerprint != "" && normalizeFingerprint(trusted) == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// Check verifies the signature of a snapshot and checks, if the snapshot is acceptable according to the policy.
+// For accepted unsigned snapshots, the returned SignatureInfo is empty.
+func (p SignaturePolicy) Check(s Snapshot, v Verifyers) (SignatureInfo, error) {
+	si, err := s.Verify(v)
+	if err == ErrUnsigned && !p.RequireSignature {
+		return SignatureInfo{}, nil
+	} else if err != nil {
+		return si, err
+	}
+
+	if !p.Trusts(si) {
+		return si, fmt.Errorf("signature by untrusted key %s", si.Fingerprint)
+	}
+	return si, nil
+}
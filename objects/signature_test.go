@@ -0,0 +1,99 @@
+package objects
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type testVerifyer struct {
+	si  SignatureInfo
+	err error
+}
+
+func (v testVerifyer) Verify([]byte) (SignatureInfo, error) { return v.si, v.err }
+
+func TestSignaturePolicy(t *testing.T) {
+	var signed, unsigned Snapshot
+	if err := signed.FromPayload(append([]byte(pgp_signed_marker+"\nHash: SHA256\n\n"), testSnapshotSerialization...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := unsigned.FromPayload(bytes.Replace(testSnapshotSerialization, []byte("signed yes\n"), nil, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	good := Verifyers{SignatureOpenPGP: testVerifyer{si: SignatureInfo{Fingerprint: "6A30199D2AAD0F2DD2E84D07376269856DDF8A8C"}}}
+	bad := Verifyers{SignatureOpenPGP: testVerifyer{err: errors.New("bad signature")}}
+	trusted := []string{"0x6a30 199d 2aad 0f2d d2e8  4d07 3762 6985 6ddf 8a8c"}
+	untrusted := []string{"0123456789ABCDEF0123456789ABCDEF01234567", "376269856DDF8A8C"}
+
+	subtests := []struct {
+		name     string
+		policy   SignaturePolicy
+		snapshot Snapshot
+		v        Verifyers
+		ok       bool
+	}{
+		{"unsigned", SignaturePolicy{}, unsigned, good, true},
+		{"unsigned required", SignaturePolicy{RequireSignature: true}, unsigned, good, false},
+		{"signed", SignaturePolicy{RequireSignature: true}, signed, good, true},
+		{"bad signature", SignaturePolicy{}, signed, bad, false},
+		{"trusted", SignaturePolicy{TrustedKeys: trusted}, signed, good, true},
+		{"untrusted", SignaturePolicy{TrustedKeys: untrusted}, signed, good, false},
+	}
+
+	for _, subtest := range subtests {
+		_, err := subtest.policy.Check(subtest.snapshot, subtest.v)
+		if subtest.ok && err != nil {
+			t.Errorf("%s: Unexpected error: %s", subtest.name, err)
+		} else if !subtest.ok && err == nil {
+			t.Errorf("%s: Snapshot was accepted", subtest.name)
+		}
+	}
+}
+
+func TestSnapshotVerifyDispatch(t *testing.T) {
+	v := Verifyers{
+		SignatureOpenPGP: testVerifyer{si: SignatureInfo{Signer: "openpgp"}},
+		SignatureSSH:     testVerifyer{si: SignatureInfo{Signer: "ssh"}},
+	}
+
+	subtests := []struct {
+		name    string
+		payload []byte
+		typ     SignatureType
+	}{
+		{"openpgp", append([]byte(pgp_signed_marker+"\nHash: SHA256\n\n"), testSnapshotSerialization...), SignatureOpenPGP},
+		{"ssh", append(append([]byte{}, testSnapshotSerialization...), ssh_signature_marker+"\n"...), SignatureSSH},
+		{"unknown", testSnapshotSerialization, ""},
+	}
+
+	for _, subtest := range subtests {
+		var snapshot Snapshot
+		if err := snapshot.FromPayload(subtest.payload); err != nil {
+			t.Fatalf("%s: %s", subtest.name, err)
+		}
+
+		if typ := snapshot.SignatureType(); typ != subtest.typ {
+			t.Errorf("%s: Unexpected signature type %s", subtest.name, typ)
+		}
+
+		si, err := snapshot.Verify(v)
+		if subtest.typ == "" {
+			if err == nil {
+				t.Errorf("%s: Snapshot with unknown signature type was verified", subtest.name)
+			}
+		} else if err != nil || si.Signer != string(subtest.typ) {
+			t.Errorf("%s: Unexpected verification result %v (err=%v)", subtest.name, si, err)
+		}
+	}
+
+	delete(v, SignatureSSH)
+	var snapshot Snapshot
+	if err := snapshot.FromPayload(subtests[1].payload); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snapshot.Verify(v); err == nil {
+		t.Errorf("SSH signature verified without SSH verifyer")
+	}
+}
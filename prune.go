@@ -0,0 +1,205 @@
+package main
+
+import (
+	"code.laria.me/petrific/backup"
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+func prune(env *Env, dryRun bool) error {
+	deleted, err := backup.Prune(env.Store, dryRun, env.Log)
+
+	verb := "deleted"
+	if dryRun {
+		verb = "would delete"
+	}
+	for _, id := range deleted {
+		fmt.Printf("%s %s\n", verb, id)
+	}
+
+	return err
+}
+
+// forgetByPolicy applies the retention policies to all snapshots (optionally only those of one archive).
+// If policy is empty, the policies from the config are used, archives without a policy are left alone.
+// Snapshots rejected by the signature policy are left alone, so they can not push trusted snapshots out of the policy.
+func forgetByPolicy(env *Env, policy backup.RetentionPolicy, archive string, dryRun bool) error {
+	ids, err := env.Store.List(objects.OTSnapshot)
+	if err != nil {
+		return err
+	}
+
+	check, err := snapshotChecker(env)
+	if err != nil {
+		return err
+	}
+
+	byArchive := make(map[string]sortableSnapshots)
+	for _, id := range ids {
+		_snapshot, err := storage.GetObjectOfType(env.Store, id, objects.OTSnapshot)
+		if err != nil {
+			return fmt.Errorf("could not get snapshot %s: %s", id, err)
+		}
+		snapshot := *_snapshot.(*objects.Snapshot)
+
+		if archive != "" && snapshot.Archive != archive {
+			continue
+		}
+
+		byArchive[snapshot.Archive] = append(byArchive[snapshot.Archive], snapshotWithId{id, snapshot})
+	}
+
+	for name, snapshots := range byArchive {
+		archivePolicy := policy
+		if archivePolicy.IsEmpty() {
+			keep := env.Conf.Archive[name].Keep
+			if keep == "" {
+				env.Log.Info().Printf("no retention policy for archive %s, keeping all snapshots", name)
+				continue
+			}
+
+			if archivePolicy, err = backup.ParseRetentionPolicy(keep); err != nil {
+				return fmt.Errorf("retention policy of archive %s: %s", name, err)
+			}
+		}
+
+		trusted := make(sortableSnapshots, 0, len(snapshots))
+		for _, s := range snapshots {
+			if err := check(s.snapshot); err != nil {
+				env.Log.Warn().Printf("ignoring snapshot %s: verification failed: %s", s.id, err)
+				continue
+			}
+			trusted = append(trusted, s)
+		}
+		snapshots = trusted
+
+		sort.Sort(snapshots)
+
+		plain := make([]objects.Snapshot, len(snapshots))
+		for i, s := range snapshots {
+			plain[i] = s.snapshot
+		}
+		keep := archivePolicy.Apply(plain)
+
+		for i, s := range snapshots {
+			if keep[i] {
+				env.Log.Info().Printf("keeping %s (%s %s)", s.id, s.snapshot.Archive, s.snapshot.Date)
+				continue
+			}
+
+			if dryRun {
+				fmt.Printf("would forget %s\t%s\t%s\n", s.snapshot.Archive, s.snapshot.Date, s.id)
+				continue
+			}
+
+			if err := env.Store.Delete(s.id); err != nil {
+				return fmt.Errorf("could not forget %s: %s", s.id, err)
+			}
+			fmt.Printf("forgot %s\t%s\t%s\n", s.snapshot.Archive, s.snapshot.Date, s.id)
+		}
+	}
+
+	return nil
+}
+
+func Forget(env *Env, args []string) int {
+	flags := flag.NewFlagSet(os.Args[0]+" forget", flag.ContinueOnError)
+	doPrune := flags.Bool("prune", false, "also delete all objects that are no longer referenced afterwards (not together with -dry-run)")
+	dryRun := flags.Bool("dry-run", false, "only list the snapshots that would be forgotten")
+	policyStr := flags.String("policy", "", "retention policy like \"last=3,daily=7,weekly=4\" (default: the policies configured per archive)")
+	archive := flags.String("archive", "", "only apply the retention policy to this archive")
+
+	flags.Usage = subcmdUsage("forget", "[flags] [snapshot-id...]", flags)
+	errout := subcmdErrout(env.Log, "forget")
+
+	if err := flags.Parse(args); err != nil {
+		errout(err)
+		return 2
+	}
+
+	// A dry run does not forget the snapshots, so pruning could not show which objects would be deleted
+	if *doPrune && *dryRun {
+		errout(errors.New("-prune can not be combined with -dry-run"))
+		return 2
+	}
+
+	args = flags.Args()
+
+	if len(args) == 0 {
+		var policy backup.RetentionPolicy
+		if *policyStr != "" {
+			var err error
+			if policy, err = backup.ParseRetentionPolicy(*policyStr); err != nil {
+				errout(err)
+				return 2
+			}
+		}
+
+		if err := forgetByPolicy(env, policy, *archive, *dryRun); err != nil {
+			errout(err)
+			return 1
+		}
+	} else {
+		if *policyStr != "" || *archive != "" {
+			errout(errors.New("-policy and -archive can not be combined with snapshot ids"))
+			return 2
+		}
+
+		ids := make([]objects.ObjectId, 0, len(args))
+		for _, arg := range args {
+			id, err := objects.ParseObjectId(arg)
+			if err != nil {
+				errout(fmt.Errorf("invalid snapshot id %s: %s", arg, err))
+				return 1
+			}
+			ids = append(ids, id)
+		}
+
+		for _, id := range ids {
+			if *dryRun {
+				fmt.Printf("would forget %s\n", id)
+				continue
+			}
+
+			if err := backup.ForgetSnapshot(env.Store, id); err != nil {
+				errout(fmt.Errorf("could not forget %s: %s", id, err))
+				return 1
+			}
+			fmt.Printf("forgot %s\n", id)
+		}
+	}
+
+	if *doPrune {
+		if err := prune(env, false); err != nil {
+			errout(err)
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func Prune(env *Env, args []string) int {
+	flags := flag.NewFlagSet(os.Args[0]+" prune", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only list the objects that would be deleted")
+
+	flags.Usage = subcmdUsage("prune", "[flags]", flags)
+	errout := subcmdErrout(env.Log, "prune")
+
+	if err := flags.Parse(args); err != nil {
+		errout(err)
+		return 2
+	}
+
+	if err := prune(env, *dryRun); err != nil {
+		errout(err)
+		return 1
+	}
+
+	return 0
+}
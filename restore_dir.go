@@ -4,15 +4,77 @@ import (
 	"code.laria.me/petrific/backup"
 	"code.laria.me/petrific/fs"
 	"code.laria.me/petrific/objects"
+	"errors"
+	"flag"
 	"fmt"
+	"os"
+	"strings"
 )
 
+// stringList is a flag.Value collecting all values of a flag that can be given multiple times
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ", ")
+}
+
+func (sl *stringList) Set(s string) error {
+	*sl = append(*sl, s)
+	return nil
+}
+
+// restoreFlags adds the flags controlling a restore to flags.
+// The returned function builds the backup.RestoreOptions, call it after parsing the flags.
+func restoreFlags(flags *flag.FlagSet) func() (backup.RestoreOptions, error) {
+	include := new(stringList)
+	exclude := new(stringList)
+	flags.Var(include, "include", "only restore paths matching this glob pattern, like \"/etc/nginx/**\" (can be given multiple times)")
+	flags.Var(exclude, "exclude", "don't restore paths matching this glob pattern (can be given multiple times)")
+
+	nonDestructive := flags.Bool("non-destructive", false, "never delete files that are not in the backup")
+	conflict := flags.String("conflict", string(backup.ConflictOverwrite), "what to do with files that already exist: overwrite, skip, keep-newer (keep files modified after the snapshot) or rename (restore with a suffix)")
+	renameSuffix := flags.String("rename-suffix", backup.DefaultRenameSuffix, "suffix for restored files, if -conflict=rename")
+	owner := flags.Bool("owner", os.Geteuid() == 0, "restore the owner and group of files (default when running as root)")
+
+	return func() (opts backup.RestoreOptions, err error) {
+		if opts.Filter, err = backup.ParsePathFilter(*include, *exclude); err != nil {
+			return
+		}
+
+		opts.NonDestructive = *nonDestructive
+		opts.Owner = *owner
+		opts.RenameSuffix = *renameSuffix
+		opts.Conflict, err = backup.ParseConflictPolicy(*conflict)
+		return
+	}
+}
+
 func RestoreDir(env *Env, args []string) int {
-	usage := subcmdUsage("restore-dir", "directory object-id", nil)
+	flags := flag.NewFlagSet(os.Args[0]+" restore-dir", flag.ContinueOnError)
+	getOpts := restoreFlags(flags)
+
+	flags.Usage = subcmdUsage("restore-dir", "[flags] directory object-id", flags)
 	errout := subcmdErrout(env.Log, "restore-dir")
 
+	if err := flags.Parse(args); err != nil {
+		errout(err)
+		return 2
+	}
+
+	args = flags.Args()
 	if len(args) != 2 {
-		usage()
+		flags.Usage()
+		return 2
+	}
+
+	opts, err := getOpts()
+	if err != nil {
+		errout(err)
+		return 2
+	}
+
+	if opts.Conflict == backup.ConflictKeepNewer {
+		errout(errors.New("-conflict=keep-newer needs the time of a snapshot, use restore-snapshot"))
 		return 2
 	}
 
@@ -39,7 +101,7 @@ func RestoreDir(env *Env, args []string) int {
 		return 1
 	}
 
-	if err := backup.RestoreDir(env.Store, id, d, env.Log); err != nil {
+	if err := backup.RestoreDir(env.Store, id, d, env.Log, opts); err != nil {
 		errout(err)
 		return 1
 	}
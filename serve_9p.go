@@ -0,0 +1,92 @@
+package main
+
+import (
+	"code.laria.me/petrific/snapshotfs"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// parseListenAddress parses addresses like "tcp:localhost:5640" or "unix:/run/petrific.sock"
+func parseListenAddress(addr string) (network, address string, err error) {
+	parts := strings.SplitN(addr, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid address %s, expected tcp:host:port or unix:path", addr)
+	}
+
+	switch parts[0] {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return parts[0], parts[1], nil
+	default:
+		return "", "", fmt.Errorf("unsupported network %s in address %s", parts[0], addr)
+	}
+}
+
+func Serve9P(env *Env, args []string) int {
+	flags := flag.NewFlagSet(os.Args[0]+" serve-9p", flag.ContinueOnError)
+	flags.Usage = subcmdUsage("serve-9p", "[flags] address\n\naddress is tcp:host:port or unix:path.\n"+
+		"There is no authentication, every client can read every snapshot (regardless of file ownership).\n"+
+		"Prefer a unix socket (only accessible by the current user) or listen on a loopback address only.", flags)
+	errout := subcmdErrout(env.Log, "serve-9p")
+
+	if err := flags.Parse(args); err != nil {
+		errout(err)
+		return 2
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	network, address, err := parseListenAddress(flags.Arg(0))
+	if err != nil {
+		errout(err)
+		return 2
+	}
+
+	fsys, err := trustedSnapshotFS(env)
+	if err != nil {
+		errout(err)
+		return 1
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		errout(err)
+		return 1
+	}
+
+	// Only the owner may connect to the unix socket
+	if network == "unix" {
+		if err := os.Chmod(address, 0600); err != nil {
+			l.Close()
+			errout(err)
+			return 1
+		}
+	}
+
+	// Closing the listener stops serving and removes the unix socket
+	stopped := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stopped)
+		l.Close()
+	}()
+
+	env.Log.Info().Printf("serve-9p: serving snapshots on %s", l.Addr())
+	err = snapshotfs.Serve9P(fsys, l)
+	select {
+	case <-stopped:
+		return 0
+	default:
+		errout(err)
+		return 1
+	}
+}
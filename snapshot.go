@@ -3,8 +3,8 @@ package main
 import (
 	"code.laria.me/petrific/backup"
 	"code.laria.me/petrific/fs"
-	"code.laria.me/petrific/gpg"
 	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/snapshotfs"
 	"code.laria.me/petrific/storage"
 	"errors"
 	"flag"
@@ -27,8 +27,12 @@ func createSnapshot(env *Env, archive, comment string, tree_id objects.ObjectId,
 	if nosign {
 		payload = snapshot.Payload()
 	} else {
-		var err error
-		payload, err = snapshot.SignedPayload(env.Conf.GPGSigner())
+		signer, err := env.Conf.Signer()
+		if err != nil {
+			return objects.ObjectId{}, fmt.Errorf("could not load signing key: %s", err)
+		}
+
+		payload, err = snapshot.SignedPayload(signer)
 		if err != nil {
 			return objects.ObjectId{}, fmt.Errorf("could not sign: %s", err)
 		}
@@ -82,6 +86,7 @@ func TakeSnapshot(env *Env, args []string) int {
 	flags := flag.NewFlagSet(os.Args[0]+" take-snapshot", flag.ContinueOnError)
 	nosign := flags.Bool("nosign", false, "don't sign the snapshot (not recommended)")
 	comment := flags.String("comment", "", "comment for the snapshot")
+	getOpts := writeDirFlags(env, flags)
 
 	flags.Usage = subcmdUsage("take-snapshot", "[flags] archive dir", flags)
 	errout := subcmdErrout(env.Log, "take-snapshot")
@@ -114,7 +119,13 @@ func TakeSnapshot(env *Env, args []string) int {
 		return 1
 	}
 
-	tree_id, err := backup.WriteDir(env.Store, dir_path, d, env.IdCache, env.Log)
+	opts, err := getOpts(args[0])
+	if err != nil {
+		errout(err)
+		return 1
+	}
+
+	tree_id, err := backup.WriteDir(env.Store, dir_path, d, env.IdCache, env.Log, opts)
 	if err != nil {
 		errout(err)
 		return 1
@@ -192,11 +203,99 @@ func ListSnapshots(env *Env, args []string) int {
 	return 0
 }
 
+// getSnapshots returns the snapshots of an archive (or all snapshots, if archive is empty), newest first.
+// Snapshots that can not be loaded are skipped with a warning.
+func getSnapshots(env *Env, archive string) (sortableSnapshots, error) {
+	objids, err := env.Store.List(objects.OTSnapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make(sortableSnapshots, 0)
+	for _, objid := range objids {
+		snapshot, err := storage.GetObjectOfType(env.Store, objid, objects.OTSnapshot)
+		if err != nil {
+			env.Log.Warn().Printf("could not get snapshot %s: %s", objid, err)
+			continue
+		}
+
+		if archive == "" || snapshot.(*objects.Snapshot).Archive == archive {
+			snapshots = append(snapshots, snapshotWithId{objid, *snapshot.(*objects.Snapshot)})
+		}
+	}
+
+	sort.Sort(snapshots)
+	return snapshots, nil
+}
+
+// snapshotChecker returns a function that checks a snapshot against the signature policy of its archive
+func snapshotChecker(env *Env) (func(objects.Snapshot) error, error) {
+	verifyers, err := env.Conf.Verifyers()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(s objects.Snapshot) error {
+		_, err := env.Conf.SignaturePolicy(s.Archive).Check(s, verifyers)
+		return err
+	}, nil
+}
+
+// loadTrustedSnapshot gets the snapshot with the given id or, if archive is not empty, the latest snapshot of that
+// archive. Only snapshots accepted by the signature policy of their archive are returned, newer snapshots of the
+// archive that are rejected are skipped with a warning.
+func loadTrustedSnapshot(env *Env, id objects.ObjectId, archive string) (*objects.Snapshot, error) {
+	check, err := snapshotChecker(env)
+	if err != nil {
+		return nil, err
+	}
+
+	if archive == "" {
+		obj, err := storage.GetObjectOfType(env.Store, id, objects.OTSnapshot)
+		if err != nil {
+			return nil, err
+		}
+		snapshot := obj.(*objects.Snapshot)
+
+		if err := check(*snapshot); err != nil {
+			return nil, fmt.Errorf("verification of snapshot %s failed: %s", id, err)
+		}
+		return snapshot, nil
+	}
+
+	snapshots, err := getSnapshots(env, archive)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, snapshot := range snapshots {
+		if err := check(snapshot.snapshot); err != nil {
+			env.Log.Warn().Printf("skipping snapshot %s: verification failed: %s", snapshot.id, err)
+			continue
+		}
+		return &snapshot.snapshot, nil
+	}
+	return nil, fmt.Errorf("no trusted snapshot of archive %s found", archive)
+}
+
+// trustedSnapshotFS creates a snapshotfs.FS that hides snapshots rejected by the signature policy of their archive
+func trustedSnapshotFS(env *Env) (*snapshotfs.FS, error) {
+	check, err := snapshotChecker(env)
+	if err != nil {
+		return nil, err
+	}
+
+	fsys := snapshotfs.New(env.Store, env.Log)
+	fsys.Verify = check
+	return fsys, nil
+}
+
 func RestoreSnapshot(env *Env, args []string) int {
 	var snapshotId objects.ObjectId
 	flags := flag.NewFlagSet("restore-snapshot", flag.ContinueOnError)
 	flags.Var(&snapshotId, "id", "Object id of a snapshot")
 	archive := flags.String("archive", "", "Get latest snapshot for this archive")
+	getOpts := restoreFlags(flags)
 
 	flags.Usage = subcmdUsage("restore-snapshot", "[flags] directory", flags)
 	errout := subcmdErrout(env.Log, "restore-snapshot")
@@ -219,6 +318,12 @@ func RestoreSnapshot(env *Env, args []string) int {
 		return 2
 	}
 
+	opts, err := getOpts()
+	if err != nil {
+		errout(err)
+		return 2
+	}
+
 	dir_path, err := abspath(args[0])
 	if err != nil {
 		errout(err)
@@ -244,29 +349,15 @@ func RestoreSnapshot(env *Env, args []string) int {
 		return 1
 	}
 
-	var snapshot *objects.Snapshot
-
-	if *archive != "" {
-		snapshot, err = storage.FindLatestSnapshot(env.Store, *archive)
-		if err != nil {
-			errout(err)
-			return 1
-		}
-	} else {
-		_snapshot, err := storage.GetObjectOfType(env.Store, snapshotId, objects.OTSnapshot)
-		if err != nil {
-			errout(err)
-			return 1
-		}
-		snapshot = _snapshot.(*objects.Snapshot)
-	}
-
-	if err := snapshot.Verify(gpg.Verifyer{}); err != nil {
-		errout(fmt.Errorf("verification failed: %s", err))
+	snapshot, err := loadTrustedSnapshot(env, snapshotId, *archive)
+	if err != nil {
+		errout(err)
 		return 1
 	}
 
-	if err := backup.RestoreDir(env.Store, snapshot.Tree, root, env.Log); err != nil {
+	opts.BackupTime = snapshot.Date
+
+	if err := backup.RestoreDir(env.Store, snapshot.Tree, root, env.Log, opts); err != nil {
 		errout(err)
 		return 1
 	}
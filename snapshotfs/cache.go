@@ -0,0 +1,61 @@
+package snapshotfs
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lruCache is a least recently used cache of decoded objects. The cost of the elements is limited to maxCost.
+type lruCache struct {
+	mu      sync.Mutex
+	maxCost int
+	cost    int
+	order   *list.List // Most recently used at the front
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+	cost  int
+}
+
+func newLRUCache(maxCost int) *lruCache {
+	return &lruCache{
+		maxCost: maxCost,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry).value, true
+}
+
+func (c *lruCache) add(key string, value interface{}, cost int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key, value, cost})
+	c.cost += cost
+
+	// Always keep the newest element, even if it alone is too expensive
+	for c.cost > c.maxCost && c.order.Len() > 1 {
+		oldest := c.order.Remove(c.order.Back()).(*lruEntry)
+		delete(c.entries, oldest.key)
+		c.cost -= oldest.cost
+	}
+}
@@ -0,0 +1,149 @@
+//go:build linux
+
+package snapshotfs
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"code.laria.me/petrific/objects"
+	"context"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Attributes of virtual directories can change, when snapshots are created
+const virtualAttrValid = 10 * time.Second
+
+type fuseFS struct {
+	root *Node
+}
+
+func (f fuseFS) Root() (fs.Node, error) {
+	return fuseNode{f.root}, nil
+}
+
+type fuseNode struct {
+	*Node
+}
+
+func fuseError(err error) error {
+	if os.IsNotExist(err) {
+		return syscall.ENOENT
+	}
+	if err == ErrNotRegularFile {
+		return syscall.EISDIR
+	}
+	return err
+}
+
+func (n fuseNode) Attr(ctx context.Context, attr *fuse.Attr) error {
+	size, err := n.Size()
+	if err != nil {
+		return err
+	}
+
+	attr.Mode = n.Mode()
+	attr.Size = uint64(size)
+	attr.Blocks = (attr.Size + 511) / 512
+	attr.Mtime = n.ModTime()
+	attr.Atime = attr.Mtime
+	attr.Ctime = attr.Mtime
+	attr.Nlink = 1
+	attr.Uid = uint32(os.Getuid())
+	attr.Gid = uint32(os.Getgid())
+
+	if n.kind != kindEntry {
+		attr.Valid = virtualAttrValid
+		return nil
+	}
+
+	attr.Valid = time.Hour // Everything below a snapshot is immutable
+	if meta := n.entry.Metadata(); meta.HasOwner {
+		attr.Uid = uint32(meta.Uid)
+		attr.Gid = uint32(meta.Gid)
+	}
+	if special, ok := n.entry.(objects.TreeEntrySpecial); ok && special.IsDevice() {
+		attr.Rdev = uint32(unix.Mkdev(special.Major, special.Minor))
+	}
+	return nil
+}
+
+func (n fuseNode) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	child, err := n.Node.Lookup(name)
+	if err != nil {
+		return nil, fuseError(err)
+	}
+	return fuseNode{child}, nil
+}
+
+func direntType(n *Node) fuse.DirentType {
+	switch n.Type() {
+	case objects.TETDir:
+		return fuse.DT_Dir
+	case objects.TETFile:
+		return fuse.DT_File
+	case objects.TETSymlink:
+		return fuse.DT_Link
+	case objects.TETBlockDev:
+		return fuse.DT_Block
+	case objects.TETCharDev:
+		return fuse.DT_Char
+	case objects.TETFifo:
+		return fuse.DT_FIFO
+	case objects.TETSocket:
+		return fuse.DT_Socket
+	default:
+		return fuse.DT_Unknown
+	}
+}
+
+func (n fuseNode) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	children, err := n.Children()
+	if err != nil {
+		return nil, fuseError(err)
+	}
+
+	dirents := make([]fuse.Dirent, 0, len(children))
+	for _, child := range children {
+		dirents = append(dirents, fuse.Dirent{Name: child.Name(), Type: direntType(child)})
+	}
+	return dirents, nil
+}
+
+func (n fuseNode) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	return n.Node.Readlink()
+}
+
+func (n fuseNode) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	buf := make([]byte, req.Size)
+	read, err := n.ReadAt(buf, req.Offset)
+	if err != nil && err != io.EOF {
+		return fuseError(err)
+	}
+	resp.Data = buf[:read]
+	return nil
+}
+
+// Mount mounts the filesystem read-only at mountpoint and serves it until it gets unmounted.
+// If allow_other is set, other users can access the filesystem (requires user_allow_other in /etc/fuse.conf).
+func Mount(fsys *FS, mountpoint string, allow_other bool) error {
+	options := []fuse.MountOption{fuse.ReadOnly(), fuse.FSName("petrific"), fuse.Subtype("petrific")}
+	if allow_other {
+		options = append(options, fuse.AllowOther(), fuse.DefaultPermissions())
+	}
+
+	conn, err := fuse.Mount(mountpoint, options...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return fs.Serve(conn, fuseFS{fsys.Root()})
+}
+
+func Unmount(mountpoint string) error {
+	return fuse.Unmount(mountpoint)
+}
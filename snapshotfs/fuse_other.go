@@ -0,0 +1,17 @@
+//go:build !linux
+
+package snapshotfs
+
+import (
+	"errors"
+)
+
+var errNoFUSE = errors.New("FUSE is not supported on this platform")
+
+func Mount(fsys *FS, mountpoint string, allow_other bool) error {
+	return errNoFUSE
+}
+
+func Unmount(mountpoint string) error {
+	return errNoFUSE
+}
@@ -0,0 +1,479 @@
+package snapshotfs
+
+import (
+	"9fans.net/go/plan9"
+	"code.laria.me/petrific/objects"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+)
+
+const (
+	maxMsize = 1024 * 1024
+	minMsize = plan9.IOHDRSZ + 512 // Room for the header and at least a directory entry
+)
+
+var (
+	errReadOnly      = errors.New("read-only file system")
+	errNoAuth        = errors.New("authentication not required")
+	errUnknownFid    = errors.New("unknown fid")
+	errFidInUse      = errors.New("fid already in use")
+	errNotOpen       = errors.New("fid not open for reading")
+	errAlreadyOpen   = errors.New("fid already open")
+	errBadDirOffset  = errors.New("bad offset in directory read")
+	errWalkNotDir    = errors.New("walk in non-directory")
+	errNoVersion     = errors.New("version not negotiated")
+	errMsizeTooSmall = errors.New("msize too small")
+	errUnknownMethod = errors.New("unknown message type")
+)
+
+type ninepFid struct {
+	node *Node
+	path string // Path of the node inside the filesystem, used to generate the qid
+	open bool
+
+	// State of a directory read. Directory reads must continue where the last one ended or start over at 0.
+	dirents    [][]byte
+	dir_index  int
+	dir_offset uint64
+}
+
+type ninepConn struct {
+	fsys  *FS
+	rwc   io.ReadWriteCloser
+	msize uint32
+	fids  map[uint32]*ninepFid
+}
+
+// Serve9P serves the filesystem read-only using the 9P2000 protocol on every connection accepted by l.
+// It returns, when l fails to accept a connection (e.g. because it was closed).
+func Serve9P(fsys *FS, l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go fsys.Serve9PConn(conn)
+	}
+}
+
+// Serve9PConn serves the filesystem read-only using the 9P2000 protocol on a single connection.
+// Requests are answered in order. The connection is closed, when the client disconnects or sends an invalid message.
+func (fsys *FS) Serve9PConn(rwc io.ReadWriteCloser) {
+	defer rwc.Close()
+
+	c := &ninepConn{fsys: fsys, rwc: rwc, fids: make(map[uint32]*ninepFid)}
+	for {
+		req, err := c.readFcall()
+		if err != nil {
+			if err != io.EOF {
+				fsys.log.Debug().Printf("9p: closing connection: %s", err)
+			}
+			return
+		}
+
+		resp, err := c.handle(req)
+		if err != nil {
+			resp = &plan9.Fcall{Type: plan9.Rerror, Ename: err.Error()}
+		}
+		resp.Tag = req.Tag
+
+		if err := plan9.WriteFcall(rwc, resp); err != nil {
+			fsys.log.Debug().Printf("9p: closing connection: %s", err)
+			return
+		}
+	}
+}
+
+// readFcall reads a message. Unlike plan9.ReadFcall, it checks the size of the message before reading it, so a client
+// can not make the server allocate arbitrary amounts of memory.
+func (c *ninepConn) readFcall() (*plan9.Fcall, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(c.rwc, size[:]); err != nil {
+		return nil, err
+	}
+
+	max := c.msize
+	if max == 0 {
+		max = maxMsize
+	}
+	n := binary.LittleEndian.Uint32(size[:])
+	if n < uint32(len(size)) || n > max {
+		return nil, fmt.Errorf("invalid message size %d", n)
+	}
+
+	buf := make([]byte, n)
+	copy(buf, size[:])
+	if _, err := io.ReadFull(c.rwc, buf[len(size):]); err != nil {
+		return nil, err
+	}
+	return plan9.UnmarshalFcall(buf)
+}
+
+func (c *ninepConn) handle(req *plan9.Fcall) (*plan9.Fcall, error) {
+	if req.Type != plan9.Tversion && c.msize == 0 {
+		return nil, errNoVersion
+	}
+
+	switch req.Type {
+	case plan9.Tversion:
+		return c.version(req)
+	case plan9.Tauth:
+		return nil, errNoAuth
+	case plan9.Tattach:
+		return c.attach(req)
+	case plan9.Tflush:
+		// Requests are handled in order, so the flushed request has already been answered
+		return &plan9.Fcall{Type: plan9.Rflush}, nil
+	case plan9.Twalk:
+		return c.walk(req)
+	case plan9.Topen:
+		return c.open(req)
+	case plan9.Tread:
+		return c.read(req)
+	case plan9.Tstat:
+		return c.stat(req)
+	case plan9.Tclunk:
+		if _, err := c.fid(req.Fid); err != nil {
+			return nil, err
+		}
+		delete(c.fids, req.Fid)
+		return &plan9.Fcall{Type: plan9.Rclunk}, nil
+	case plan9.Tremove:
+		// The fid gets clunked, even if the remove fails
+		delete(c.fids, req.Fid)
+		return nil, errReadOnly
+	case plan9.Tcreate, plan9.Twrite, plan9.Twstat:
+		return nil, errReadOnly
+	default:
+		return nil, errUnknownMethod
+	}
+}
+
+func (c *ninepConn) fid(fid uint32) (*ninepFid, error) {
+	f, ok := c.fids[fid]
+	if !ok {
+		return nil, errUnknownFid
+	}
+	return f, nil
+}
+
+func (c *ninepConn) version(req *plan9.Fcall) (*plan9.Fcall, error) {
+	// A version request aborts all outstanding I/O and clunks all fids
+	c.fids = make(map[uint32]*ninepFid)
+
+	if req.Msize < minMsize {
+		c.msize = 0
+		return nil, errMsizeTooSmall
+	}
+
+	c.msize = req.Msize
+	if c.msize > maxMsize {
+		c.msize = maxMsize
+	}
+
+	version := plan9.VERSION9P
+	if len(req.Version) < len(version) || req.Version[:len(version)] != version {
+		version = "unknown"
+		c.msize = 0
+	}
+	return &plan9.Fcall{Type: plan9.Rversion, Msize: c.msize, Version: version}, nil
+}
+
+func (c *ninepConn) attach(req *plan9.Fcall) (*plan9.Fcall, error) {
+	if req.Afid != plan9.NOFID {
+		return nil, errNoAuth
+	}
+	if _, ok := c.fids[req.Fid]; ok {
+		return nil, errFidInUse
+	}
+
+	f := &ninepFid{node: c.fsys.Root(), path: "/"}
+	c.fids[req.Fid] = f
+	return &plan9.Fcall{Type: plan9.Rattach, Qid: qidOf(f.node, f.path)}, nil
+}
+
+func (c *ninepConn) walk(req *plan9.Fcall) (*plan9.Fcall, error) {
+	f, err := c.fid(req.Fid)
+	if err != nil {
+		return nil, err
+	}
+	if f.open {
+		return nil, errAlreadyOpen
+	}
+	if _, ok := c.fids[req.Newfid]; ok && req.Newfid != req.Fid {
+		return nil, errFidInUse
+	}
+
+	node, p := f.node, f.path
+	qids := []plan9.Qid{}
+	for _, name := range req.Wname {
+		if !node.IsDir() {
+			err = errWalkNotDir
+			break
+		}
+
+		if name == ".." {
+			// Nodes don't know their parents, so we walk down from the root again
+			node, err = c.lookupPath(path.Dir(p))
+		} else {
+			node, err = node.Lookup(name)
+		}
+		if err != nil {
+			break
+		}
+		p = path.Join(p, name)
+		qids = append(qids, qidOf(node, p))
+	}
+
+	if err != nil {
+		// Only an error for the first element is reported as an error, otherwise the client learns about the
+		// failure by the number of qids
+		if len(qids) == 0 {
+			return nil, ninepError(err)
+		}
+		return &plan9.Fcall{Type: plan9.Rwalk, Wqid: qids}, nil
+	}
+
+	c.fids[req.Newfid] = &ninepFid{node: node, path: p}
+	return &plan9.Fcall{Type: plan9.Rwalk, Wqid: qids}, nil
+}
+
+func (c *ninepConn) lookupPath(p string) (*Node, error) {
+	node := c.fsys.Root()
+	for _, name := range splitPath(p) {
+		var err error
+		if node, err = node.Lookup(name); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+func splitPath(p string) (names []string) {
+	for p != "/" && p != "." && p != "" {
+		names = append([]string{path.Base(p)}, names...)
+		p = path.Dir(p)
+	}
+	return
+}
+
+func (c *ninepConn) open(req *plan9.Fcall) (*plan9.Fcall, error) {
+	f, err := c.fid(req.Fid)
+	if err != nil {
+		return nil, err
+	}
+	if f.open {
+		return nil, errAlreadyOpen
+	}
+
+	mode := req.Mode &^ plan9.OCEXEC
+	if mode != plan9.OREAD && mode != plan9.OEXEC {
+		return nil, errReadOnly
+	}
+
+	switch f.node.Type() {
+	case objects.TETFile, objects.TETDir, objects.TETSymlink:
+	default:
+		return nil, errors.New("special files can not be opened")
+	}
+
+	f.open = true
+	return &plan9.Fcall{Type: plan9.Ropen, Qid: qidOf(f.node, f.path), Iounit: c.msize - plan9.IOHDRSZ}, nil
+}
+
+func (c *ninepConn) read(req *plan9.Fcall) (*plan9.Fcall, error) {
+	f, err := c.fid(req.Fid)
+	if err != nil {
+		return nil, err
+	}
+	if !f.open {
+		return nil, errNotOpen
+	}
+
+	count := req.Count
+	if max := c.msize - plan9.IOHDRSZ; count > max {
+		count = max
+	}
+
+	var data []byte
+	switch f.node.Type() {
+	case objects.TETDir:
+		data, err = c.readDir(f, req.Offset, count)
+	case objects.TETSymlink:
+		// Plain 9P2000 has no readlink, the target is the content of the symlink
+		target, _ := f.node.Readlink()
+		data = readString(target, req.Offset, count)
+	default:
+		buf := make([]byte, count)
+		n, err := f.node.ReadAt(buf, int64(req.Offset))
+		if err != nil && err != io.EOF {
+			return nil, ninepError(err)
+		}
+		data = buf[:n]
+	}
+	if err != nil {
+		return nil, ninepError(err)
+	}
+
+	return &plan9.Fcall{Type: plan9.Rread, Data: data}, nil
+}
+
+func readString(s string, offset uint64, count uint32) []byte {
+	if offset >= uint64(len(s)) {
+		return []byte{}
+	}
+	s = s[offset:]
+	if uint64(len(s)) > uint64(count) {
+		s = s[:count]
+	}
+	return []byte(s)
+}
+
+// readDir returns as many whole directory entries as fit into count bytes
+func (c *ninepConn) readDir(f *ninepFid, offset uint64, count uint32) ([]byte, error) {
+	if offset == 0 {
+		children, err := f.node.Children()
+		if err != nil {
+			return nil, err
+		}
+
+		f.dirents = make([][]byte, 0, len(children))
+		for _, child := range children {
+			dir, err := dirOf(child, path.Join(f.path, child.Name()))
+			if err != nil {
+				return nil, err
+			}
+			b, err := dir.Bytes()
+			if err != nil {
+				return nil, err
+			}
+			f.dirents = append(f.dirents, b)
+		}
+		f.dir_index = 0
+		f.dir_offset = 0
+	} else if offset != f.dir_offset || f.dirents == nil {
+		return nil, errBadDirOffset
+	}
+
+	data := []byte{}
+	for ; f.dir_index < len(f.dirents); f.dir_index++ {
+		dirent := f.dirents[f.dir_index]
+		if len(data)+len(dirent) > int(count) {
+			break
+		}
+		data = append(data, dirent...)
+	}
+	f.dir_offset += uint64(len(data))
+	return data, nil
+}
+
+func (c *ninepConn) stat(req *plan9.Fcall) (*plan9.Fcall, error) {
+	f, err := c.fid(req.Fid)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := dirOf(f.node, f.path)
+	if err != nil {
+		return nil, ninepError(err)
+	}
+	b, err := dir.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return &plan9.Fcall{Type: plan9.Rstat, Stat: b}, nil
+}
+
+func ninepError(err error) error {
+	if os.IsNotExist(err) {
+		return errors.New("file does not exist")
+	}
+	return err
+}
+
+// qidOf returns the qid of a node. The qid path is derived from the path of the node, as the filesystem never changes
+// below a snapshot.
+func qidOf(n *Node, p string) plan9.Qid {
+	h := fnv.New64a()
+	h.Write([]byte(p))
+
+	qid := plan9.Qid{Path: h.Sum64(), Type: plan9.QTFILE}
+	switch n.Type() {
+	case objects.TETDir:
+		qid.Type = plan9.QTDIR
+	case objects.TETSymlink:
+		qid.Type = plan9.QTSYMLINK
+	}
+	return qid
+}
+
+func ownerName(name string, id int, has_owner bool) string {
+	if name != "" {
+		return name
+	}
+	if has_owner {
+		return strconv.Itoa(id)
+	}
+	return "none"
+}
+
+func dirOf(n *Node, p string) (*plan9.Dir, error) {
+	size, err := n.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	mode := n.Mode()
+	perm := plan9.Perm(mode.Perm())
+	switch {
+	case mode&os.ModeDir != 0:
+		perm |= plan9.DMDIR
+		size = 0
+	case mode&os.ModeSymlink != 0:
+		perm |= plan9.DMSYMLINK
+	case mode&os.ModeDevice != 0:
+		perm |= plan9.DMDEVICE
+	case mode&os.ModeNamedPipe != 0:
+		perm |= plan9.DMNAMEDPIPE
+	case mode&os.ModeSocket != 0:
+		perm |= plan9.DMSOCKET
+	}
+	if mode&os.ModeSetuid != 0 {
+		perm |= plan9.DMSETUID
+	}
+	if mode&os.ModeSetgid != 0 {
+		perm |= plan9.DMSETGID
+	}
+
+	name := n.Name()
+	if p == "/" {
+		name = "/"
+	}
+
+	uid, gid := "none", "none"
+	if n.kind == kindEntry {
+		meta := n.entry.Metadata()
+		uid = ownerName(n.entry.User(), meta.Uid, meta.HasOwner)
+		gid = ownerName(n.entry.Group(), meta.Gid, meta.HasOwner)
+	}
+
+	mtime := uint32(n.ModTime().Unix())
+	return &plan9.Dir{
+		Qid:    qidOf(n, p),
+		Mode:   perm,
+		Atime:  mtime,
+		Mtime:  mtime,
+		Length: uint64(size),
+		Name:   name,
+		Uid:    uid,
+		Gid:    gid,
+		Muid:   uid,
+	}, nil
+}
@@ -0,0 +1,182 @@
+package snapshotfs
+
+import (
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/client"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func test9PClient(t *testing.T) (*client.Fsys, []byte) {
+	fsys, content := testFS(t)
+
+	server_conn, client_conn := net.Pipe()
+	go fsys.Serve9PConn(server_conn)
+
+	conn, err := client.NewConn(client_conn)
+	if err != nil {
+		t.Fatalf("Could not negotiate version: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	c, err := conn.Attach(nil, "nobody", "")
+	if err != nil {
+		t.Fatalf("Could not attach: %s", err)
+	}
+	return c, content
+}
+
+func dirNames(t *testing.T, c *client.Fsys, name string) []string {
+	fid, err := c.Open(name, plan9.OREAD)
+	if err != nil {
+		t.Fatalf("Could not open %s: %s", name, err)
+	}
+	defer fid.Close()
+
+	dirs, err := fid.Dirreadall()
+	if err != nil {
+		t.Fatalf("Could not read directory %s: %s", name, err)
+	}
+
+	names := []string{}
+	for _, d := range dirs {
+		names = append(names, d.Name)
+	}
+	return names
+}
+
+func Test9PBrowse(t *testing.T) {
+	c, _ := test9PClient(t)
+
+	wantNames(t, dirNames(t, c, "/"), "etc", "home")
+	wantNames(t, dirNames(t, c, "/etc"), "2018-01-02T04:04:05Z")
+	wantNames(t, dirNames(t, c, "/etc/2018-01-02T04:04:05Z"), "file", "link", "sub")
+	wantNames(t, dirNames(t, c, "/etc/2018-01-02T04:04:05Z/sub/../sub"), "sparse")
+
+	d, err := c.Stat("/etc/2018-01-02T04:04:05Z/link")
+	if err != nil {
+		t.Fatalf("Could not stat link: %s", err)
+	}
+	if d.Mode&plan9.DMSYMLINK == 0 || d.Qid.Type != plan9.QTSYMLINK || d.Length != 4 {
+		t.Errorf("Unexpected stat of link: %s", d)
+	}
+
+	d, err = c.Stat("/etc/2018-01-02T04:04:05Z/sub")
+	if err != nil {
+		t.Fatalf("Could not stat dir: %s", err)
+	}
+	if d.Mode&plan9.DMDIR == 0 || d.Qid.Type != plan9.QTDIR || d.Name != "sub" {
+		t.Errorf("Unexpected stat of dir: %s", d)
+	}
+
+	if _, err := c.Stat("/etc/nonexistent"); err == nil {
+		t.Errorf("Stat of nonexistent file succeeded")
+	}
+}
+
+func Test9PRead(t *testing.T) {
+	c, content := test9PClient(t)
+
+	fid, err := c.Open("/home/2018-01-02T04:04:05Z/file", plan9.OREAD)
+	if err != nil {
+		t.Fatalf("Could not open file: %s", err)
+	}
+	have, err := io.ReadAll(fid)
+	fid.Close()
+	if err != nil || !bytes.Equal(have, content) {
+		t.Errorf("Unexpected content %q (err=%v)", have, err)
+	}
+
+	fid, err = c.Open("/home/2018-01-02T04:04:05Z/sub/sparse", plan9.OREAD)
+	if err != nil {
+		t.Fatalf("Could not open file: %s", err)
+	}
+	buf := make([]byte, 3)
+	n, err := fid.ReadAt(buf, 4)
+	fid.Close()
+	if err != nil || !bytes.Equal(buf[:n], []byte{0, 'x', 'y'}) {
+		t.Errorf("Unexpected content of sparse file %q (err=%v)", buf[:n], err)
+	}
+}
+
+func Test9PReadOnly(t *testing.T) {
+	c, _ := test9PClient(t)
+
+	if _, err := c.Open("/home/2018-01-02T04:04:05Z/file", plan9.OWRITE); err == nil {
+		t.Errorf("Opening a file for writing succeeded")
+	}
+	if _, err := c.Create("/home/2018-01-02T04:04:05Z/new", plan9.OWRITE, 0644); err == nil {
+		t.Errorf("Creating a file succeeded")
+	}
+	if err := c.Remove("/home/2018-01-02T04:04:05Z/file"); err == nil {
+		t.Errorf("Removing a file succeeded")
+	}
+}
+
+func Test9PTinyMsize(t *testing.T) {
+	fsys, _ := testFS(t)
+
+	server_conn, client_conn := net.Pipe()
+	go fsys.Serve9PConn(server_conn)
+	defer client_conn.Close()
+
+	rpc := func(req *plan9.Fcall) *plan9.Fcall {
+		if err := plan9.WriteFcall(client_conn, req); err != nil {
+			t.Fatalf("Could not send %s: %s", req, err)
+		}
+		resp, err := plan9.ReadFcall(client_conn)
+		if err != nil {
+			t.Fatalf("Could not read response to %s: %s", req, err)
+		}
+		return resp
+	}
+
+	resp := rpc(&plan9.Fcall{Type: plan9.Tversion, Tag: plan9.NOTAG, Msize: 16, Version: plan9.VERSION9P})
+	if resp.Type != plan9.Rerror {
+		t.Errorf("Tiny msize was accepted: %s", resp)
+	}
+
+	resp = rpc(&plan9.Fcall{Type: plan9.Tattach, Tag: 1, Fid: 0, Afid: plan9.NOFID, Uname: "nobody"})
+	if resp.Type != plan9.Rerror {
+		t.Errorf("Attach without negotiated version succeeded: %s", resp)
+	}
+
+	resp = rpc(&plan9.Fcall{Type: plan9.Tversion, Tag: plan9.NOTAG, Msize: 8192, Version: plan9.VERSION9P})
+	if resp.Type != plan9.Rversion || resp.Msize != 8192 {
+		t.Errorf("Unexpected response to valid version: %s", resp)
+	}
+}
+
+func Test9PHugeMessage(t *testing.T) {
+	fsys, _ := testFS(t)
+
+	for _, msize := range []uint32{0, 8192} {
+		server_conn, client_conn := net.Pipe()
+		go fsys.Serve9PConn(server_conn)
+
+		if msize != 0 {
+			err := plan9.WriteFcall(client_conn, &plan9.Fcall{Type: plan9.Tversion, Tag: plan9.NOTAG, Msize: msize, Version: plan9.VERSION9P})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp, err := plan9.ReadFcall(client_conn); err != nil || resp.Type != plan9.Rversion {
+				t.Fatalf("Could not negotiate version: %v (err=%v)", resp, err)
+			}
+		}
+
+		// Only the size header is sent, the server must close the connection instead of waiting for the rest
+		size := []byte{0xff, 0xff, 0xff, 0x7f}
+		if msize != 0 {
+			size = []byte{0x01, 0x20, 0x00, 0x00} // msize + 1
+		}
+		if _, err := client_conn.Write(size); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client_conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("msize %d: Expected connection to be closed, got %v", msize, err)
+		}
+		client_conn.Close()
+	}
+}
@@ -0,0 +1,471 @@
+// Package snapshotfs provides a read-only filesystem view of the snapshots in a storage.
+// It is used to mount snapshots via FUSE and to serve them via 9P.
+//
+// The root directory contains a directory for every archive ("/" in archive names is encoded as "%2F", see
+// archiveDirName). An archive directory contains a directory for every snapshot of the archive, named by the date of
+// the snapshot (RFC 3339). A snapshot directory contains the backed up tree:
+//
+//    /home/2018-01-02T03:04:05+01:00/...
+package snapshotfs
+
+import (
+	"code.laria.me/petrific/logging"
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultObjectCacheSize = 1024             // Number of decoded trees and file objects to cache
+	DefaultBlobCacheSize   = 64 * 1024 * 1024 // Bytes of blobs to cache
+
+	// How long the list of snapshots is cached. Listing snapshots can be expensive (e.g. a request to a cloud storage),
+	// but new snapshots should show up eventually.
+	snapshotListValid = 10 * time.Second
+)
+
+var ErrNotRegularFile = errors.New("not a regular file")
+
+type FS struct {
+	store storage.Storage
+	log   *logging.Log
+
+	// If set, snapshots for which Verify returns an error are hidden (e.g. because their signature is not trusted)
+	Verify func(objects.Snapshot) error
+
+	objects *lruCache // Trees and file indices
+	blobs   *lruCache
+
+	mu        sync.Mutex
+	snapshots map[string]*objects.Snapshot // Snapshots never change, so they are cached forever
+	verified  map[string]bool              // Snapshot ID => accepted by Verify, also cached forever
+
+	archives  map[string]map[string]*objects.Snapshot // Cached result of listSnapshots
+	listed_at time.Time
+}
+
+func New(store storage.Storage, log *logging.Log) *FS {
+	return &FS{
+		store:     store,
+		log:       log,
+		objects:   newLRUCache(DefaultObjectCacheSize),
+		blobs:     newLRUCache(DefaultBlobCacheSize),
+		snapshots: make(map[string]*objects.Snapshot),
+		verified:  make(map[string]bool),
+	}
+}
+
+type nodeKind int
+
+const (
+	kindRoot nodeKind = iota
+	kindArchive
+	kindEntry
+)
+
+// Node is a file or directory in the filesystem
+type Node struct {
+	fsys    *FS
+	kind    nodeKind
+	name    string
+	archive string            // Directory name of the archive, only kindArchive
+	entry   objects.TreeEntry // Only kindEntry
+	mtime   time.Time         // Used, if the entry has no mtime
+}
+
+func (fsys *FS) Root() *Node {
+	return &Node{fsys: fsys, kind: kindRoot, mtime: time.Now()}
+}
+
+func (fsys *FS) snapshot(id objects.ObjectId) (*objects.Snapshot, error) {
+	fsys.mu.Lock()
+	snapshot, ok := fsys.snapshots[id.String()]
+	fsys.mu.Unlock()
+	if ok {
+		return snapshot, nil
+	}
+
+	obj, err := storage.GetObjectOfType(fsys.store, id, objects.OTSnapshot)
+	if err != nil {
+		return nil, err
+	}
+	snapshot = obj.(*objects.Snapshot)
+
+	fsys.mu.Lock()
+	fsys.snapshots[id.String()] = snapshot
+	fsys.mu.Unlock()
+	return snapshot, nil
+}
+
+// trusted checks a snapshot with Verify. Rejected snapshots are only logged once.
+func (fsys *FS) trusted(id objects.ObjectId, snapshot *objects.Snapshot) bool {
+	if fsys.Verify == nil {
+		return true
+	}
+
+	fsys.mu.Lock()
+	accepted, checked := fsys.verified[id.String()]
+	fsys.mu.Unlock()
+	if checked {
+		return accepted
+	}
+
+	err := fsys.Verify(*snapshot)
+	if err != nil {
+		fsys.log.Warn().Printf("hiding snapshot %s: verification failed: %s", id, err)
+	}
+
+	fsys.mu.Lock()
+	fsys.verified[id.String()] = err == nil
+	fsys.mu.Unlock()
+	return err == nil
+}
+
+// listSnapshots returns the snapshots of all archives, by archive directory name and name.
+// The result is cached for snapshotListValid and must not be modified.
+func (fsys *FS) listSnapshots() (map[string]map[string]*objects.Snapshot, error) {
+	fsys.mu.Lock()
+	archives, listed_at := fsys.archives, fsys.listed_at
+	fsys.mu.Unlock()
+	if archives != nil && time.Since(listed_at) < snapshotListValid {
+		return archives, nil
+	}
+
+	archives, err := fsys.readSnapshotList()
+	if err != nil {
+		return nil, err
+	}
+
+	fsys.mu.Lock()
+	fsys.archives, fsys.listed_at = archives, time.Now()
+	fsys.mu.Unlock()
+	return archives, nil
+}
+
+// archiveDirName turns an archive name into a valid directory name. Archive names are free-form text, so "%", "/" and
+// NUL are percent-encoded, as are the dots of "." and "..". The empty name becomes "%", which no other name can become.
+func archiveDirName(archive string) string {
+	switch archive {
+	case "":
+		return "%"
+	case ".", "..":
+		return strings.Replace(archive, ".", "%2E", -1)
+	}
+	return strings.NewReplacer("%", "%25", "/", "%2F", "\x00", "%00").Replace(archive)
+}
+
+// readSnapshotList reads the snapshots of all archives from the storage, by archive directory name and name
+func (fsys *FS) readSnapshotList() (map[string]map[string]*objects.Snapshot, error) {
+	ids, err := fsys.store.List(objects.OTSnapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sorted, so names of snapshots with the same date are stable
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+
+	archives := make(map[string]map[string]*objects.Snapshot)
+	for _, id := range ids {
+		snapshot, err := fsys.snapshot(id)
+		if err != nil {
+			fsys.log.Warn().Printf("could not get snapshot %s: %s", id, err)
+			continue
+		}
+		if !fsys.trusted(id, snapshot) {
+			continue
+		}
+
+		snapshots, ok := archives[archiveDirName(snapshot.Archive)]
+		if !ok {
+			snapshots = make(map[string]*objects.Snapshot)
+			archives[archiveDirName(snapshot.Archive)] = snapshots
+		}
+
+		name := snapshot.Date.Format(time.RFC3339)
+		if _, exists := snapshots[name]; exists {
+			name = fmt.Sprintf("%s_%x", name, id.Sum[:4])
+		}
+		snapshots[name] = snapshot
+	}
+
+	return archives, nil
+}
+
+func (fsys *FS) tree(id objects.ObjectId) (objects.Tree, error) {
+	key := "tree:" + id.String()
+	if tree, ok := fsys.objects.get(key); ok {
+		return tree.(objects.Tree), nil
+	}
+
+	obj, err := storage.GetObjectOfType(fsys.store, id, objects.OTTree)
+	if err != nil {
+		return nil, err
+	}
+	tree := obj.(objects.Tree)
+
+	fsys.objects.add(key, tree, 1)
+	return tree, nil
+}
+
+// fileIndex is a file object with the offsets of the fragments
+type fileIndex struct {
+	fragments objects.File
+	offsets   []uint64
+	size      uint64
+}
+
+func (fsys *FS) fileIndex(id objects.ObjectId) (*fileIndex, error) {
+	key := "file:" + id.String()
+	if idx, ok := fsys.objects.get(key); ok {
+		return idx.(*fileIndex), nil
+	}
+
+	obj, err := storage.GetObjectOfType(fsys.store, id, objects.OTFile)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &fileIndex{fragments: *obj.(*objects.File)}
+	idx.offsets = make([]uint64, len(idx.fragments))
+	for i, fragment := range idx.fragments {
+		idx.offsets[i] = idx.size
+		idx.size += fragment.Size
+	}
+
+	fsys.objects.add(key, idx, 1)
+	return idx, nil
+}
+
+func (fsys *FS) blob(id objects.ObjectId) (objects.Blob, error) {
+	key := id.String()
+	if blob, ok := fsys.blobs.get(key); ok {
+		return blob.(objects.Blob), nil
+	}
+
+	obj, err := storage.GetObjectOfType(fsys.store, id, objects.OTBlob)
+	if err != nil {
+		return nil, err
+	}
+	blob := *obj.(*objects.Blob)
+
+	fsys.blobs.add(key, blob, len(blob))
+	return blob, nil
+}
+
+func (n *Node) Name() string {
+	return n.name
+}
+
+// Entry returns the tree entry of the node, nil for the root and archive directories
+func (n *Node) Entry() objects.TreeEntry {
+	return n.entry
+}
+
+func (n *Node) Type() objects.TreeEntryType {
+	if n.kind != kindEntry {
+		return objects.TETDir
+	}
+	return n.entry.Type()
+}
+
+func (n *Node) IsDir() bool {
+	return n.Type() == objects.TETDir
+}
+
+// Mode returns the type and permission bits of the node
+func (n *Node) Mode() os.FileMode {
+	if n.kind != kindEntry {
+		return os.ModeDir | 0555
+	}
+
+	perm := n.entry.Metadata().Mode
+	if perm == 0 {
+		perm = n.entry.ACL().ToUnixPerms()
+	}
+
+	switch n.entry.Type() {
+	case objects.TETDir:
+		perm |= os.ModeDir
+	case objects.TETSymlink:
+		perm |= os.ModeSymlink
+	case objects.TETBlockDev:
+		perm |= os.ModeDevice
+	case objects.TETCharDev:
+		perm |= os.ModeDevice | os.ModeCharDevice
+	case objects.TETFifo:
+		perm |= os.ModeNamedPipe
+	case objects.TETSocket:
+		perm |= os.ModeSocket
+	}
+	return perm
+}
+
+// ModTime returns the recorded modification time or the date of the snapshot, if there is none
+func (n *Node) ModTime() time.Time {
+	if n.kind == kindEntry {
+		if mtime := n.entry.Metadata().Mtime; !mtime.IsZero() {
+			return mtime
+		}
+	}
+	return n.mtime
+}
+
+func (n *Node) Size() (int64, error) {
+	switch e := n.entry.(type) {
+	case objects.TreeEntryFile:
+		idx, err := n.fsys.fileIndex(e.Ref)
+		if err != nil {
+			return 0, err
+		}
+		return int64(idx.size), nil
+	case objects.TreeEntrySymlink:
+		return int64(len(e.Target)), nil
+	default:
+		return 0, nil
+	}
+}
+
+func (n *Node) Readlink() (string, error) {
+	symlink, ok := n.entry.(objects.TreeEntrySymlink)
+	if !ok {
+		return "", errors.New("not a symlink")
+	}
+	return symlink.Target, nil
+}
+
+func (n *Node) child(name string, entry objects.TreeEntry, mtime time.Time) *Node {
+	return &Node{fsys: n.fsys, kind: kindEntry, name: name, entry: entry, mtime: mtime}
+}
+
+// Children returns the children of a directory, sorted by name
+func (n *Node) Children() ([]*Node, error) {
+	children := []*Node{}
+
+	switch n.kind {
+	case kindRoot:
+		archives, err := n.fsys.listSnapshots()
+		if err != nil {
+			return nil, err
+		}
+		for archive := range archives {
+			children = append(children, &Node{fsys: n.fsys, kind: kindArchive, name: archive, archive: archive, mtime: n.mtime})
+		}
+	case kindArchive:
+		archives, err := n.fsys.listSnapshots()
+		if err != nil {
+			return nil, err
+		}
+		for name, snapshot := range archives[n.archive] {
+			children = append(children, n.child(name, objects.NewTreeEntryDir(snapshot.Tree, true), snapshot.Date))
+		}
+	default:
+		dir, ok := n.entry.(objects.TreeEntryDir)
+		if !ok {
+			return nil, errors.New("not a directory")
+		}
+
+		tree, err := n.fsys.tree(dir.Ref)
+		if err != nil {
+			return nil, err
+		}
+		for name, entry := range tree {
+			children = append(children, n.child(name, entry, n.mtime))
+		}
+	}
+
+	sort.Slice(children, func(i, j int) bool { return children[i].name < children[j].name })
+	return children, nil
+}
+
+// Lookup finds the child name of a directory. It returns os.ErrNotExist, if there is no such child.
+func (n *Node) Lookup(name string) (*Node, error) {
+	if dir, ok := n.entry.(objects.TreeEntryDir); ok {
+		// Faster than building all children
+		tree, err := n.fsys.tree(dir.Ref)
+		if err != nil {
+			return nil, err
+		}
+
+		entry, ok := tree[name]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return n.child(name, entry, n.mtime), nil
+	}
+
+	children, err := n.Children()
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range children {
+		if child.name == name {
+			return child, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// ReadAt reads the content of a regular file. Only the needed fragments are loaded.
+func (n *Node) ReadAt(p []byte, off int64) (int, error) {
+	file, ok := n.entry.(objects.TreeEntryFile)
+	if !ok {
+		return 0, ErrNotRegularFile
+	}
+
+	idx, err := n.fsys.fileIndex(file.Ref)
+	if err != nil {
+		return 0, err
+	}
+
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if uint64(off) >= idx.size {
+		return 0, io.EOF
+	}
+
+	// First fragment containing off
+	i := sort.Search(len(idx.fragments), func(i int) bool {
+		return idx.offsets[i]+idx.fragments[i].Size > uint64(off)
+	})
+
+	read := 0
+	for ; i < len(idx.fragments) && read < len(p); i++ {
+		fragment := idx.fragments[i]
+		start := uint64(off) + uint64(read) - idx.offsets[i]
+
+		if fragment.Hole {
+			length := fragment.Size - start
+			if rest := uint64(len(p) - read); length > rest {
+				length = rest
+			}
+			for j := range p[read : read+int(length)] {
+				p[read+j] = 0
+			}
+			read += int(length)
+			continue
+		}
+
+		blob, err := n.fsys.blob(fragment.Blob)
+		if err != nil {
+			return read, err
+		}
+		if uint64(len(blob)) != fragment.Size {
+			return read, fmt.Errorf("blob size of %s doesn't match size in fragment %d of file %s", fragment.Blob, i, file.Ref)
+		}
+
+		read += copy(p[read:], blob[start:])
+	}
+
+	if read < len(p) {
+		return read, io.EOF
+	}
+	return read, nil
+}
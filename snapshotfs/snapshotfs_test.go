@@ -0,0 +1,218 @@
+package snapshotfs
+
+import (
+	"bytes"
+	"code.laria.me/petrific/backup"
+	"code.laria.me/petrific/logging"
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"code.laria.me/petrific/storage/memory"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setObject(t *testing.T, s storage.Storage, o objects.Object) objects.ObjectId {
+	id, err := storage.SetObject(s, objects.ToRawObject(o))
+	if err != nil {
+		t.Fatalf("Could not store object: %s", err)
+	}
+	return id
+}
+
+func testFS(t *testing.T) (*FS, []byte) {
+	s := memory.NewMemoryStorage()
+
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	file_id, err := backup.WriteFileChunked(s, bytes.NewReader(content), backup.FixedChunking(10))
+	if err != nil {
+		t.Fatalf("Could not write file: %s", err)
+	}
+
+	sparse_id := setObject(t, s, &objects.File{
+		objects.FileFragment{Hole: true, Size: 5},
+		objects.FileFragment{Blob: setObject(t, s, &objects.Blob{'x', 'y'}), Size: 2},
+		objects.FileFragment{Hole: true, Size: 3},
+	})
+
+	subtree_id := setObject(t, s, objects.Tree{"sparse": objects.NewTreeEntryFile(sparse_id, false)})
+	tree_id := setObject(t, s, objects.Tree{
+		"file": objects.NewTreeEntryFile(file_id, true),
+		"link": objects.NewTreeEntrySymlink("file", false),
+		"sub":  objects.NewTreeEntryDir(subtree_id, true),
+	})
+
+	date := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, archive := range []string{"home", "home", "home", "etc"} {
+		setObject(t, s, &objects.Snapshot{
+			Tree:    tree_id,
+			Date:    date.Add(time.Duration(i/2) * time.Hour),
+			Archive: archive,
+			Comment: string(rune('a' + i)), // Different snapshot ids
+		})
+	}
+
+	return New(s, logging.NewNopLog()), content
+}
+
+func childNames(t *testing.T, n *Node) []string {
+	children, err := n.Children()
+	if err != nil {
+		t.Fatalf("Could not get children of %s: %s", n.Name(), err)
+	}
+
+	names := []string{}
+	for _, c := range children {
+		names = append(names, c.Name())
+	}
+	return names
+}
+
+func lookupPath(t *testing.T, n *Node, names ...string) *Node {
+	for _, name := range names {
+		var err error
+		if n, err = n.Lookup(name); err != nil {
+			t.Fatalf("Could not lookup %s: %s", name, err)
+		}
+	}
+	return n
+}
+
+func wantNames(t *testing.T, have []string, want ...string) {
+	if len(have) != len(want) {
+		t.Errorf("Unexpected names %v, want %v", have, want)
+		return
+	}
+	for i := range have {
+		if have[i] != want[i] {
+			t.Errorf("Unexpected names %v, want %v", have, want)
+			return
+		}
+	}
+}
+
+func TestBrowse(t *testing.T) {
+	fsys, _ := testFS(t)
+	root := fsys.Root()
+
+	wantNames(t, childNames(t, root), "etc", "home")
+
+	home_snapshots := childNames(t, lookupPath(t, root, "home"))
+	// Two snapshots with the same date
+	if len(home_snapshots) != 3 || home_snapshots[0] != "2018-01-02T03:04:05Z" ||
+		!strings.HasPrefix(home_snapshots[1], "2018-01-02T03:04:05Z_") || home_snapshots[2] != "2018-01-02T04:04:05Z" {
+		t.Errorf("Unexpected snapshots of home: %v", home_snapshots)
+	}
+	wantNames(t, childNames(t, lookupPath(t, root, "etc")), "2018-01-02T04:04:05Z")
+
+	snapshot := lookupPath(t, root, "etc", "2018-01-02T04:04:05Z")
+	wantNames(t, childNames(t, snapshot), "file", "link", "sub")
+
+	if mtime := snapshot.ModTime(); !mtime.Equal(time.Date(2018, 1, 2, 4, 4, 5, 0, time.UTC)) {
+		t.Errorf("Unexpected mtime of snapshot: %s", mtime)
+	}
+
+	link := lookupPath(t, snapshot, "link")
+	if target, err := link.Readlink(); err != nil || target != "file" {
+		t.Errorf("Unexpected link target %s (err=%v)", target, err)
+	}
+	if link.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Unexpected mode of link: %s", link.Mode())
+	}
+
+	if _, err := snapshot.Lookup("nonexistent"); !os.IsNotExist(err) {
+		t.Errorf("Unexpected error for nonexistent file: %v", err)
+	}
+}
+
+func TestHideRejectedSnapshots(t *testing.T) {
+	fsys, _ := testFS(t)
+
+	calls := 0
+	fsys.Verify = func(s objects.Snapshot) error {
+		calls++
+		if s.Comment == "c" || s.Comment == "d" {
+			return errors.New("untrusted")
+		}
+		return nil
+	}
+	root := fsys.Root()
+
+	wantNames(t, childNames(t, root), "home")
+	if home_snapshots := childNames(t, lookupPath(t, root, "home")); len(home_snapshots) != 2 {
+		t.Errorf("Unexpected snapshots of home: %v", home_snapshots)
+	}
+	if _, err := lookupPath(t, root, "home").Lookup("2018-01-02T04:04:05Z"); !os.IsNotExist(err) {
+		t.Errorf("Unexpected error for rejected snapshot: %v", err)
+	}
+	if _, err := root.Lookup("etc"); !os.IsNotExist(err) {
+		t.Errorf("Unexpected error for archive with only rejected snapshots: %v", err)
+	}
+
+	if calls != 4 {
+		t.Errorf("Expected every snapshot to be verified once, got %d calls", calls)
+	}
+}
+
+func TestSnapshotListCache(t *testing.T) {
+	fsys, _ := testFS(t)
+	root := fsys.Root()
+	wantNames(t, childNames(t, root), "etc", "home")
+
+	for _, archive := range []string{"new", "a/b", "..", "50%"} {
+		setObject(t, fsys.store, &objects.Snapshot{
+			Tree:    objects.MustParseObjectId("sha3-256:0000000000000000000000000000000000000000000000000000000000000000"),
+			Date:    time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC),
+			Archive: archive,
+		})
+	}
+
+	// The list of snapshots is cached for a while
+	wantNames(t, childNames(t, root), "etc", "home")
+
+	fsys.mu.Lock()
+	fsys.listed_at = time.Now().Add(-snapshotListValid)
+	fsys.mu.Unlock()
+
+	wantNames(t, childNames(t, root), "%2E%2E", "50%25", "a%2Fb", "etc", "home", "new")
+	wantNames(t, childNames(t, lookupPath(t, root, "a%2Fb")), "2018-01-02T03:04:05Z")
+}
+
+func TestReadAt(t *testing.T) {
+	fsys, content := testFS(t)
+	snapshot := lookupPath(t, fsys.Root(), "etc", "2018-01-02T04:04:05Z")
+	file := lookupPath(t, snapshot, "file")
+
+	if size, err := file.Size(); err != nil || size != int64(len(content)) {
+		t.Errorf("Unexpected size %d (err=%v)", size, err)
+	}
+	if file.Mode() != 0755 {
+		t.Errorf("Unexpected mode %s", file.Mode())
+	}
+
+	// Across fragment boundaries
+	buf := make([]byte, 15)
+	n, err := file.ReadAt(buf, 8)
+	if err != nil || !bytes.Equal(buf[:n], content[8:23]) {
+		t.Errorf("Unexpected ReadAt result %q (err=%v)", buf[:n], err)
+	}
+
+	// Beyond the end
+	n, err = file.ReadAt(buf, 30)
+	if err != io.EOF || !bytes.Equal(buf[:n], content[30:]) {
+		t.Errorf("Unexpected ReadAt result at end %q (err=%v)", buf[:n], err)
+	}
+
+	sparse := lookupPath(t, snapshot, "sub", "sparse")
+	buf = make([]byte, 20)
+	for i := range buf {
+		buf[i] = 0xff
+	}
+	n, err = sparse.ReadAt(buf, 1)
+	if err != io.EOF || !bytes.Equal(buf[:n], []byte{0, 0, 0, 0, 'x', 'y', 0, 0, 0}) {
+		t.Errorf("Unexpected ReadAt result of sparse file %q (err=%v)", buf[:n], err)
+	}
+}
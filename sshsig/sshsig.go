@@ -0,0 +1,285 @@
+// Package sshsig signs and verifies snapshots with Ed25519 SSH keys. It uses the signature format of
+// `ssh-keygen -Y sign` (see PROTOCOL.sshsig in the OpenSSH sources), so existing OpenSSH keys can be used.
+//
+// A signed snapshot is the snapshot followed by the armored signature. The signature can also be checked by ssh-keygen
+// after splitting it off into its own file:
+//
+//    ssh-keygen -Y check-novalidate -n petrific-snapshot -s snapshot.sig < snapshot
+package sshsig
+
+import (
+	"bytes"
+	"code.laria.me/petrific/objects"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"hash"
+	"io/ioutil"
+)
+
+// Namespace of snapshot signatures, prevents that signatures made for other purposes are accepted
+const Namespace = "petrific-snapshot"
+
+const (
+	armorStart = "-----BEGIN SSH SIGNATURE-----"
+	armorEnd   = "-----END SSH SIGNATURE-----"
+
+	magic         = "SSHSIG"
+	version       = 1
+	hashAlgorithm = "sha512"
+	lineLength    = 70 // Same as ssh-keygen
+)
+
+var (
+	ErrNoSignature    = errors.New("no SSH signature found")
+	ErrNotEd25519     = errors.New("only ed25519 keys are supported")
+	ErrUntrustedKey   = errors.New("signature by unknown key")
+	ErrWrongNamespace = errors.New("signature was not made for snapshots")
+)
+
+// signatureBlob is the content of an armored signature, after the magic preamble
+type signatureBlob struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+// signedData is the data that actually gets signed, after the magic preamble
+type signedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+func dataToSign(namespace, hash_algorithm string, message []byte) ([]byte, error) {
+	var h hash.Hash
+	switch hash_algorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm %s", hash_algorithm)
+	}
+	h.Write(message)
+
+	data := ssh.Marshal(signedData{Namespace: namespace, HashAlgorithm: hash_algorithm, Hash: h.Sum(nil)})
+	return append([]byte(magic), data...), nil
+}
+
+// Sign creates an armored signature of message
+func Sign(signer ssh.Signer, namespace string, message []byte) ([]byte, error) {
+	data, err := dataToSign(namespace, hashAlgorithm, message)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := signer.Sign(rand.Reader, data)
+	if err != nil {
+		return nil, err
+	}
+
+	blob := append([]byte(magic), ssh.Marshal(signatureBlob{
+		Version:       version,
+		PublicKey:     signer.PublicKey().Marshal(),
+		Namespace:     namespace,
+		HashAlgorithm: hashAlgorithm,
+		Signature:     ssh.Marshal(sig),
+	})...)
+
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	out := []byte(armorStart + "\n")
+	for len(encoded) > lineLength {
+		out = append(out, encoded[:lineLength]+"\n"...)
+		encoded = encoded[lineLength:]
+	}
+	out = append(out, encoded+"\n"+armorEnd+"\n"...)
+	return out, nil
+}
+
+// Verify verifies an armored signature of message and returns the key that made the signature.
+// The caller has to check, if the key is trusted.
+func Verify(armored []byte, namespace string, message []byte) (ssh.PublicKey, error) {
+	armored = bytes.TrimSpace(armored)
+	if !bytes.HasPrefix(armored, []byte(armorStart)) || !bytes.HasSuffix(armored, []byte(armorEnd)) {
+		return nil, ErrNoSignature
+	}
+	encoded := bytes.Join(bytes.Fields(armored[len(armorStart):len(armored)-len(armorEnd)]), nil)
+
+	blob := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
+	n, err := base64.StdEncoding.Decode(blob, encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH signature: %s", err)
+	}
+	blob = blob[:n]
+
+	if !bytes.HasPrefix(blob, []byte(magic)) {
+		return nil, errors.New("invalid SSH signature: wrong magic preamble")
+	}
+	var sig_blob signatureBlob
+	if err := ssh.Unmarshal(blob[len(magic):], &sig_blob); err != nil {
+		return nil, fmt.Errorf("invalid SSH signature: %s", err)
+	}
+	if sig_blob.Version != version {
+		return nil, fmt.Errorf("unsupported SSH signature version %d", sig_blob.Version)
+	}
+	if sig_blob.Namespace != namespace {
+		return nil, ErrWrongNamespace
+	}
+
+	key, err := ssh.ParsePublicKey(sig_blob.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if key.Type() != ssh.KeyAlgoED25519 {
+		return nil, ErrNotEd25519
+	}
+
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(sig_blob.Signature, &sig); err != nil {
+		return nil, fmt.Errorf("invalid SSH signature: %s", err)
+	}
+
+	data, err := dataToSign(namespace, sig_blob.HashAlgorithm, message)
+	if err != nil {
+		return nil, err
+	}
+	if err := key.Verify(data, &sig); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Signer implements objects.Signer using an ed25519 SSH key
+type Signer struct {
+	signer ssh.Signer
+}
+
+// NewSigner reads the private key from the file at path (e.g. ~/.ssh/id_ed25519).
+// The passphrase is only needed, if the key is encrypted.
+func NewSigner(path string, passphrase []byte) (Signer, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Signer{}, err
+	}
+
+	var signer ssh.Signer
+	if passphrase == nil {
+		signer, err = ssh.ParsePrivateKey(pem)
+	} else {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, passphrase)
+	}
+	if err != nil {
+		return Signer{}, fmt.Errorf("could not read SSH key %s: %s", path, err)
+	}
+
+	if signer.PublicKey().Type() != ssh.KeyAlgoED25519 {
+		return Signer{}, ErrNotEd25519
+	}
+	return Signer{signer}, nil
+}
+
+// Sign appends an armored signature to b
+func (s Signer) Sign(b []byte) ([]byte, error) {
+	sig, err := Sign(s.signer, Namespace, b)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, b...), sig...), nil
+}
+
+type trustedKey struct {
+	key     ssh.PublicKey
+	comment string
+}
+
+// Verifyer implements objects.Verifyer for snapshots signed by Signer. It only accepts signatures by its keys.
+type Verifyer struct {
+	keys map[string]trustedKey // By fingerprint
+}
+
+// readPublicKeys reads the public keys from an authorized_keys style file (like ~/.ssh/id_ed25519.pub) or
+// gets the public key of an OpenSSH private key file (without needing the passphrase).
+func readPublicKeys(path string) ([]trustedKey, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Contains(content, []byte("PRIVATE KEY-----")) {
+		signer, err := ssh.ParsePrivateKey(content)
+		if missing, ok := err.(*ssh.PassphraseMissingError); ok && missing.PublicKey != nil {
+			return []trustedKey{{key: missing.PublicKey}}, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("could not read SSH key %s: %s", path, err)
+		}
+		return []trustedKey{{key: signer.PublicKey()}}, nil
+	}
+
+	keys := []trustedKey{}
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		key, comment, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("could not read SSH public key %s: %s", path, err)
+		}
+		keys = append(keys, trustedKey{key, comment})
+	}
+	return keys, nil
+}
+
+// NewVerifyer reads the trusted keys from the files at paths, see readPublicKeys for the supported formats.
+func NewVerifyer(paths ...string) (Verifyer, error) {
+	v := Verifyer{make(map[string]trustedKey)}
+	for _, path := range paths {
+		keys, err := readPublicKeys(path)
+		if err != nil {
+			return Verifyer{}, err
+		}
+
+		for _, k := range keys {
+			if k.key.Type() != ssh.KeyAlgoED25519 {
+				return Verifyer{}, fmt.Errorf("%s: %s", path, ErrNotEd25519)
+			}
+			v.keys[ssh.FingerprintSHA256(k.key)] = k
+		}
+	}
+	return v, nil
+}
+
+// Verify verifies a message with an appended armored signature
+func (v Verifyer) Verify(b []byte) (objects.SignatureInfo, error) {
+	i := bytes.LastIndex(b, []byte(armorStart))
+	if i < 0 || (i > 0 && b[i-1] != '\n') {
+		return objects.SignatureInfo{}, ErrNoSignature
+	}
+
+	key, err := Verify(b[i:], Namespace, b[:i])
+	if err != nil {
+		return objects.SignatureInfo{}, err
+	}
+
+	fingerprint := ssh.FingerprintSHA256(key)
+	trusted, ok := v.keys[fingerprint]
+	if !ok {
+		return objects.SignatureInfo{}, fmt.Errorf("%s %s", ErrUntrustedKey, fingerprint)
+	}
+
+	return objects.SignatureInfo{
+		Signer:      trusted.comment,
+		KeyId:       fingerprint,
+		Fingerprint: fingerprint,
+	}, nil
+}
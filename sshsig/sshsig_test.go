@@ -0,0 +1,214 @@
+package sshsig
+
+import (
+	"bytes"
+	"code.laria.me/petrific/objects"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/pem"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	testSnapshotPayload = []byte("" +
+		"== BEGIN SNAPSHOT ==\n" +
+		"archive foo\n" +
+		"date 2017-07-01T21:40:00+02:00\n" +
+		"signed yes\n" +
+		"tree sha3-256:ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff\n" +
+		"\n" +
+		"foo\n" +
+		"bar\n" +
+		"baz!\n" +
+		"== END SNAPSHOT ==\n")
+
+	// testSnapshotPayload signed with `ssh-keygen -Y sign -n petrific-snapshot` by testPublicKey
+	testSignature = []byte(`-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAADMAAAALc3NoLWVkMjU1MTkAAAAgzKEQKinyOiitdLWEQVIA7E0ycC
+fDTka03cNTQE9xUxcAAAARcGV0cmlmaWMtc25hcHNob3QAAAAAAAAABnNoYTUxMgAAAFMA
+AAALc3NoLWVkMjU1MTkAAABADYv2gRzgZ2FDi2RMriRCo12+xL79NeJ56N1bs72yzhcbUi
+/AiQRMPM19UOGru3NrY4E3g+k9WhbhldudIqqTCA==
+-----END SSH SIGNATURE-----
+`)
+
+	testPublicKey   = []byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMyhECop8joorXS1hEFSAOxNMnAnw05GtN3DU0BPcVMX petrific-test\n")
+	testFingerprint = "SHA256:EYvB0z9s6io7MhNVnObNf+5GTaxJQhy+rCBjNkb6ckg"
+)
+
+func writeFile(t *testing.T, name string, content []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatalf("Could not write %s: %s", name, err)
+	}
+	return path
+}
+
+// writeSecretKey generates a new ed25519 key and writes it to an OpenSSH private key file. If passphrase is not nil,
+// the key gets encrypted.
+func writeSecretKey(t *testing.T, passphrase []byte) string {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate key: %s", err)
+	}
+
+	var block *pem.Block
+	if passphrase == nil {
+		block, err = ssh.MarshalPrivateKey(key, "generated")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, "generated", passphrase)
+	}
+	if err != nil {
+		t.Fatalf("Could not serialize key: %s", err)
+	}
+
+	return writeFile(t, "id_ed25519", pem.EncodeToMemory(block))
+}
+
+func verifySnapshot(payload []byte, v objects.Verifyer) error {
+	var snapshot objects.Snapshot
+	if err := snapshot.FromPayload(payload); err != nil {
+		return err
+	}
+	_, err := snapshot.Verify(objects.Verifyers{objects.SignatureSSH: v})
+	return err
+}
+
+func TestVerifySSHKeygenSignature(t *testing.T) {
+	key, err := Verify(testSignature, Namespace, testSnapshotPayload)
+	if err != nil {
+		t.Fatalf("Verification of ssh-keygen signature failed: %s", err)
+	}
+	if fp := ssh.FingerprintSHA256(key); fp != testFingerprint {
+		t.Errorf("Unexpected key %s", fp)
+	}
+
+	if _, err := Verify(testSignature, "file", testSnapshotPayload); err != ErrWrongNamespace {
+		t.Errorf("Unexpected error for wrong namespace: %v", err)
+	}
+
+	v, err := NewVerifyer(writeFile(t, "trusted.pub", append([]byte("# comment\n\n"), testPublicKey...)))
+	if err != nil {
+		t.Fatalf("Could not load public key: %s", err)
+	}
+
+	signed := append(append([]byte{}, testSnapshotPayload...), testSignature...)
+	if err := verifySnapshot(signed, v); err != nil {
+		t.Errorf("Verification of signed snapshot failed: %s", err)
+	}
+
+	si, err := v.Verify(signed)
+	if err != nil {
+		t.Fatalf("Verification of signed snapshot failed: %s", err)
+	}
+	if si != (objects.SignatureInfo{Signer: "petrific-test", KeyId: testFingerprint, Fingerprint: testFingerprint}) {
+		t.Errorf("Unexpected signature info %#v", si)
+	}
+
+	subtests := []struct {
+		name    string
+		payload []byte
+	}{
+		{"tampered", bytes.Replace(signed, []byte("archive foo"), []byte("archive bar"), 1)},
+		{"prefixed", append([]byte("== BEGIN SNAPSHOT ==\narchive bar\ndate 2017-07-01T21:40:00+02:00\nsigned yes\ntree sha3-256:0000000000000000000000000000000000000000000000000000000000000000\n== END SNAPSHOT ==\n"), signed...)},
+		{"suffixed", append(append([]byte{}, signed...), "unsigned\n"...)},
+	}
+
+	for _, subtest := range subtests {
+		if err := verifySnapshot(subtest.payload, v); err == nil {
+			t.Errorf("Verification of %s snapshot succeeded", subtest.name)
+		}
+	}
+
+	other, err := NewVerifyer(writeSecretKey(t, nil))
+	if err != nil {
+		t.Fatalf("Could not load key: %s", err)
+	}
+	if _, err := other.Verify(signed); err == nil {
+		t.Errorf("Verification with untrusted key succeeded")
+	}
+}
+
+func TestSign(t *testing.T) {
+	key_path := writeSecretKey(t, nil)
+
+	signer, err := NewSigner(key_path, nil)
+	if err != nil {
+		t.Fatalf("Could not load signing key: %s", err)
+	}
+	signed, err := signer.Sign(testSnapshotPayload)
+	if err != nil {
+		t.Fatalf("Could not sign: %s", err)
+	}
+	if !bytes.HasPrefix(signed, testSnapshotPayload) {
+		t.Errorf("Signed snapshot does not start with the payload")
+	}
+
+	v, err := NewVerifyer(key_path)
+	if err != nil {
+		t.Fatalf("Could not load key: %s", err)
+	}
+	if err := verifySnapshot(signed, v); err != nil {
+		t.Errorf("Verification failed: %s", err)
+	}
+
+	other, err := NewVerifyer(writeFile(t, "trusted.pub", testPublicKey))
+	if err != nil {
+		t.Fatalf("Could not load public key: %s", err)
+	}
+	if err := verifySnapshot(signed, other); err == nil {
+		t.Errorf("Verification with other key succeeded")
+	}
+}
+
+func TestSignEncryptedKey(t *testing.T) {
+	key_path := writeSecretKey(t, []byte("secret"))
+
+	if _, err := NewSigner(key_path, nil); err == nil {
+		t.Errorf("Loading encrypted key without passphrase succeeded")
+	}
+	if _, err := NewSigner(key_path, []byte("wrong")); err == nil {
+		t.Errorf("Loading encrypted key with wrong passphrase succeeded")
+	}
+
+	signer, err := NewSigner(key_path, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Could not load encrypted key: %s", err)
+	}
+	signed, err := signer.Sign(testSnapshotPayload)
+	if err != nil {
+		t.Fatalf("Could not sign with encrypted key: %s", err)
+	}
+
+	// The public key of an encrypted key file can be read without the passphrase
+	v, err := NewVerifyer(key_path)
+	if err != nil {
+		t.Fatalf("Could not load encrypted key: %s", err)
+	}
+	if err := verifySnapshot(signed, v); err != nil {
+		t.Errorf("Verification failed: %s", err)
+	}
+}
+
+func TestRejectNonEd25519Keys(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate key: %s", err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "ecdsa")
+	if err != nil {
+		t.Fatalf("Could not serialize key: %s", err)
+	}
+	key_path := writeFile(t, "id_ecdsa", pem.EncodeToMemory(block))
+
+	if _, err := NewSigner(key_path, nil); err != ErrNotEd25519 {
+		t.Errorf("Unexpected error for ecdsa signing key: %v", err)
+	}
+	if _, err := NewVerifyer(key_path); err == nil {
+		t.Errorf("Loading ecdsa key for verification succeeded")
+	}
+}
@@ -105,6 +105,36 @@ func (cbos CloudBasedObjectStorage) Set(id objects.ObjectId, typ objects.ObjectT
 	return nil
 }
 
+func (cbos CloudBasedObjectStorage) Delete(id objects.ObjectId) error {
+	key := cbos.objidToKey(id)
+
+	ok, err := cbos.CS.Has(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return storage.ObjectNotFound
+	}
+
+	if err := cbos.CS.Delete(key); err != nil {
+		return err
+	}
+
+	// Also remove the type hint used by restore-index, if there is one
+	typeof_key := cbos.Prefix + "typeof/" + id.String()
+	if ok, err := cbos.CS.Has(typeof_key); err != nil {
+		return err
+	} else if ok {
+		if err := cbos.CS.Delete(typeof_key); err != nil {
+			return err
+		}
+	}
+
+	cbos.index.Delete(id)
+
+	return nil
+}
+
 func (cbos CloudBasedObjectStorage) List(typ objects.ObjectType) ([]objects.ObjectId, error) {
 	return cbos.index.List(typ), nil
 }
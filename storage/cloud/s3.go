@@ -0,0 +1,156 @@
+package cloud
+
+import (
+	"bytes"
+	"code.laria.me/petrific/config"
+	"code.laria.me/petrific/storage"
+	"context"
+	"errors"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"io/ioutil"
+)
+
+type S3CloudStorage struct {
+	client *minio.Client
+	bucket string
+}
+
+type S3Config struct {
+	// Mandatory options
+	Bucket    string `toml:"bucket"`
+	AccessKey string `toml:"access_key"`
+	SecretKey string `toml:"secret_key"`
+
+	// Optional options
+	Endpoint     string `toml:"endpoint,omitempty"` // host[:port], defaults to AWS (s3.amazonaws.com)
+	Region       string `toml:"region,omitempty"`
+	SessionToken string `toml:"session_token,omitempty"`
+	PathStyle    bool   `toml:"path_style,omitempty"` // Use path-style addressing (needed by e.g. MinIO or Ceph RGW without wildcard DNS)
+	Insecure     bool   `toml:"insecure,omitempty"`   // Use plain HTTP instead of HTTPS
+}
+
+// NewS3CloudStorage creates a CloudStorage using a bucket of a S3 compatible object storage
+func NewS3CloudStorage(conf S3Config) (S3CloudStorage, error) {
+	if conf.Bucket == "" {
+		return S3CloudStorage{}, errors.New("bucket must be set")
+	}
+
+	endpoint := conf.Endpoint
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+
+	opts := &minio.Options{
+		Creds:        credentials.NewStaticV4(conf.AccessKey, conf.SecretKey, conf.SessionToken),
+		Secure:       !conf.Insecure,
+		Region:       conf.Region,
+		BucketLookup: minio.BucketLookupAuto,
+	}
+	if conf.PathStyle {
+		opts.BucketLookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(endpoint, opts)
+	if err != nil {
+		return S3CloudStorage{}, err
+	}
+
+	return S3CloudStorage{client: client, bucket: conf.Bucket}, nil
+}
+
+// S3StorageCreator creates an object storage that saves the objects to a S3 compatible object storage
+// (AWS S3, MinIO, Ceph RGW, ...).
+// Use the method "s3" in your config and refer to the `S3Config` structure for additional config keys.
+//
+// Example config:
+//
+//     [storage.s3]
+//     method="s3"
+//     endpoint="minio.example.com:9000"
+//     bucket="backups"
+//     access_key="..."
+//     secret_key="..."
+//     path_style=true
+//     prefix="petrific/" # optional, prefix for all keys
+func S3StorageCreator() storage.CreateStorageFromConfig {
+	return cloudStorageCreator(func(conf config.Config, name string) (CloudStorage, error) {
+		var storage_conf S3Config
+
+		if err := conf.GetStorageConfData(name, &storage_conf); err != nil {
+			return nil, err
+		}
+
+		return NewS3CloudStorage(storage_conf)
+	})
+}
+
+func isS3NotFound(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == "NoSuchKey" || code == "NotFound"
+}
+
+func (s3 S3CloudStorage) Get(key string) ([]byte, error) {
+	obj, err := s3.client.GetObject(context.Background(), s3.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	b, err := ioutil.ReadAll(obj)
+	if isS3NotFound(err) {
+		return nil, NotFoundErr
+	}
+	return b, err
+}
+
+func (s3 S3CloudStorage) Has(key string) (bool, error) {
+	_, err := s3.client.StatObject(context.Background(), s3.bucket, key, minio.StatObjectOptions{})
+	switch {
+	case err == nil:
+		return true, nil
+	case isS3NotFound(err):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (s3 S3CloudStorage) Put(key string, content []byte) error {
+	_, err := s3.client.PutObject(
+		context.Background(),
+		s3.bucket,
+		key,
+		bytes.NewReader(content),
+		int64(len(content)),
+		minio.PutObjectOptions{ContentType: "application/octet-stream"},
+	)
+	return err
+}
+
+func (s3 S3CloudStorage) Delete(key string) error {
+	return s3.client.RemoveObject(context.Background(), s3.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s3 S3CloudStorage) List(prefix string) ([]string, error) {
+	keys := []string{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for info := range s3.client.ListObjects(ctx, s3.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if info.Err != nil {
+			return nil, info.Err
+		}
+		keys = append(keys, info.Key)
+	}
+
+	return keys, nil
+}
+
+func (s3 S3CloudStorage) Close() error {
+	return nil
+}
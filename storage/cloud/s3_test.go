@@ -0,0 +1,132 @@
+package cloud
+
+import (
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+)
+
+func newFakeS3(t *testing.T) (S3CloudStorage, func()) {
+	backend := s3mem.New()
+	if err := backend.CreateBucket("petrific"); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(gofakes3.New(backend).Server())
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s3, err := NewS3CloudStorage(S3Config{
+		Bucket:    "petrific",
+		AccessKey: "access",
+		SecretKey: "secret",
+		Endpoint:  u.Host,
+		Region:    "us-east-1",
+		PathStyle: true,
+		Insecure:  true,
+	})
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+
+	return s3, server.Close
+}
+
+func TestS3CloudStorage(t *testing.T) {
+	s3, stop := newFakeS3(t)
+	defer stop()
+
+	if err := s3.Put("foo/a", []byte("a")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if err := s3.Put("foo/b", []byte("b")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if err := s3.Put("bar", []byte("bar")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+
+	if b, err := s3.Get("foo/a"); err != nil || string(b) != "a" {
+		t.Errorf("Get(foo/a) = %q, %v", b, err)
+	}
+
+	if _, err := s3.Get("nope"); err != NotFoundErr {
+		t.Errorf("Get of missing key returned error %v", err)
+	}
+
+	if ok, err := s3.Has("bar"); err != nil || !ok {
+		t.Errorf("Has(bar) = %t, %v", ok, err)
+	}
+	if ok, err := s3.Has("nope"); err != nil || ok {
+		t.Errorf("Has(nope) = %t, %v", ok, err)
+	}
+
+	keys, err := s3.List("foo/")
+	if err != nil {
+		t.Fatalf("List failed: %s", err)
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "foo/a" || keys[1] != "foo/b" {
+		t.Errorf("Unexpected List result: %v", keys)
+	}
+
+	if err := s3.Delete("bar"); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+	if ok, err := s3.Has("bar"); err != nil || ok {
+		t.Errorf("Has(bar) after delete = %t, %v", ok, err)
+	}
+}
+
+func TestS3ObjectStorage(t *testing.T) {
+	s3, stop := newFakeS3(t)
+	defer stop()
+
+	cbos := CloudBasedObjectStorage{CS: s3, Prefix: "p/"}
+	if err := cbos.Init(); err != nil {
+		t.Fatalf("Init failed: %s", err)
+	}
+
+	blob := objects.Blob("foo")
+	raw := objects.ToRawObject(&blob)
+	id, err := storage.SetObject(cbos, raw)
+	if err != nil {
+		t.Fatalf("SetObject failed: %s", err)
+	}
+
+	if err := cbos.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	// Reopen, the index must have been persisted
+	cbos = CloudBasedObjectStorage{CS: s3, Prefix: "p/"}
+	if err := cbos.Init(); err != nil {
+		t.Fatalf("Init failed: %s", err)
+	}
+
+	ids, err := cbos.List(objects.OTBlob)
+	if err != nil || len(ids) != 1 || !ids[0].Equals(id) {
+		t.Fatalf("Unexpected List result: %v, %v", ids, err)
+	}
+
+	if err := cbos.Delete(id); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+
+	if ok, err := cbos.Has(id); err != nil || ok {
+		t.Errorf("Has after Delete = %t, %v", ok, err)
+	}
+
+	if ids, _ := cbos.List(objects.OTBlob); len(ids) != 0 {
+		t.Errorf("Deleted object still listed: %v", ids)
+	}
+}
@@ -0,0 +1,391 @@
+// Package encrypt provides a storage that encrypts all objects before passing them to another storage
+package encrypt
+
+import (
+	"bytes"
+	"code.laria.me/petrific/config"
+	"code.laria.me/petrific/logging"
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+	"strconv"
+)
+
+const (
+	formatVersion = 1
+
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+var (
+	// The key file and (if types are hidden) the index are stored in the base storage under these fixed IDs
+	keyFileId = fixedId("petrific encrypt key file")
+	indexId   = fixedId("petrific encrypt index")
+
+	WrongPassphrase  = errors.New("Wrong passphrase or corrupted key file")
+	DecryptionFailed = errors.New("Decryption failed (object is corrupted or was encrypted with another key)")
+)
+
+func fixedId(name string) objects.ObjectId {
+	gen := objects.OIdAlgoDefault.Generator()
+	gen.Write([]byte(name))
+	return gen.GetId()
+}
+
+// EncryptStorage is a storage implementation wrapping around another storage, encrypting every object with
+// XChaCha20-Poly1305. The objects are encrypted with a random master key, which is stored in the base storage,
+// encrypted with a key derived from a passphrase (using scrypt). The key file is created when the storage is
+// used for the first time.
+//
+// It is used in a configuration by using the method "encrypt". It needs the config key "base" referencing the name of
+// another configured storage. The passphrase is read from the file named by "passphrase_file" or, if that is not set,
+// from the environment variable PETRIFIC_PASSPHRASE.
+//
+// If "hide_types" is set to true, all objects are stored as blobs in the base storage, the real types are recorded
+// in an encrypted index. This index is stored as a single object, so only one petrific process should write to the
+// storage at the same time. If the index gets lost, it can be rebuilt with the storage subcommand "restore-index".
+//
+// Example:
+//
+//     [storage.local_encrypted]
+//     method="encrypt"
+//     base="local"
+//     passphrase_file="~/.config/petrific/passphrase"
+//     hide_types=true
+type EncryptStorage struct {
+	Base      storage.Storage
+	HideTypes bool
+
+	aead  cipher.AEAD
+	index storage.Index
+}
+
+func deriveKey(passphrase, salt []byte) ([]byte, error) {
+	return scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, chacha20poly1305.KeySize)
+}
+
+func seal(aead cipher.AEAD, plain, ad []byte) ([]byte, error) {
+	out := make([]byte, 1+aead.NonceSize(), 1+aead.NonceSize()+len(plain)+aead.Overhead())
+	out[0] = formatVersion
+	if _, err := rand.Read(out[1:]); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(out, out[1:], plain, ad), nil
+}
+
+func open(aead cipher.AEAD, sealed, ad []byte) ([]byte, error) {
+	if len(sealed) < 1+aead.NonceSize() || sealed[0] != formatVersion {
+		return nil, DecryptionFailed
+	}
+
+	nonce := sealed[1 : 1+aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, sealed[1+aead.NonceSize():], ad)
+	if err != nil {
+		return nil, DecryptionFailed
+	}
+	return plain, nil
+}
+
+// The key file is serialized as objects.Properties
+func createKeyFile(passphrase []byte) (keyfile []byte, master []byte, err error) {
+	salt := make([]byte, 32)
+	master = make([]byte, chacha20poly1305.KeySize)
+	if _, err = rand.Read(salt); err != nil {
+		return
+	}
+	if _, err = rand.Read(master); err != nil {
+		return
+	}
+
+	kek, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return
+	}
+
+	aead, err := chacha20poly1305.NewX(kek)
+	if err != nil {
+		return
+	}
+
+	sealed, err := seal(aead, master, nil)
+	if err != nil {
+		return
+	}
+
+	keyfile, err = objects.Properties{
+		"version": strconv.Itoa(formatVersion),
+		"kdf":     "scrypt",
+		"n":       strconv.Itoa(scryptN),
+		"r":       strconv.Itoa(scryptR),
+		"p":       strconv.Itoa(scryptP),
+		"salt":    hex.EncodeToString(salt),
+		"key":     hex.EncodeToString(sealed),
+	}.MarshalText()
+	return
+}
+
+func loadKeyFile(keyfile, passphrase []byte) ([]byte, error) {
+	props := make(objects.Properties)
+	if err := props.UnmarshalText(bytes.TrimSpace(keyfile)); err != nil {
+		return nil, fmt.Errorf("invalid key file: %s", err)
+	}
+
+	if props["version"] != strconv.Itoa(formatVersion) || props["kdf"] != "scrypt" {
+		return nil, fmt.Errorf("unsupported key file (version %s, kdf %s)", props["version"], props["kdf"])
+	}
+
+	params := make(map[string]int)
+	for _, k := range []string{"n", "r", "p"} {
+		v, err := strconv.Atoi(props[k])
+		if err != nil {
+			return nil, fmt.Errorf("invalid key file: bad scrypt parameter %s", k)
+		}
+		params[k] = v
+	}
+
+	salt, err := hex.DecodeString(props["salt"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file: %s", err)
+	}
+	sealed, err := hex.DecodeString(props["key"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file: %s", err)
+	}
+
+	kek, err := scrypt.Key(passphrase, salt, params["n"], params["r"], params["p"], chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.NewX(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	master, err := open(aead, sealed, nil)
+	if err != nil {
+		return nil, WrongPassphrase
+	}
+	return master, nil
+}
+
+// OpenEncryptStorage opens an encrypted storage on top of base.
+// If base doesn't contain a key file yet, a new master key is generated.
+func OpenEncryptStorage(base storage.Storage, passphrase []byte, hideTypes bool) (EncryptStorage, error) {
+	es := EncryptStorage{Base: base, HideTypes: hideTypes}
+
+	if len(passphrase) == 0 {
+		return es, errors.New("passphrase must not be empty")
+	}
+
+	// Not all storages return storage.ObjectNotFound from Get, so we need to check with Has
+	has_keyfile, err := base.Has(keyFileId)
+	if err != nil {
+		return es, err
+	}
+
+	var master []byte
+	if has_keyfile {
+		keyfile, err := base.Get(keyFileId)
+		if err != nil {
+			return es, err
+		}
+		if master, err = loadKeyFile(keyfile, passphrase); err != nil {
+			return es, err
+		}
+	} else {
+		var keyfile []byte
+		if keyfile, master, err = createKeyFile(passphrase); err != nil {
+			return es, err
+		}
+		if err := base.Set(keyFileId, objects.OTBlob, keyfile); err != nil {
+			return es, err
+		}
+	}
+
+	if es.aead, err = chacha20poly1305.NewX(master); err != nil {
+		return es, err
+	}
+
+	if hideTypes {
+		es.index = storage.NewIndex()
+
+		if has_index, err := base.Has(indexId); err != nil || !has_index {
+			return es, err
+		}
+
+		sealed, err := base.Get(indexId)
+		if err != nil {
+			return es, err
+		}
+
+		plain, err := open(es.aead, sealed, []byte(indexId.String()))
+		if err != nil {
+			return es, fmt.Errorf("could not decrypt index: %s", err)
+		}
+
+		if err := es.index.Load(bytes.NewReader(plain)); err != nil {
+			return es, err
+		}
+	}
+
+	return es, nil
+}
+
+func (es EncryptStorage) Get(id objects.ObjectId) ([]byte, error) {
+	sealed, err := es.Base.Get(id)
+	if err != nil {
+		return sealed, err
+	}
+
+	// The ID is used as additional data, so encrypted objects can not be swapped around
+	return open(es.aead, sealed, []byte(id.String()))
+}
+
+func (es EncryptStorage) Has(id objects.ObjectId) (bool, error) {
+	return es.Base.Has(id)
+}
+
+func (es EncryptStorage) Set(id objects.ObjectId, typ objects.ObjectType, raw []byte) error {
+	sealed, err := seal(es.aead, raw, []byte(id.String()))
+	if err != nil {
+		return err
+	}
+
+	if !es.HideTypes {
+		return es.Base.Set(id, typ, sealed)
+	}
+
+	if err := es.Base.Set(id, objects.OTBlob, sealed); err != nil {
+		return err
+	}
+	es.index.Set(id, typ)
+	return nil
+}
+
+func isInternal(id objects.ObjectId) bool {
+	return id.Equals(keyFileId) || id.Equals(indexId)
+}
+
+func (es EncryptStorage) List(typ objects.ObjectType) ([]objects.ObjectId, error) {
+	if es.HideTypes {
+		return es.index.List(typ), nil
+	}
+
+	ids, err := es.Base.List(typ)
+	if err != nil {
+		return ids, err
+	}
+
+	// Don't expose the key file and index, they would otherwise e.g. get deleted by a prune
+	filtered := make([]objects.ObjectId, 0, len(ids))
+	for _, id := range ids {
+		if !isInternal(id) {
+			filtered = append(filtered, id)
+		}
+	}
+	return filtered, nil
+}
+
+func (es EncryptStorage) Delete(id objects.ObjectId) error {
+	if isInternal(id) {
+		return storage.ObjectNotFound
+	}
+
+	if err := es.Base.Delete(id); err != nil {
+		return err
+	}
+
+	if es.HideTypes {
+		es.index.Delete(id)
+	}
+	return nil
+}
+
+// restoreIndex rebuilds the index of hidden types by decrypting every object of the base storage
+func (es EncryptStorage) restoreIndex(log *logging.Log) error {
+	ids, err := es.Base.List(objects.OTBlob)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if isInternal(id) {
+			continue
+		}
+
+		raw, err := es.Get(id)
+		if err != nil {
+			log.Error().Printf("Skip %s: %s", id, err)
+			continue
+		}
+
+		obj, err := objects.Unserialize(bytes.NewReader(raw))
+		if err != nil {
+			log.Error().Printf("Skip %s: %s", id, err)
+			continue
+		}
+
+		if !obj.Type.IsKnown() {
+			log.Error().Printf("Skip %s, unknown object type %s", id, obj.Type)
+			continue
+		}
+
+		log.Debug().Printf("%s is a %s", id, obj.Type)
+		es.index.Set(id, obj.Type)
+	}
+
+	return nil
+}
+
+func (es EncryptStorage) Subcmds() map[string]storage.StorageSubcmd {
+	cmds := es.Base.Subcmds()
+	if !es.HideTypes {
+		return cmds
+	}
+
+	// Our own restore-index replaces the one of the base storage, the base index only lists blobs anyway
+	cmds["restore-index"] = func(args []string, log *logging.Log, conf config.Config) int {
+		if base_restore, ok := es.Base.Subcmds()["restore-index"]; ok {
+			if ret := base_restore(args, log, conf); ret != 0 {
+				return ret
+			}
+		}
+
+		if err := es.restoreIndex(log); err != nil {
+			log.Error().Print(err)
+			return 1
+		}
+		return 0
+	}
+	return cmds
+}
+
+func (es EncryptStorage) Close() error {
+	if es.HideTypes {
+		buf := new(bytes.Buffer)
+		if err := es.index.Save(buf); err != nil {
+			return err
+		}
+
+		sealed, err := seal(es.aead, buf.Bytes(), []byte(indexId.String()))
+		if err != nil {
+			return err
+		}
+
+		if err := es.Base.Set(indexId, objects.OTBlob, sealed); err != nil {
+			return err
+		}
+	}
+
+	return es.Base.Close()
+}
@@ -0,0 +1,145 @@
+package encrypt
+
+import (
+	"bytes"
+	"code.laria.me/petrific/backup"
+	"code.laria.me/petrific/config"
+	"code.laria.me/petrific/logging"
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"code.laria.me/petrific/storage/memory"
+	"testing"
+)
+
+var testContent = []byte("This is some secret content, that should not be readable from the base storage")
+
+func writeTestFile(t *testing.T, st storage.Storage) objects.ObjectId {
+	id, err := backup.WriteFile(st, bytes.NewReader(testContent))
+	if err != nil {
+		t.Fatalf("WriteFile failed: %s", err)
+	}
+	return id
+}
+
+func wantTestFile(t *testing.T, st storage.Storage, id objects.ObjectId) {
+	buf := new(bytes.Buffer)
+	if err := backup.RestoreFile(st, id, buf); err != nil {
+		t.Fatalf("RestoreFile failed: %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), testContent) {
+		t.Errorf("Unexpected content: %s", buf.Bytes())
+	}
+}
+
+func TestEncryptStorage(t *testing.T) {
+	base := memory.NewMemoryStorage()
+
+	es, err := OpenEncryptStorage(base, []byte("hunter2"), false)
+	if err != nil {
+		t.Fatalf("Could not open storage: %s", err)
+	}
+
+	id := writeTestFile(t, es)
+	wantTestFile(t, es, id)
+
+	for _, typ := range []objects.ObjectType{objects.OTBlob, objects.OTFile} {
+		ids, err := base.List(typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, id := range ids {
+			raw, _ := base.Get(id)
+			if bytes.Contains(raw, testContent) || bytes.Contains(raw, []byte(typ)) {
+				t.Errorf("base object %s is not encrypted", id)
+			}
+		}
+	}
+
+	// The key file must not be listed (and therefore can't be pruned)
+	if ids, _ := es.List(objects.OTBlob); len(ids) != 1 {
+		t.Errorf("Expected 1 listed blob, got %d", len(ids))
+	}
+
+	// Reopen with the correct passphrase
+	es, err = OpenEncryptStorage(base, []byte("hunter2"), false)
+	if err != nil {
+		t.Fatalf("Could not reopen storage: %s", err)
+	}
+	wantTestFile(t, es, id)
+
+	if _, err := OpenEncryptStorage(base, []byte("wrong"), false); err != WrongPassphrase {
+		t.Errorf("Opening with wrong passphrase returned %v", err)
+	}
+}
+
+func TestEncryptedObjectsAreBoundToId(t *testing.T) {
+	base := memory.NewMemoryStorage()
+	es, err := OpenEncryptStorage(base, []byte("hunter2"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := objects.Blob("a")
+	a_id, err := storage.SetObject(es, objects.ToRawObject(&a))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := objects.Blob("b")
+	b_id, err := storage.SetObject(es, objects.ToRawObject(&b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Swap the encrypted content of a into b
+	sealed_a, _ := base.Get(a_id)
+	base.Set(b_id, objects.OTBlob, sealed_a)
+
+	if _, err := es.Get(b_id); err != DecryptionFailed {
+		t.Errorf("Getting a swapped object returned %v", err)
+	}
+}
+
+func TestEncryptStorageHideTypes(t *testing.T) {
+	base := memory.NewMemoryStorage()
+
+	es, err := OpenEncryptStorage(base, []byte("hunter2"), true)
+	if err != nil {
+		t.Fatalf("Could not open storage: %s", err)
+	}
+
+	id := writeTestFile(t, es)
+
+	if ids, _ := base.List(objects.OTFile); len(ids) != 0 {
+		t.Errorf("base storage lists %d file objects", len(ids))
+	}
+
+	if err := es.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	es, err = OpenEncryptStorage(base, []byte("hunter2"), true)
+	if err != nil {
+		t.Fatalf("Could not reopen storage: %s", err)
+	}
+
+	ids, err := es.List(objects.OTFile)
+	if err != nil || len(ids) != 1 || !ids[0].Equals(id) {
+		t.Errorf("Unexpected List result: %v, %v", ids, err)
+	}
+	wantTestFile(t, es, id)
+
+	// Rebuild the index from scratch
+	es.index = storage.NewIndex()
+	if ret := es.Subcmds()["restore-index"](nil, logging.NewNopLog(), config.Config{}); ret != 0 {
+		t.Fatalf("restore-index returned %d", ret)
+	}
+
+	if ids, _ := es.List(objects.OTFile); len(ids) != 1 || !ids[0].Equals(id) {
+		t.Errorf("Unexpected List result after restore-index: %v", ids)
+	}
+	if ids, _ := es.List(objects.OTBlob); len(ids) != 1 {
+		t.Errorf("Unexpected number of blobs after restore-index: %d", len(ids))
+	}
+}
@@ -0,0 +1,155 @@
+package filter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io/ioutil"
+)
+
+// Compressed objects start with this header, followed by a byte identifying the algorithm.
+// Serialized objects always start with their (ASCII) type, so objects without this header can be passed through
+// unchanged. This way, uncompressed objects written before compression was enabled remain readable.
+var compressHeader = []byte{0, 'p', 'z'}
+
+type CompressionAlgo byte
+
+const (
+	CompressNone CompressionAlgo = 'n'
+	CompressGzip CompressionAlgo = 'g'
+	CompressZstd CompressionAlgo = 'z'
+)
+
+func ParseCompressionAlgo(s string) (CompressionAlgo, error) {
+	switch s {
+	case "", "zstd":
+		return CompressZstd, nil
+	case "gzip":
+		return CompressGzip, nil
+	case "none":
+		return CompressNone, nil
+	default:
+		return 0, fmt.Errorf("unknown compression algorithm %s", s)
+	}
+}
+
+// CompressFilter compresses data and prepends a header describing the compression.
+// If compression doesn't make the data smaller, the data is stored uncompressed.
+type CompressFilter struct {
+	Algo  CompressionAlgo
+	Level int // 0 means: Default level of the algorithm
+
+	zstdEnc *zstd.Encoder
+}
+
+// NewCompressFilter creates a CompressFilter. For zstd, level is the zstd level (1-22), for gzip the level is 1-9.
+func NewCompressFilter(algo CompressionAlgo, level int) (*CompressFilter, error) {
+	cf := &CompressFilter{Algo: algo, Level: level}
+
+	switch algo {
+	case CompressZstd:
+		zstd_level := zstd.SpeedDefault
+		if level != 0 {
+			zstd_level = zstd.EncoderLevelFromZstd(level)
+		}
+
+		var err error
+		cf.zstdEnc, err = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd_level))
+		if err != nil {
+			return nil, err
+		}
+	case CompressGzip:
+		if level < 0 || level > gzip.BestCompression {
+			return nil, fmt.Errorf("invalid gzip level %d", level)
+		}
+	case CompressNone:
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %c", algo)
+	}
+
+	return cf, nil
+}
+
+func (cf *CompressFilter) compress(b []byte) ([]byte, error) {
+	out := append(append([]byte{}, compressHeader...), byte(cf.Algo))
+
+	switch cf.Algo {
+	case CompressZstd:
+		return cf.zstdEnc.EncodeAll(b, out), nil
+	case CompressGzip:
+		level := cf.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+
+		buf := bytes.NewBuffer(out)
+		w, err := gzip.NewWriterLevel(buf, level)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(b); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return append(out, b...), nil
+	}
+}
+
+func (cf *CompressFilter) Transform(b []byte) ([]byte, error) {
+	compressed, err := cf.compress(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if cf.Algo != CompressNone && len(compressed) >= len(b)+len(compressHeader)+1 {
+		// Not worth it
+		return append(append(append([]byte{}, compressHeader...), byte(CompressNone)), b...), nil
+	}
+
+	return compressed, nil
+}
+
+// DecompressFilter decompresses data compressed by CompressFilter. Data without a compression header is passed through.
+type DecompressFilter struct {
+	zstdDec *zstd.Decoder
+}
+
+func NewDecompressFilter() (*DecompressFilter, error) {
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	if err != nil {
+		return nil, err
+	}
+
+	return &DecompressFilter{zstdDec: dec}, nil
+}
+
+func (df *DecompressFilter) Transform(b []byte) ([]byte, error) {
+	if len(b) < len(compressHeader)+1 || !bytes.Equal(b[:len(compressHeader)], compressHeader) {
+		return b, nil
+	}
+
+	algo := CompressionAlgo(b[len(compressHeader)])
+	payload := b[len(compressHeader)+1:]
+
+	switch algo {
+	case CompressNone:
+		return payload, nil
+	case CompressZstd:
+		return df.zstdDec.DecodeAll(payload, nil)
+	case CompressGzip:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		return ioutil.ReadAll(r)
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %c", algo)
+	}
+}
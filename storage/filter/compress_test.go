@@ -0,0 +1,81 @@
+package filter
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func newCompressionFilters(t *testing.T, algo CompressionAlgo, level int) (Filter, Filter) {
+	encode, err := NewCompressFilter(algo, level)
+	if err != nil {
+		t.Fatalf("Could not create compress filter: %s", err)
+	}
+
+	decode, err := NewDecompressFilter()
+	if err != nil {
+		t.Fatalf("Could not create decompress filter: %s", err)
+	}
+
+	return encode, decode
+}
+
+func TestCompressFilters(t *testing.T) {
+	for _, algo := range []CompressionAlgo{CompressZstd, CompressGzip, CompressNone} {
+		encode, decode := newCompressionFilters(t, algo, 0)
+		testFilter(t, encode, decode)
+	}
+}
+
+func TestCompressionShrinks(t *testing.T) {
+	data := bytes.Repeat([]byte("petrific "), 1000)
+
+	for _, algo := range []CompressionAlgo{CompressZstd, CompressGzip} {
+		encode, decode := newCompressionFilters(t, algo, 9)
+
+		compressed, err := encode.Transform(data)
+		if err != nil {
+			t.Fatalf("Compression with %c failed: %s", algo, err)
+		}
+
+		if len(compressed) >= len(data)/10 {
+			t.Errorf("%c: compressed size %d is too large", algo, len(compressed))
+		}
+
+		decompressed, err := decode.Transform(compressed)
+		if err != nil {
+			t.Fatalf("Decompression with %c failed: %s", algo, err)
+		}
+		if !bytes.Equal(decompressed, data) {
+			t.Errorf("%c: decompressed data differs", algo)
+		}
+	}
+}
+
+func TestIncompressibleDataStoredRaw(t *testing.T) {
+	data := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	encode, _ := newCompressionFilters(t, CompressZstd, 0)
+	out, err := encode.Transform(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out) != len(data)+len(compressHeader)+1 || CompressionAlgo(out[len(compressHeader)]) != CompressNone {
+		t.Errorf("incompressible data was not stored uncompressed (size %d)", len(out))
+	}
+}
+
+func TestDecompressLegacyObject(t *testing.T) {
+	_, decode := newCompressionFilters(t, CompressZstd, 0)
+
+	legacy := []byte("blob 3\nfoo")
+	out, err := decode.Transform(legacy)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !bytes.Equal(out, legacy) {
+		t.Errorf("legacy object was modified: %q", out)
+	}
+}
@@ -87,6 +87,10 @@ func (filt FilterStorage) List(typ objects.ObjectType) ([]objects.ObjectId, erro
 	return filt.Base.List(typ)
 }
 
+func (filt FilterStorage) Delete(id objects.ObjectId) error {
+	return filt.Base.Delete(id)
+}
+
 func (filt FilterStorage) Subcmds() map[string]storage.StorageSubcmd {
 	return filt.Base.Subcmds()
 }
@@ -26,6 +26,14 @@ func (idx Index) Set(id objects.ObjectId, typ objects.ObjectType) {
 	idx[typ][id.String()] = struct{}{}
 }
 
+// Delete removes an object from the index, regardless of it's type
+func (idx Index) Delete(id objects.ObjectId) {
+	sid := id.String()
+	for _, objs := range idx {
+		delete(objs, sid)
+	}
+}
+
 func (idx Index) List(typ objects.ObjectType) []objects.ObjectId {
 	ids := make([]objects.ObjectId, 0, len(idx[typ]))
 	for id := range idx[typ] {
@@ -128,6 +128,18 @@ func (l LocalStorage) List(typ objects.ObjectType) ([]objects.ObjectId, error) {
 	return l.index.List(typ), nil
 }
 
+func (l LocalStorage) Delete(id objects.ObjectId) error {
+	err := os.Remove(joinPath(l.Path, objectPath(id)))
+	if os.IsNotExist(err) {
+		return storage.ObjectNotFound
+	} else if err != nil {
+		return err
+	}
+
+	l.index.Delete(id)
+	return nil
+}
+
 func (LocalStorage) Subcmds() map[string]storage.StorageSubcmd {
 	return make(map[string]storage.StorageSubcmd)
 }
@@ -45,8 +45,11 @@ func (ms MemoryStorage) Has(id objects.ObjectId) (bool, error) {
 }
 
 func (ms MemoryStorage) Set(id objects.ObjectId, typ objects.ObjectType, raw []byte) error {
+	_, exists := ms.objects[id.String()]
 	ms.objects[id.String()] = copyBytes(raw)
-	ms.bytype[typ] = append(ms.bytype[typ], id)
+	if !exists {
+		ms.bytype[typ] = append(ms.bytype[typ], id)
+	}
 
 	return nil
 }
@@ -55,6 +58,25 @@ func (ms MemoryStorage) List(typ objects.ObjectType) ([]objects.ObjectId, error)
 	return ms.bytype[typ], nil
 }
 
+func (ms MemoryStorage) Delete(id objects.ObjectId) error {
+	if _, ok := ms.objects[id.String()]; !ok {
+		return storage.ObjectNotFound
+	}
+	delete(ms.objects, id.String())
+
+	for typ, ids := range ms.bytype {
+		kept := make([]objects.ObjectId, 0, len(ids))
+		for _, other := range ids {
+			if !other.Equals(id) {
+				kept = append(kept, other)
+			}
+		}
+		ms.bytype[typ] = kept
+	}
+
+	return nil
+}
+
 func (MemoryStorage) Subcmds() map[string]storage.StorageSubcmd {
 	return make(map[string]storage.StorageSubcmd)
 }
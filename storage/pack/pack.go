@@ -0,0 +1,562 @@
+// Package pack provides a storage that combines many objects into larger pack files
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"code.laria.me/petrific/config"
+	"code.laria.me/petrific/logging"
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	DefaultPackSize = 16 * 1024 * 1024 // 16MB
+
+	packMagic = "petrific-pack 1\n"
+
+	// Number of recently read packs kept in memory
+	packCacheSize = 4
+)
+
+// The pack index is stored in the base storage under this fixed ID
+var indexId = fixedId("petrific pack index")
+
+func fixedId(name string) objects.ObjectId {
+	gen := objects.OIdAlgoDefault.Generator()
+	gen.Write([]byte(name))
+	return gen.GetId()
+}
+
+type packEntry struct {
+	typ            objects.ObjectType
+	pack           string // ID of the pack, empty if the object is still pending
+	offset, length int
+}
+
+type packState struct {
+	sync.Mutex
+
+	entries map[string]packEntry
+	packs   map[string]int // Pack ID => size
+
+	pending *bytes.Buffer
+
+	cache      map[string][]byte
+	cacheOrder []string
+}
+
+// PackStorage is a storage implementation wrapping around another storage, combining many objects into pack files.
+// This reduces the number of objects in the base storage, which is especially useful for cloud storages.
+//
+// A pack is stored as a blob in the base storage. It starts with the line "petrific-pack 1" followed by the serialized
+// objects. Since objects are self-describing, the index (which records the location of each object) can always be
+// rebuilt from the packs using the storage subcommand "restore-index". Objects that are already in the base storage
+// remain readable.
+//
+// The index is stored as a single object in the base storage, so only one petrific process should write to the
+// storage at the same time.
+//
+// Deleting objects only removes them from the index. Use the storage subcommand "repack" to rewrite packs
+// containing deleted objects (and to combine small packs).
+//
+// It is used in a configuration by using the method "pack". It needs the config key "base" referencing the name of
+// another configured storage. The optional key "pack_size" sets the size of the packs in bytes (default 16MB).
+//
+//     [storage.cloud_packed]
+//     method="pack"
+//     base="cloud"
+//     pack_size=33554432
+type PackStorage struct {
+	Base     storage.Storage
+	PackSize int
+
+	*packState
+}
+
+// OpenPackStorage opens a pack storage on top of base and loads the pack index
+func OpenPackStorage(base storage.Storage, packSize int) (PackStorage, error) {
+	if packSize <= 0 {
+		packSize = DefaultPackSize
+	}
+
+	ps := PackStorage{
+		Base:     base,
+		PackSize: packSize,
+		packState: &packState{
+			entries: make(map[string]packEntry),
+			packs:   make(map[string]int),
+			pending: new(bytes.Buffer),
+			cache:   make(map[string][]byte),
+		},
+	}
+
+	has_index, err := base.Has(indexId)
+	if err != nil || !has_index {
+		return ps, err
+	}
+
+	raw, err := base.Get(indexId)
+	if err != nil {
+		return ps, err
+	}
+
+	if err := ps.loadIndex(bytes.NewReader(raw)); err != nil {
+		return ps, fmt.Errorf("could not load pack index: %s", err)
+	}
+
+	return ps, nil
+}
+
+// The index is a text file with two kinds of lines:
+//
+//     pack <pack id> <size>
+//     obj <type> <object id> <pack id> <offset> <length>
+func (ps PackStorage) loadIndex(r io.Reader) error {
+	scan := bufio.NewScanner(r)
+	for scan.Scan() {
+		parts := strings.Fields(scan.Text())
+		if len(parts) == 0 {
+			continue
+		}
+
+		switch {
+		case parts[0] == "pack" && len(parts) == 3:
+			size, err := strconv.Atoi(parts[2])
+			if err != nil {
+				return err
+			}
+			ps.packs[parts[1]] = size
+		case parts[0] == "obj" && len(parts) == 6:
+			offset, err := strconv.Atoi(parts[4])
+			if err != nil {
+				return err
+			}
+			length, err := strconv.Atoi(parts[5])
+			if err != nil {
+				return err
+			}
+
+			ps.entries[parts[2]] = packEntry{
+				typ:    objects.ObjectType(parts[1]),
+				pack:   parts[3],
+				offset: offset,
+				length: length,
+			}
+		default:
+			return fmt.Errorf("invalid line: %s", scan.Text())
+		}
+	}
+
+	return scan.Err()
+}
+
+func (ps PackStorage) saveIndex() error {
+	buf := new(bytes.Buffer)
+
+	for pack, size := range ps.packs {
+		fmt.Fprintf(buf, "pack %s %d\n", pack, size)
+	}
+	for id, e := range ps.entries {
+		fmt.Fprintf(buf, "obj %s %s %s %d %d\n", e.typ, id, e.pack, e.offset, e.length)
+	}
+
+	return ps.Base.Set(indexId, objects.OTBlob, buf.Bytes())
+}
+
+// flush writes all pending objects as a new pack. Must be called with the lock held.
+func (ps PackStorage) flush() error {
+	if ps.pending.Len() == 0 {
+		return nil
+	}
+
+	data := append([]byte(packMagic), ps.pending.Bytes()...)
+
+	gen := objects.OIdAlgoDefault.Generator()
+	gen.Write(data)
+	pack_id := gen.GetId()
+
+	if err := ps.Base.Set(pack_id, objects.OTBlob, data); err != nil {
+		return err
+	}
+
+	pack := pack_id.String()
+	ps.packs[pack] = len(data)
+
+	for id, e := range ps.entries {
+		if e.pack == "" {
+			e.pack = pack
+			e.offset += len(packMagic)
+			ps.entries[id] = e
+		}
+	}
+
+	ps.pending = new(bytes.Buffer)
+	return nil
+}
+
+// loadPack gets a pack from the base storage or the cache. Must be called with the lock held.
+func (ps PackStorage) loadPack(pack string) ([]byte, error) {
+	if data, ok := ps.cache[pack]; ok {
+		return data, nil
+	}
+
+	id, err := objects.ParseObjectId(pack)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ps.Base.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ps.cacheOrder) >= packCacheSize {
+		delete(ps.cache, ps.cacheOrder[0])
+		ps.cacheOrder = ps.cacheOrder[1:]
+	}
+	ps.cache[pack] = data
+	ps.cacheOrder = append(ps.cacheOrder, pack)
+
+	return data, nil
+}
+
+// readEntry reads the raw object described by e. Must be called with the lock held.
+func (ps PackStorage) readEntry(e packEntry) ([]byte, error) {
+	var data []byte
+	if e.pack == "" {
+		data = ps.pending.Bytes()
+	} else {
+		var err error
+		if data, err = ps.loadPack(e.pack); err != nil {
+			return nil, err
+		}
+	}
+
+	if e.offset < 0 || e.offset+e.length > len(data) {
+		return nil, fmt.Errorf("pack %s is too short", e.pack)
+	}
+
+	out := make([]byte, e.length)
+	copy(out, data[e.offset:e.offset+e.length])
+	return out, nil
+}
+
+func (ps PackStorage) Get(id objects.ObjectId) ([]byte, error) {
+	ps.Lock()
+	e, ok := ps.entries[id.String()]
+	if ok {
+		defer ps.Unlock()
+		return ps.readEntry(e)
+	}
+	ps.Unlock()
+
+	// Might be an object written before packing was used
+	return ps.Base.Get(id)
+}
+
+func (ps PackStorage) Has(id objects.ObjectId) (bool, error) {
+	ps.Lock()
+	_, ok := ps.entries[id.String()]
+	ps.Unlock()
+
+	if ok {
+		return true, nil
+	}
+	return ps.Base.Has(id)
+}
+
+// add adds an object to the pending pack. Must be called with the lock held.
+func (ps PackStorage) add(id string, typ objects.ObjectType, raw []byte) error {
+	ps.entries[id] = packEntry{
+		typ:    typ,
+		offset: ps.pending.Len(),
+		length: len(raw),
+	}
+	ps.pending.Write(raw)
+
+	if ps.pending.Len() >= ps.PackSize {
+		return ps.flush()
+	}
+	return nil
+}
+
+func (ps PackStorage) Set(id objects.ObjectId, typ objects.ObjectType, raw []byte) error {
+	ps.Lock()
+	defer ps.Unlock()
+
+	if _, ok := ps.entries[id.String()]; ok {
+		return nil
+	}
+
+	return ps.add(id.String(), typ, raw)
+}
+
+// isInternal checks, if an object of the base storage is a pack or the index
+func (ps PackStorage) isInternal(id objects.ObjectId) bool {
+	if id.Equals(indexId) {
+		return true
+	}
+	_, ok := ps.packs[id.String()]
+	return ok
+}
+
+func (ps PackStorage) List(typ objects.ObjectType) ([]objects.ObjectId, error) {
+	base_ids, err := ps.Base.List(typ)
+	if err != nil {
+		return nil, err
+	}
+
+	ps.Lock()
+	defer ps.Unlock()
+
+	ids := make([]objects.ObjectId, 0, len(base_ids))
+	for id, e := range ps.entries {
+		if e.typ == typ {
+			ids = append(ids, objects.MustParseObjectId(id))
+		}
+	}
+
+	for _, id := range base_ids {
+		if _, packed := ps.entries[id.String()]; !packed && !ps.isInternal(id) {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
+
+func (ps PackStorage) Delete(id objects.ObjectId) error {
+	ps.Lock()
+	_, ok := ps.entries[id.String()]
+	if ok {
+		// The data remains in the pack until it gets repacked
+		delete(ps.entries, id.String())
+	}
+	internal := ps.isInternal(id)
+	ps.Unlock()
+
+	if ok {
+		return nil
+	}
+	if internal {
+		return storage.ObjectNotFound
+	}
+	return ps.Base.Delete(id)
+}
+
+// Repack rewrites all packs where less than minUsage (0 to 1) of the data is still referenced, and all packs
+// that are smaller than half of the pack size (to combine them). Old packs are deleted after the new ones and
+// the index were written.
+func (ps PackStorage) Repack(minUsage float64, log *logging.Log) error {
+	ps.Lock()
+	defer ps.Unlock()
+
+	used := make(map[string]int)
+	for _, e := range ps.entries {
+		if e.pack != "" {
+			used[e.pack] += e.length
+		}
+	}
+
+	obsolete := []string{}
+	for pack, size := range ps.packs {
+		usage := float64(used[pack]) / float64(size)
+		if usage >= minUsage && size >= ps.PackSize/2 {
+			continue
+		}
+
+		log.Info().Printf("repacking %s (size: %d, usage: %.1f%%)", pack, size, usage*100)
+		obsolete = append(obsolete, pack)
+	}
+
+	// Repacking a single small pack without deleted objects would only produce the same pack again
+	if len(obsolete) == 0 || (len(obsolete) == 1 && used[obsolete[0]]+len(packMagic) == ps.packs[obsolete[0]]) {
+		log.Info().Print("nothing to repack")
+		return nil
+	}
+
+	for _, pack := range obsolete {
+		data, err := ps.loadPack(pack)
+		if err != nil {
+			return err
+		}
+
+		for id, e := range ps.entries {
+			if e.pack != pack {
+				continue
+			}
+
+			if err := ps.add(id, e.typ, data[e.offset:e.offset+e.length]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := ps.flush(); err != nil {
+		return err
+	}
+
+	// Pack ids are content hashes, so a new pack can have the same id as an obsolete one. All objects of the
+	// obsolete packs were moved, so obsolete packs that are still referenced were just written again and must be kept.
+	written := make(map[string]bool)
+	for _, e := range ps.entries {
+		written[e.pack] = true
+	}
+
+	for _, pack := range obsolete {
+		if !written[pack] {
+			delete(ps.packs, pack)
+		}
+	}
+
+	if err := ps.saveIndex(); err != nil {
+		return err
+	}
+
+	for _, pack := range obsolete {
+		if written[pack] {
+			continue
+		}
+
+		delete(ps.cache, pack)
+		log.Debug().Printf("deleting pack %s", pack)
+		if err := ps.Base.Delete(objects.MustParseObjectId(pack)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parsePack returns the entries of all objects in a pack
+func parsePack(pack string, data []byte) (map[string]packEntry, error) {
+	if !bytes.HasPrefix(data, []byte(packMagic)) {
+		return nil, errors.New("not a pack")
+	}
+
+	entries := make(map[string]packEntry)
+
+	r := bytes.NewReader(data[len(packMagic):])
+	for r.Len() > 0 {
+		start := len(data) - r.Len()
+
+		obj, err := objects.Unserialize(r)
+		if err != nil {
+			return nil, err
+		}
+
+		end := len(data) - r.Len()
+
+		gen := objects.OIdAlgoDefault.Generator()
+		gen.Write(data[start:end])
+
+		entries[gen.GetId().String()] = packEntry{
+			typ:    obj.Type,
+			pack:   pack,
+			offset: start,
+			length: end - start,
+		}
+	}
+
+	return entries, nil
+}
+
+// restoreIndex rebuilds the pack index by reading all packs in the base storage
+func (ps PackStorage) restoreIndex(log *logging.Log) error {
+	ids, err := ps.Base.List(objects.OTBlob)
+	if err != nil {
+		return err
+	}
+
+	ps.Lock()
+	defer ps.Unlock()
+
+	for _, id := range ids {
+		if id.Equals(indexId) {
+			continue
+		}
+
+		data, err := ps.Base.Get(id)
+		if err != nil {
+			return err
+		}
+
+		if !bytes.HasPrefix(data, []byte(packMagic)) {
+			continue
+		}
+
+		entries, err := parsePack(id.String(), data)
+		if err != nil {
+			log.Error().Printf("Skip pack %s: %s", id, err)
+			continue
+		}
+
+		log.Debug().Printf("pack %s contains %d objects", id, len(entries))
+
+		ps.packs[id.String()] = len(data)
+		for obj_id, e := range entries {
+			ps.entries[obj_id] = e
+		}
+	}
+
+	return ps.saveIndex()
+}
+
+func (ps PackStorage) Subcmds() map[string]storage.StorageSubcmd {
+	cmds := ps.Base.Subcmds()
+
+	base_restore, has_base_restore := cmds["restore-index"]
+	cmds["restore-index"] = func(args []string, log *logging.Log, conf config.Config) int {
+		if has_base_restore {
+			if ret := base_restore(args, log, conf); ret != 0 {
+				return ret
+			}
+		}
+
+		if err := ps.restoreIndex(log); err != nil {
+			log.Error().Print(err)
+			return 1
+		}
+		return 0
+	}
+
+	cmds["repack"] = func(args []string, log *logging.Log, conf config.Config) int {
+		minUsage := 0.75
+		if len(args) > 0 {
+			var err error
+			if minUsage, err = strconv.ParseFloat(args[0], 64); err != nil || minUsage < 0 || minUsage > 1 {
+				log.Error().Printf("repack: usage must be a number between 0 and 1")
+				return 2
+			}
+		}
+
+		if err := ps.Repack(minUsage, log); err != nil {
+			log.Error().Print(err)
+			return 1
+		}
+		return 0
+	}
+
+	return cmds
+}
+
+func (ps PackStorage) Close() error {
+	ps.Lock()
+	err := ps.flush()
+	if err == nil {
+		err = ps.saveIndex()
+	}
+	ps.Unlock()
+
+	if cerr := ps.Base.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,223 @@
+package pack
+
+import (
+	"bytes"
+	"code.laria.me/petrific/backup"
+	"code.laria.me/petrific/config"
+	"code.laria.me/petrific/logging"
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"code.laria.me/petrific/storage/memory"
+	"fmt"
+	"testing"
+)
+
+func setBlobs(t *testing.T, st storage.Storage, n int) []objects.ObjectId {
+	ids := []objects.ObjectId{}
+	for i := 0; i < n; i++ {
+		blob := objects.Blob(fmt.Sprintf("blob number %d", i))
+		id, err := storage.SetObject(st, objects.ToRawObject(&blob))
+		if err != nil {
+			t.Fatalf("SetObject failed: %s", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func wantBlobs(t *testing.T, st storage.Storage, ids []objects.ObjectId) {
+	for i, id := range ids {
+		obj, err := storage.GetObjectOfType(st, id, objects.OTBlob)
+		if err != nil {
+			t.Errorf("GetObject(%s) failed: %s", id, err)
+			continue
+		}
+
+		if blob := obj.(*objects.Blob); string(*blob) != fmt.Sprintf("blob number %d", i) {
+			t.Errorf("Unexpected content of %s: %s", id, *blob)
+		}
+	}
+}
+
+func countBase(t *testing.T, base storage.Storage) int {
+	ids, err := base.List(objects.OTBlob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(ids)
+}
+
+func TestPackStorage(t *testing.T) {
+	base := memory.NewMemoryStorage()
+
+	ps, err := OpenPackStorage(base, 200)
+	if err != nil {
+		t.Fatalf("Could not open storage: %s", err)
+	}
+
+	ids := setBlobs(t, ps, 20)
+	wantBlobs(t, ps, ids)
+
+	if listed, _ := ps.List(objects.OTBlob); len(listed) != len(ids) {
+		t.Errorf("Expected %d listed blobs, got %d", len(ids), len(listed))
+	}
+
+	if err := ps.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	// 20 objects of about 30 bytes each in packs of 200 bytes, plus the index
+	if n := countBase(t, base); n < 2 || n > 6 {
+		t.Errorf("Unexpected number of objects in base storage: %d", n)
+	}
+
+	ps, err = OpenPackStorage(base, 200)
+	if err != nil {
+		t.Fatalf("Could not reopen storage: %s", err)
+	}
+	wantBlobs(t, ps, ids)
+}
+
+func TestPackStorageLegacyObjects(t *testing.T) {
+	base := memory.NewMemoryStorage()
+	legacy := setBlobs(t, base, 3)
+
+	ps, err := OpenPackStorage(base, 0)
+	if err != nil {
+		t.Fatalf("Could not open storage: %s", err)
+	}
+
+	wantBlobs(t, ps, legacy)
+	if listed, _ := ps.List(objects.OTBlob); len(listed) != len(legacy) {
+		t.Errorf("Expected %d listed blobs, got %d", len(legacy), len(listed))
+	}
+}
+
+func TestRepack(t *testing.T) {
+	base := memory.NewMemoryStorage()
+	log := logging.NewNopLog()
+
+	ps, err := OpenPackStorage(base, 200)
+	if err != nil {
+		t.Fatalf("Could not open storage: %s", err)
+	}
+
+	ids := setBlobs(t, ps, 20)
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	}
+	packs_before := len(ps.packs)
+
+	// Delete every second object
+	keep := []objects.ObjectId{}
+	for i, id := range ids {
+		if i%2 == 0 {
+			keep = append(keep, id)
+		} else if err := ps.Delete(id); err != nil {
+			t.Fatalf("Delete failed: %s", err)
+		}
+	}
+
+	if err := ps.Repack(0.75, log); err != nil {
+		t.Fatalf("Repack failed: %s", err)
+	}
+
+	if len(ps.packs) >= packs_before {
+		t.Errorf("Expected less than %d packs after repack, got %d", packs_before, len(ps.packs))
+	}
+	if n := countBase(t, base); n != len(ps.packs)+1 {
+		t.Errorf("Expected old packs to be deleted, %d objects in base, %d packs", n, len(ps.packs))
+	}
+
+	for i, id := range keep {
+		obj, err := storage.GetObjectOfType(ps, id, objects.OTBlob)
+		if err != nil {
+			t.Fatalf("GetObject(%s) failed: %s", id, err)
+		}
+		if blob := obj.(*objects.Blob); string(*blob) != fmt.Sprintf("blob number %d", i*2) {
+			t.Errorf("Unexpected content of %s: %s", id, *blob)
+		}
+	}
+
+	if err := ps.Repack(0.75, log); err != nil {
+		t.Fatalf("Second repack failed: %s", err)
+	}
+
+	// Repacking a small pack together with a pack without used objects produces a pack with the same content (and
+	// therefore the same id) as the small pack, which must not be deleted.
+	base = memory.NewMemoryStorage()
+	ps, err = OpenPackStorage(base, 200)
+	if err != nil {
+		t.Fatalf("Could not open storage: %s", err)
+	}
+
+	ids = setBlobs(t, ps, 1)
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	y := objects.Blob("deleted blob")
+	y_id, err := storage.SetObject(ps, objects.ToRawObject(&y))
+	if err != nil {
+		t.Fatalf("SetObject failed: %s", err)
+	}
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Delete(y_id); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+
+	if err := ps.Repack(0.75, log); err != nil {
+		t.Fatalf("Repack failed: %s", err)
+	}
+
+	ps, err = OpenPackStorage(base, 200)
+	if err != nil {
+		t.Fatalf("Could not reopen storage: %s", err)
+	}
+	wantBlobs(t, ps, ids)
+}
+
+func TestRestoreIndex(t *testing.T) {
+	base := memory.NewMemoryStorage()
+
+	ps, err := OpenPackStorage(base, 0)
+	if err != nil {
+		t.Fatalf("Could not open storage: %s", err)
+	}
+
+	content := []byte("Hello, world!")
+	id, err := backup.WriteFile(ps, bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("WriteFile failed: %s", err)
+	}
+
+	// Write the pending pack, but not the index
+	ps.Lock()
+	err = ps.flush()
+	ps.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ps, err = OpenPackStorage(base, 0)
+	if err != nil {
+		t.Fatalf("Could not reopen storage: %s", err)
+	}
+	if has, _ := ps.Has(id); has {
+		t.Fatalf("File should not be known without index")
+	}
+
+	if ret := ps.Subcmds()["restore-index"](nil, logging.NewNopLog(), config.Config{}); ret != 0 {
+		t.Fatalf("restore-index failed with %d", ret)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := backup.RestoreFile(ps, id, buf); err != nil {
+		t.Fatalf("RestoreFile failed: %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), content) {
+		t.Errorf("Unexpected content: %s", buf.Bytes())
+	}
+}
@@ -0,0 +1,53 @@
+package registry
+
+import (
+	"code.laria.me/petrific/config"
+	"code.laria.me/petrific/storage"
+	"code.laria.me/petrific/storage/filter"
+)
+
+// compressStorageFromConfig sets up a FilterStorage compressing all objects in-process.
+//
+// It is used in a configuration by using the method "compress". It needs the config key "base" referencing the name
+// of another configured storage. Optional keys are "algorithm" ("zstd" (default), "gzip" or "none") and "level".
+// When combining it with encryption, the compressing storage must use the encrypting storage as it's base
+// (encrypted data can't be compressed):
+//
+//     [storage.local_compressed]
+//     method="compress"
+//     base="local_encrypted"
+//     algorithm="zstd"
+//     level=3
+func compressStorageFromConfig(conf config.Config, name string) (storage.Storage, error) {
+	var storage_conf struct {
+		Base      string
+		Algorithm string `toml:"algorithm,omitempty"`
+		Level     int    `toml:"level,omitempty"`
+	}
+
+	if err := conf.GetStorageConfData(name, &storage_conf); err != nil {
+		return nil, err
+	}
+
+	algo, err := filter.ParseCompressionAlgo(storage_conf.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	encode, err := filter.NewCompressFilter(algo, storage_conf.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	decode, err := filter.NewDecompressFilter()
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := LoadStorage(conf, storage_conf.Base)
+	if err != nil {
+		return nil, err
+	}
+
+	return filter.FilterStorage{Base: base, Encode: encode, Decode: decode}, nil
+}
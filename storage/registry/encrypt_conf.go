@@ -0,0 +1,55 @@
+package registry
+
+import (
+	"bytes"
+	"code.laria.me/petrific/config"
+	"code.laria.me/petrific/storage"
+	"code.laria.me/petrific/storage/encrypt"
+	"errors"
+	"io/ioutil"
+	"os"
+)
+
+// Like FilterStorage, EncryptStorage needs to load it's base storage, so it also must be set up here
+
+const passphraseEnv = "PETRIFIC_PASSPHRASE"
+
+func encryptStorageFromConfig(conf config.Config, name string) (storage.Storage, error) {
+	var storage_conf struct {
+		Base           string
+		PassphraseFile string `toml:"passphrase_file,omitempty"`
+		HideTypes      bool   `toml:"hide_types,omitempty"`
+	}
+
+	if err := conf.GetStorageConfData(name, &storage_conf); err != nil {
+		return nil, err
+	}
+
+	var passphrase []byte
+	if storage_conf.PassphraseFile != "" {
+		var err error
+		if passphrase, err = ioutil.ReadFile(config.ExpandTilde(storage_conf.PassphraseFile)); err != nil {
+			return nil, err
+		}
+		passphrase = bytes.TrimRight(passphrase, "\r\n")
+	} else {
+		passphrase = []byte(os.Getenv(passphraseEnv))
+	}
+
+	if len(passphrase) == 0 {
+		return nil, errors.New("no passphrase given (set passphrase_file or the environment variable " + passphraseEnv + ")")
+	}
+
+	base, err := LoadStorage(conf, storage_conf.Base)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := encrypt.OpenEncryptStorage(base, passphrase, storage_conf.HideTypes)
+	if err != nil {
+		base.Close()
+		return nil, err
+	}
+
+	return st, nil
+}
@@ -0,0 +1,33 @@
+package registry
+
+import (
+	"code.laria.me/petrific/config"
+	"code.laria.me/petrific/storage"
+	"code.laria.me/petrific/storage/pack"
+)
+
+// Like FilterStorage, PackStorage needs to load it's base storage, so it also must be set up here
+
+func packStorageFromConfig(conf config.Config, name string) (storage.Storage, error) {
+	var storage_conf struct {
+		Base     string
+		PackSize int `toml:"pack_size,omitempty"`
+	}
+
+	if err := conf.GetStorageConfData(name, &storage_conf); err != nil {
+		return nil, err
+	}
+
+	base, err := LoadStorage(conf, storage_conf.Base)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := pack.OpenPackStorage(base, storage_conf.PackSize)
+	if err != nil {
+		base.Close()
+		return nil, err
+	}
+
+	return st, nil
+}
@@ -6,6 +6,7 @@ import (
 	"code.laria.me/petrific/storage/cloud"
 	"code.laria.me/petrific/storage/local"
 	"code.laria.me/petrific/storage/memory"
+	"code.laria.me/petrific/storage/sftp"
 	"errors"
 	"fmt"
 )
@@ -16,7 +17,12 @@ func getStorageTypes() map[string]storage.CreateStorageFromConfig {
 		"local":           local.LocalStorageFromConfig,
 		"memory":          memory.MemoryStorageFromConfig,
 		"filter":          filterStorageFromConfig,
+		"encrypt":         encryptStorageFromConfig,
+		"compress":        compressStorageFromConfig,
+		"pack":            packStorageFromConfig,
 		"openstack-swift": cloud.SwiftStorageCreator(),
+		"s3":              cloud.S3StorageCreator(),
+		"sftp":            sftp.SFTPStorageFromConfig,
 	}
 }
 
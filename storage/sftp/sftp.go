@@ -0,0 +1,240 @@
+// Package sftp provides a storage that saves objects on a remote host via SFTP
+package sftp
+
+import (
+	"bytes"
+	"code.laria.me/petrific/config"
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	pkgsftp "github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+)
+
+// The layout is the same as the one of local.LocalStorage, a directory can therefore be used with both storages.
+
+func objectDir(id objects.ObjectId) string {
+	return path.Join(string(id.Algo), hex.EncodeToString(id.Sum[0:1]))
+}
+
+func objectPath(id objects.ObjectId) string {
+	return path.Join(objectDir(id), hex.EncodeToString(id.Sum[1:]))
+}
+
+// SFTPStorage is a storage implementation that saves your objects on a remote host using SFTP.
+// The objects are stored in the same layout as local.LocalStorage would.
+//
+// Example config:
+//
+//     [storage.remote]
+//     method="sftp"
+//     host="backup.example.com" # host[:port], port defaults to 22
+//     user="petrific"
+//     key_file="~/.ssh/id_ed25519"
+//     path="/srv/petrific" # Save the objects here
+//     known_hosts="~/.ssh/known_hosts" # optional, this is the default
+type SFTPStorage struct {
+	Path   string
+	client *pkgsftp.Client
+	conn   io.Closer
+	index  storage.Index
+}
+
+type SFTPConfig struct {
+	Host       string `toml:"host"`
+	User       string `toml:"user"`
+	KeyFile    string `toml:"key_file"`
+	Path       string `toml:"path"`
+	KnownHosts string `toml:"known_hosts,omitempty"`
+}
+
+func SFTPStorageFromConfig(conf config.Config, name string) (storage.Storage, error) {
+	var storage_conf SFTPConfig
+
+	if err := conf.GetStorageConfData(name, &storage_conf); err != nil {
+		return nil, err
+	}
+
+	if storage_conf.Host == "" || storage_conf.User == "" || storage_conf.KeyFile == "" || storage_conf.Path == "" {
+		return nil, errors.New("host, user, key_file and path must be set")
+	}
+
+	conn, err := dial(storage_conf)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := pkgsftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	st, err := OpenSFTPStorage(client, storage_conf.Path)
+	if err != nil {
+		client.Close()
+		conn.Close()
+		return nil, err
+	}
+
+	st.conn = conn
+	return st, nil
+}
+
+func dial(conf SFTPConfig) (*ssh.Client, error) {
+	key, err := ioutil.ReadFile(config.ExpandTilde(conf.KeyFile))
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse key %s: %s", conf.KeyFile, err)
+	}
+
+	known_hosts := conf.KnownHosts
+	if known_hosts == "" {
+		known_hosts = "~/.ssh/known_hosts"
+	}
+
+	host_key_callback, err := knownhosts.New(config.ExpandTilde(known_hosts))
+	if err != nil {
+		return nil, err
+	}
+
+	addr := conf.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+
+	return ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            conf.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: host_key_callback,
+	})
+}
+
+// OpenSFTPStorage opens a storage in the directory p using an already connected SFTP client.
+// The client will be closed, when the storage is closed.
+func OpenSFTPStorage(client *pkgsftp.Client, p string) (s SFTPStorage, err error) {
+	s.Path = p
+	s.client = client
+	s.index = storage.NewIndex()
+
+	if fi, err := client.Stat(p); os.IsNotExist(err) {
+		if err := client.MkdirAll(p); err != nil {
+			return s, err
+		}
+	} else if err != nil {
+		return s, err
+	} else if !fi.IsDir() {
+		return s, fmt.Errorf("%s: Not a directory", p)
+	}
+
+	f, err := client.Open(path.Join(p, "index"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return s, err
+	}
+	defer f.Close()
+
+	err = s.index.Load(f)
+	return
+}
+
+func (s SFTPStorage) Get(id objects.ObjectId) ([]byte, error) {
+	f, err := s.client.Open(path.Join(s.Path, objectPath(id)))
+	if os.IsNotExist(err) {
+		return []byte{}, storage.ObjectNotFound
+	} else if err != nil {
+		return []byte{}, err
+	}
+	defer f.Close()
+
+	buf := new(bytes.Buffer)
+	_, err = f.WriteTo(buf)
+	return buf.Bytes(), err
+}
+
+func (s SFTPStorage) Has(id objects.ObjectId) (bool, error) {
+	_, err := s.client.Stat(path.Join(s.Path, objectPath(id)))
+	if err == nil {
+		return true, nil
+	} else if os.IsNotExist(err) {
+		return false, nil
+	} else {
+		return false, err
+	}
+}
+
+func (s SFTPStorage) Set(id objects.ObjectId, typ objects.ObjectType, raw []byte) error {
+	if err := s.client.MkdirAll(path.Join(s.Path, objectDir(id))); err != nil {
+		return err
+	}
+
+	f, err := s.client.Create(path.Join(s.Path, objectPath(id)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(raw)
+	s.index.Set(id, typ)
+	return err
+}
+
+func (s SFTPStorage) List(typ objects.ObjectType) ([]objects.ObjectId, error) {
+	return s.index.List(typ), nil
+}
+
+func (s SFTPStorage) Delete(id objects.ObjectId) error {
+	err := s.client.Remove(path.Join(s.Path, objectPath(id)))
+	if os.IsNotExist(err) {
+		return storage.ObjectNotFound
+	} else if err != nil {
+		return err
+	}
+
+	s.index.Delete(id)
+	return nil
+}
+
+func (SFTPStorage) Subcmds() map[string]storage.StorageSubcmd {
+	return make(map[string]storage.StorageSubcmd)
+}
+
+func (s SFTPStorage) saveIndex() error {
+	f, err := s.client.Create(path.Join(s.Path, "index"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return s.index.Save(f)
+}
+
+func (s SFTPStorage) Close() error {
+	err := s.saveIndex()
+
+	if cerr := s.client.Close(); err == nil {
+		err = cerr
+	}
+
+	if s.conn != nil {
+		if cerr := s.conn.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
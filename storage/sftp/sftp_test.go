@@ -0,0 +1,101 @@
+package sftp
+
+import (
+	"bytes"
+	"code.laria.me/petrific/backup"
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"code.laria.me/petrific/storage/local"
+	pkgsftp "github.com/pkg/sftp"
+	"io"
+	"testing"
+)
+
+// connect starts an in-process SFTP server (serving the local filesystem) and returns a client connected to it
+func connect(t *testing.T) *pkgsftp.Client {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+
+	server, err := pkgsftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		server.Serve()
+		sw.Close() // Lets the client know the connection is closed
+	}()
+
+	client, err := pkgsftp.NewClientPipe(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func TestSFTPStorage(t *testing.T) {
+	dir := t.TempDir() + "/store"
+
+	st, err := OpenSFTPStorage(connect(t), dir)
+	if err != nil {
+		t.Fatalf("Could not open storage: %s", err)
+	}
+
+	content := []byte("Hello, World!")
+	file_id, err := backup.WriteFile(st, bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("WriteFile failed: %s", err)
+	}
+
+	if err := st.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	// Reopen, the index must be persisted
+	st, err = OpenSFTPStorage(connect(t), dir)
+	if err != nil {
+		t.Fatalf("Could not reopen storage: %s", err)
+	}
+	defer st.Close()
+
+	ids, err := st.List(objects.OTFile)
+	if err != nil || len(ids) != 1 || !ids[0].Equals(file_id) {
+		t.Errorf("Unexpected List result: %v, %v", ids, err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := backup.RestoreFile(st, file_id, buf); err != nil {
+		t.Fatalf("RestoreFile failed: %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), content) {
+		t.Errorf("Unexpected content: %s", buf.Bytes())
+	}
+
+	// The directory must be usable by the local storage
+	ls, err := local.OpenLocalStorage(dir)
+	if err != nil {
+		t.Fatalf("Could not open directory as local storage: %s", err)
+	}
+	if ids, _ := ls.List(objects.OTFile); len(ids) != 1 {
+		t.Errorf("local storage lists %d files, want 1", len(ids))
+	}
+	if _, err := storage.GetObjectOfType(ls, file_id, objects.OTFile); err != nil {
+		t.Errorf("Could not get file from local storage: %s", err)
+	}
+
+	if err := st.Delete(file_id); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+	if ok, err := st.Has(file_id); err != nil || ok {
+		t.Errorf("Has after Delete = %t, %v", ok, err)
+	}
+	if err := st.Delete(file_id); err != storage.ObjectNotFound {
+		t.Errorf("Deleting a missing object returned %v", err)
+	}
+	if _, err := st.Get(file_id); err != storage.ObjectNotFound {
+		t.Errorf("Getting a missing object returned %v", err)
+	}
+}
@@ -22,6 +22,7 @@ type Storage interface {
 	Has(id objects.ObjectId) (bool, error)
 	Set(id objects.ObjectId, typ objects.ObjectType, raw []byte) error
 	List(typ objects.ObjectType) ([]objects.ObjectId, error)
+	Delete(id objects.ObjectId) error // Must return ObjectNotFound, if the object doesn't exist
 
 	Subcmds() map[string]StorageSubcmd
 
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"code.laria.me/petrific/backup"
+	"code.laria.me/petrific/fs"
+	"code.laria.me/petrific/objects"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func VerifyDir(env *Env, args []string) int {
+	flags := flag.NewFlagSet(os.Args[0]+" verify-dir", flag.ContinueOnError)
+	archive := flags.String("archive", "", "compare against the latest trusted snapshot of this archive (instead of giving an id)")
+	asJSON := flags.Bool("json", false, "print one JSON object per difference")
+	getOpts := writeDirFlags(env, flags)
+
+	flags.Usage = subcmdUsage("verify-dir", "[flags] [snapshot-or-tree-id] directory\n\n"+
+		"Differences are printed like in the diff command, the snapshot being the old and the directory the new side.\n"+
+		"Exclude rules are applied like when taking a snapshot.\n"+
+		"Exits with status 1, if differences were found", flags)
+	errout := subcmdErrout(env.Log, "verify-dir")
+
+	if err := flags.Parse(args); err != nil {
+		errout(err)
+		return 2
+	}
+
+	args = flags.Args()
+
+	var tree_id objects.ObjectId
+	archive_name := *archive
+	if *archive != "" {
+		if len(args) != 1 {
+			flags.Usage()
+			return 2
+		}
+
+		snapshot, err := loadTrustedSnapshot(env, objects.ObjectId{}, *archive)
+		if err != nil {
+			errout(err)
+			return 1
+		}
+		tree_id = snapshot.Tree
+	} else {
+		if len(args) != 2 {
+			errout(errors.New("Either an id or -archive must be given"))
+			flags.Usage()
+			return 2
+		}
+
+		id, err := objects.ParseObjectId(args[0])
+		if err != nil {
+			errout(fmt.Errorf("invalid id %s: %s", args[0], err))
+			return 2
+		}
+
+		if tree_id, archive_name, err = treeOf(env, id); err != nil {
+			errout(err)
+			return 1
+		}
+		args = args[1:]
+	}
+
+	dir_path, err := abspath(args[0])
+	if err != nil {
+		errout(err)
+		return 1
+	}
+
+	d, err := fs.OpenOSFile(dir_path)
+	if err != nil {
+		errout(err)
+		return 1
+	}
+
+	if d.Type() != fs.FDir {
+		errout(fmt.Errorf("%s is not a directory", dir_path))
+		return 1
+	}
+
+	opts, err := getOpts(archive_name)
+	if err != nil {
+		errout(err)
+		return 1
+	}
+
+	print := printChange
+	if *asJSON {
+		print = printChangeJSON(json.NewEncoder(os.Stdout))
+	}
+
+	differences := 0
+	err = backup.VerifyDir(env.Store, dir_path, d, tree_id, env.Log, opts, func(c backup.Change) error {
+		differences++
+		return print(c)
+	})
+	if err != nil {
+		errout(err)
+		return 1
+	}
+
+	if differences > 0 {
+		return 1
+	}
+	return 0
+}
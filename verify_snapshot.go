@@ -0,0 +1,86 @@
+package main
+
+import (
+	"code.laria.me/petrific/objects"
+	"code.laria.me/petrific/storage"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func VerifySnapshot(env *Env, args []string) int {
+	flags := flag.NewFlagSet(os.Args[0]+" verify-snapshot", flag.ContinueOnError)
+	archive := flags.String("archive", "", "verify all snapshots of this archive")
+
+	flags.Usage = subcmdUsage("verify-snapshot", "[flags] [snapshot-id ...]\n\n"+
+		"Verifies the signatures of the given snapshots (or of all snapshots, if neither ids nor -archive are given)\n"+
+		"against the signature policy of their archives.", flags)
+	errout := subcmdErrout(env.Log, "verify-snapshot")
+
+	if err := flags.Parse(args); err != nil {
+		errout(err)
+		return 2
+	}
+
+	var snapshots sortableSnapshots
+	if flags.NArg() > 0 {
+		if *archive != "" {
+			flags.Usage()
+			return 2
+		}
+
+		for _, arg := range flags.Args() {
+			id, err := objects.ParseObjectId(arg)
+			if err != nil {
+				errout(fmt.Errorf("invalid id %s: %s", arg, err))
+				return 2
+			}
+
+			snapshot, err := storage.GetObjectOfType(env.Store, id, objects.OTSnapshot)
+			if err != nil {
+				errout(fmt.Errorf("could not get snapshot %s: %s", id, err))
+				return 1
+			}
+			snapshots = append(snapshots, snapshotWithId{id, *snapshot.(*objects.Snapshot)})
+		}
+	} else {
+		var err error
+		if snapshots, err = getSnapshots(env, *archive); err != nil {
+			errout(err)
+			return 1
+		}
+	}
+
+	verifyers, err := env.Conf.Verifyers()
+	if err != nil {
+		errout(err)
+		return 1
+	}
+
+	rejected := false
+	for _, s := range snapshots {
+		fmt.Printf("%s\t%s\t%s\n", s.id, s.snapshot.Archive, s.snapshot.Date)
+
+		si, err := env.Conf.SignaturePolicy(s.snapshot.Archive).Check(s.snapshot, verifyers)
+		switch {
+		case err != nil && si.Fingerprint != "":
+			// Valid signature, but not by a trusted key
+			fmt.Printf("\tREJECTED: %s: %s\n", si, err)
+		case err != nil:
+			fmt.Printf("\tREJECTED: %s\n", err)
+		case !s.snapshot.Signed:
+			fmt.Printf("\tOK: unsigned\n")
+		default:
+			fmt.Printf("\tOK: %s\n", si)
+		}
+
+		if err != nil {
+			rejected = true
+		}
+	}
+
+	if rejected {
+		return 1
+	}
+	return 0
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"code.laria.me/petrific/backup"
 	"code.laria.me/petrific/fs"
+	"flag"
 	"fmt"
 	"os"
 	"path"
@@ -19,12 +20,67 @@ func abspath(p string) (string, error) {
 	return path.Clean(p), nil
 }
 
+// writeDirFlags adds the flags controlling what gets backed up to flags.
+// The returned function builds the backup.WriteDirOptions from the config (of the archive, if not empty)
+// and the flags, call it after parsing the flags.
+func writeDirFlags(env *Env, flags *flag.FlagSet) func(archive string) (backup.WriteDirOptions, error) {
+	exclude := new(stringList)
+	flags.Var(exclude, "exclude", "exclude paths matching this gitignore-style pattern (can be given multiple times)")
+	excludeCaches := flags.Bool("exclude-caches", false, "exclude the content of directories containing a CACHEDIR.TAG file")
+	maxFileSize := flags.Int64("max-file-size", 0, "exclude files larger than this many bytes")
+	noIgnoreFiles := flags.Bool("no-ignore-files", false, "don't read exclude rules from "+backup.DefaultIgnoreFileName+" files")
+	oneFileSystem := flags.Bool("one-file-system", false, "don't descend into directories on other filesystems")
+	allowMounts := new(stringList)
+	flags.Var(allowMounts, "allow-mount", "with -one-file-system: still back up this mount point (absolute path, can be given multiple times)")
+
+	return func(archive string) (backup.WriteDirOptions, error) {
+		opts, err := env.WriteDirOptions(archive)
+		if err != nil {
+			return opts, err
+		}
+
+		more_rules, err := backup.ParseIgnoreRules("/", *exclude)
+		if err != nil {
+			return opts, err
+		}
+		opts.Exclude = append(opts.Exclude, more_rules...)
+
+		opts.ExcludeCaches = opts.ExcludeCaches || *excludeCaches
+		if *maxFileSize > 0 {
+			opts.MaxFileSize = *maxFileSize
+		}
+		if *noIgnoreFiles {
+			opts.IgnoreFile = ""
+		}
+
+		opts.OneFileSystem = opts.OneFileSystem || *oneFileSystem
+		for _, mount := range *allowMounts {
+			mount, err := abspath(mount)
+			if err != nil {
+				return opts, err
+			}
+			opts.AllowedMounts = append(opts.AllowedMounts, mount)
+		}
+
+		return opts, nil
+	}
+}
+
 func WriteDir(env *Env, args []string) int {
-	usage := subcmdUsage("write-dir", "directory", nil)
+	flags := flag.NewFlagSet(os.Args[0]+" write-dir", flag.ContinueOnError)
+	getOpts := writeDirFlags(env, flags)
+
+	flags.Usage = subcmdUsage("write-dir", "[flags] directory", flags)
 	errout := subcmdErrout(env.Log, "write-dir")
 
+	if err := flags.Parse(args); err != nil {
+		errout(err)
+		return 2
+	}
+
+	args = flags.Args()
 	if len(args) != 1 {
-		usage()
+		flags.Usage()
 		return 2
 	}
 
@@ -45,7 +101,13 @@ func WriteDir(env *Env, args []string) int {
 		return 1
 	}
 
-	id, err := backup.WriteDir(env.Store, dir_path, d, env.IdCache, env.Log)
+	opts, err := getOpts("")
+	if err != nil {
+		errout(err)
+		return 1
+	}
+
+	id, err := backup.WriteDir(env.Store, dir_path, d, env.IdCache, env.Log, opts)
 	if err != nil {
 		errout(err)
 		return 1